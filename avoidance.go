@@ -0,0 +1,491 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+avoidance.go
+This file implements the avoidance of known deadlocks at runtime, based on
+UNDEAD. A deadlock is known by its signature, which consists of the outer
+acquisitions of the cycle, i.e. for every edge of the cycle the class of the
+held lock which is acquired by the next edge (the position where it was
+created) and the position where it was acquired. Every signature has a gate.
+A routine has to pass the gate before it can make an outer acquisition of
+the signature and holds it until the lock is released. Therefore at most one
+routine can be inside the cycle at the same time and the deadlock can not
+occur. The gates are ordered by their creation and a routine never waits for
+a gate which is lower than a gate it already passed, so that the routines
+can not block each other on the gates alone. If such a gate is busy, the
+deadlock is not avoided for the acquisition and the bypass is reported. Routines which wait for a gate
+are part of the wait-for graph of the periodical detection.
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petermattis/goid"
+)
+
+// gate of a signature
+type avoidanceGate struct {
+	// lock to protect the gate
+	lock sync.Mutex
+	// condition to wait for the gate to be free
+	free *sync.Cond
+	// position of the gate in the global order of the gates
+	order uint64
+	// id of the go routine which is inside the gate
+	owner int64
+	// number of outer acquisitions of the owner which are inside the gate
+	depth int
+	// time at which the owner entered the gate
+	since time.Time
+}
+
+// gate passed by a routine when acquiring a lock
+type avoidanceHold struct {
+	// id of the go routine
+	goID int64
+	// passed gates
+	gates []*avoidanceGate
+}
+
+// newAvoidanceGate creates a free gate
+//  Args:
+//   order (uint64): position of the gate in the global order of the gates
+//  Returns:
+//   (*avoidanceGate): the gate
+func newAvoidanceGate(order uint64) *avoidanceGate {
+	g := &avoidanceGate{order: order}
+	g.free = sync.NewCond(&g.lock)
+	return g
+}
+
+// enter waits until the gate is free or already owned by the routine and
+// enters it
+//  Args:
+//   id (int64): id of the go routine
+//  Returns:
+//   nil
+func (g *avoidanceGate) enter(id int64) {
+	g.lock.Lock()
+	for g.depth > 0 && g.owner != id {
+		g.free.Wait()
+	}
+	g.pass(id)
+	g.lock.Unlock()
+}
+
+// tryEnter enters the gate if it is free or already owned by the routine
+//  Args:
+//   id (int64): id of the go routine
+//  Returns:
+//   (bool): true if the routine entered the gate, false otherwise
+func (g *avoidanceGate) tryEnter(id int64) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.depth > 0 && g.owner != id {
+		return false
+	}
+	g.pass(id)
+	return true
+}
+
+// pass lets the routine pass the gate. g.lock must be held.
+//  Args:
+//   id (int64): id of the go routine
+//  Returns:
+//   nil
+func (g *avoidanceGate) pass(id int64) {
+	if g.depth == 0 {
+		g.since = time.Now()
+	}
+	g.owner = id
+	g.depth++
+}
+
+// getOwner returns the routine which is inside the gate
+//  Returns:
+//   (int64): id of the go routine, 0 if the gate is free
+//   (time.Time): time at which the routine entered the gate
+func (g *avoidanceGate) getOwner() (int64, time.Time) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.depth == 0 {
+		return 0, time.Time{}
+	}
+	return g.owner, g.since
+}
+
+// leave leaves the gate. If the owner has left all outer acquisitions, the
+// gate is free again.
+//  Returns:
+//   nil
+func (g *avoidanceGate) leave() {
+	g.lock.Lock()
+	g.depth--
+	if g.depth <= 0 {
+		g.depth = 0
+		g.owner = 0
+		g.free.Broadcast()
+	}
+	g.lock.Unlock()
+}
+
+// avoidanceKey returns the key of an outer acquisition
+//  Args:
+//   class (CallSite): position where the lock was created
+//   site (CallSite): position where the lock was acquired
+//  Returns:
+//   (string): the key
+func avoidanceKey(class CallSite, site CallSite) string {
+	return callSiteKey(class) + "@" + callSiteKey(site)
+}
+
+// avoidanceSignature calculates the signature of the cycle of a report for
+// the avoidance, which consists of the outer acquisitions of the cycle
+//  Args:
+//   r (*Report): the report
+//  Returns:
+//   ([]string): sorted keys of the outer acquisitions of the cycle, nil if
+//    the report is not a cycle
+func avoidanceSignature(r *Report) []string {
+	if r.Kind != KindPotentialDeadlock && r.Kind != KindDeadlock {
+		return nil
+	}
+
+	keys := make([]string, 0, len(r.Edges))
+	for i := range r.Edges {
+		if held := r.cycleHeld(i); held != nil {
+			keys = append(keys, avoidanceKey(r.lock(held.Lock).Created, held.Acquired))
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// addAvoidanceSignature adds the signature of the cycle of a report to the
+// known signatures, if the avoidance is enabled
+//  Args:
+//   r (*Report): the report
+//  Returns:
+//   nil
+func (d *Detector) addAvoidanceSignature(r *Report) {
+	if !d.opts.deadlockAvoidance {
+		return
+	}
+
+	keys := avoidanceSignature(r)
+	if keys == nil {
+		return
+	}
+	signature := strings.Join(keys, ",")
+
+	d.avoidanceLock.Lock()
+	defer d.avoidanceLock.Unlock()
+
+	if _, ok := d.avoidanceSignatures[signature]; ok {
+		return
+	}
+
+	// the new gate is the newest gate, so appending it keeps the gates of
+	// every outer acquisition in the global order
+	d.avoidanceGateCount++
+	gate := newAvoidanceGate(d.avoidanceGateCount)
+	d.avoidanceSignatures[signature] = gate
+	for i, key := range keys {
+		// the same outer acquisition can appear multiple times in a signature
+		if i > 0 && keys[i-1] == key {
+			continue
+		}
+		d.avoidanceGates[key] = append(d.avoidanceGates[key], gate)
+	}
+}
+
+// LoadAvoidanceSignatures reads reports in the JSON format of the JSON output,
+// one report per line, and adds the signatures of the cycles to the known
+// signatures. Reports which are not cycles are ignored.
+// The avoidance must be enabled with SetDeadlockAvoidance.
+//  Args:
+//   r (io.Reader): reader with the reports
+//  Returns:
+//   (error): error if a report could not be read
+func (d *Detector) LoadAvoidanceSignatures(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var report Report
+		if err := json.Unmarshal([]byte(line), &report); err != nil {
+			return fmt.Errorf("could not read report: %w", err)
+		}
+		d.addAvoidanceSignature(&report)
+	}
+	return scanner.Err()
+}
+
+// LoadAvoidanceSignatures calls Detector.LoadAvoidanceSignatures of the
+// default detector
+//  Args:
+//   r (io.Reader): reader with the reports
+//  Returns:
+//   (error): error if a report could not be read
+func LoadAvoidanceSignatures(r io.Reader) error {
+	return defaultDetector.LoadAvoidanceSignatures(r)
+}
+
+// enterAvoidanceGates passes all gates of the signatures for which the
+// acquisition of m is an outer acquisition. It must be called directly by
+// lockInt before the lock is acquired.
+// The gates are passed in their global order. A gate which is lower than a
+// gate the routine already passed for a held lock is only passed if it is
+// free, because two routines could otherwise block each other on the gates.
+// If it is busy, the acquisition is not serialized and the bypass is
+// reported.
+//  Args:
+//   m (mutexInt): the lock which is acquired
+//   rLock (bool): true if m is acquired as r-lock
+//   info (callerInfo): position of the acquisition
+//  Returns:
+//   nil
+func (d *Detector) enterAvoidanceGates(m mutexInt, rLock bool, info callerInfo) {
+	if !d.opts.deadlockAvoidance {
+		return
+	}
+
+	d.avoidanceLock.RLock()
+	empty := len(d.avoidanceGates) == 0
+	d.avoidanceLock.RUnlock()
+	if empty {
+		return
+	}
+
+	// get the class of the lock and the position of the acquisition
+	class := CallSite{}
+	for _, c := range *m.getContext() {
+		if c.create {
+			class = CallSite{File: c.file, Line: c.line}
+			break
+		}
+	}
+	_, file, line, _ := runtime.Caller(3)
+	key := avoidanceKey(class, CallSite{File: file, Line: line})
+
+	id := goid.Get()
+
+	// the highest passed gate is only needed if the acquisition has gates
+	d.avoidanceLock.RLock()
+	gates := d.avoidanceGates[key]
+	highest := uint64(0)
+	if len(gates) > 0 {
+		highest = d.highestAvoidanceGate(id)
+	}
+	d.avoidanceLock.RUnlock()
+	if len(gates) == 0 {
+		return
+	}
+
+	// if the routine leaves with a panic because of the policy while it
+	// waits for a gate, it leaves the gates it already passed
+	passed := make([]*avoidanceGate, 0, len(gates))
+	done := false
+	defer func() {
+		if !done {
+			for i := len(passed) - 1; i >= 0; i-- {
+				passed[i].leave()
+			}
+		}
+	}()
+
+	for _, g := range gates {
+		if g.order < highest {
+			if !g.tryEnter(id) {
+				d.reportAvoidanceBypass(g, key, id)
+				continue
+			}
+		} else {
+			d.waitAvoidanceGate(g, m, rLock, id, info)
+		}
+		passed = append(passed, g)
+	}
+	done = true
+
+	if len(passed) == 0 {
+		return
+	}
+
+	d.avoidanceLock.Lock()
+	pos := m.getMemoryPosition()
+	d.avoidanceHolds[pos] = append(d.avoidanceHolds[pos], avoidanceHold{
+		goID:  id,
+		gates: passed,
+	})
+	d.avoidanceLock.Unlock()
+}
+
+// reportAvoidanceBypass reports an acquisition which is not serialized by a
+// gate, because the gate is owned by another routine and is lower than a gate
+// the routine already passed. Waiting for the gate could block the routines
+// on the gates, so the known deadlock can occur in this case. The bypass is
+// counted and printed the first time for every outer acquisition.
+//  Args:
+//   g (*avoidanceGate): the gate
+//   key (string): the outer acquisition
+//   id (int64): id of the go routine
+//  Returns:
+//   nil
+func (d *Detector) reportAvoidanceBypass(g *avoidanceGate, key string, id int64) {
+	d.avoidanceLock.Lock()
+	d.avoidanceBypasses[key]++
+	first := d.avoidanceBypasses[key] == 1
+	d.avoidanceLock.Unlock()
+	if !first {
+		return
+	}
+
+	owner, _ := g.getOwner()
+	fmt.Fprintf(os.Stderr, "Deadlock-Go: the known deadlock of the acquisition %s is not avoided, "+
+		"because its gate is owned by goroutine %d and goroutine %d already passed a later gate\n",
+		key, owner, id)
+}
+
+// highestAvoidanceGate returns the order of the highest gate a routine has
+// passed for the locks it holds. avoidanceLock must be held at least for
+// reading.
+//  Args:
+//   id (int64): id of the go routine
+//  Returns:
+//   (uint64): order of the highest gate, 0 if the routine did not pass a gate
+func (d *Detector) highestAvoidanceGate(id int64) uint64 {
+	highest := uint64(0)
+	for _, holds := range d.avoidanceHolds {
+		for _, h := range holds {
+			if h.goID != id {
+				continue
+			}
+			for _, g := range h.gates {
+				if g.order > highest {
+					highest = g.order
+				}
+			}
+		}
+	}
+	return highest
+}
+
+// waitAvoidanceGate enters the gate g and waits until it is free. While the
+// routine waits, it is registered as waiting for m in the wait-for graph,
+// with the routine inside the gate as holder.
+//  Args:
+//   g (*avoidanceGate): the gate
+//   m (mutexInt): the lock which is acquired after the gate
+//   rLock (bool): true if m is acquired as r-lock
+//   id (int64): id of the go routine
+//   info (callerInfo): position of the acquisition
+//  Returns:
+//   nil
+func (d *Detector) waitAvoidanceGate(g *avoidanceGate, m mutexInt, rLock bool,
+	id int64, info callerInfo) {
+	if g.tryEnter(id) {
+		return
+	}
+
+	if d.trackLockWaits() {
+		w := d.startLockWait(m, rLock, id, info, g)
+		defer d.endLockWait(w)
+	}
+
+	g.enter(id)
+}
+
+// leaveAvoidanceGates leaves the gates which were passed when m was acquired
+// by the calling routine. Because a lock can be released by another routine
+// than the one which acquired it, the gates of the oldest acquisition are
+// left if the calling routine did not pass a gate for m.
+//  Args:
+//   m (mutexInt): the lock which is released
+//  Returns:
+//   nil
+func (d *Detector) leaveAvoidanceGates(m mutexInt) {
+	if !d.opts.deadlockAvoidance {
+		return
+	}
+
+	// most locks are acquired without passing a gate
+	pos := m.getMemoryPosition()
+	d.avoidanceLock.RLock()
+	empty := len(d.avoidanceHolds[pos]) == 0
+	d.avoidanceLock.RUnlock()
+	if empty {
+		return
+	}
+
+	d.avoidanceLock.Lock()
+	holds := d.avoidanceHolds[pos]
+	if len(holds) == 0 {
+		d.avoidanceLock.Unlock()
+		return
+	}
+
+	id := goid.Get()
+	index := 0
+	for i, h := range holds {
+		if h.goID == id {
+			index = i
+			break
+		}
+	}
+	hold := holds[index]
+	holds = append(holds[:index], holds[index+1:]...)
+	if len(holds) == 0 {
+		delete(d.avoidanceHolds, pos)
+	} else {
+		d.avoidanceHolds[pos] = holds
+	}
+	d.avoidanceLock.Unlock()
+
+	for i := len(hold.gates) - 1; i >= 0; i-- {
+		hold.gates[i].leave()
+	}
+}
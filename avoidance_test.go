@@ -0,0 +1,278 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+avoidance_test.go
+Tests for the avoidance of known deadlocks.
+*/
+
+import (
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// acquisitions at two different positions, each function is a single line,
+// so that the position of the function is the position of the acquisition
+func lockFirst(m *Mutex)  { m.Lock() }
+func lockSecond(m *Mutex) { m.Lock() }
+
+// addGate adds a gate for the outer acquisition of locks of the class of m
+// at the position of the function acquire
+//  Args:
+//   d (*Detector): the detector
+//   m (*Mutex): lock of the class
+//   acquire (func(*Mutex)): function which acquires the lock
+//  Returns:
+//   (*avoidanceGate): the gate
+func addGate(d *Detector, m *Mutex, acquire func(*Mutex)) *avoidanceGate {
+	class := CallSite{}
+	for _, c := range *m.getContext() {
+		if c.create {
+			class = CallSite{File: c.file, Line: c.line}
+		}
+	}
+	file, line := runtime.FuncForPC(reflect.ValueOf(acquire).Pointer()).FileLine(
+		reflect.ValueOf(acquire).Pointer())
+	key := avoidanceKey(class, CallSite{File: file, Line: line})
+
+	d.avoidanceLock.Lock()
+	defer d.avoidanceLock.Unlock()
+	d.avoidanceGateCount++
+	g := newAvoidanceGate(d.avoidanceGateCount)
+	d.avoidanceGates[key] = append(d.avoidanceGates[key], g)
+	return g
+}
+
+// waitUntil waits until cond is true or fails the test after 5 seconds
+//  Args:
+//   t (*testing.T): the test
+//   cond (func() bool): the condition
+//  Returns:
+//   nil
+func waitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Two routines which pass gates of different signatures in nested
+// acquisitions must not block each other on the gates.
+func TestAvoidanceGateOrder(t *testing.T) {
+	d := NewDetector()
+	d.SetDeadlockAvoidance(true)
+	deadlocks := interceptDeadlocks(t, d)
+
+	// all locks have the same class
+	locks := make([]*Mutex, 4)
+	for i := range locks {
+		locks[i] = d.NewLock()
+	}
+	first := addGate(d, locks[0], lockFirst)
+	second := addGate(d, locks[0], lockSecond)
+
+	inFirst := make(chan struct{})
+	inSecond := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		lockFirst(locks[0])
+		close(inFirst)
+		<-inSecond
+		// waits for the second gate, which is higher than the first
+		lockSecond(locks[1])
+		locks[1].Unlock()
+		locks[0].Unlock()
+		done <- struct{}{}
+	}()
+
+	go func() {
+		<-inFirst
+		lockSecond(locks[2])
+		close(inSecond)
+		waitUntil(t, func() bool {
+			owner, _ := second.getOwner()
+			return owner != 0 && len(d.State().Waits) == 1
+		})
+		// the first gate is lower than the second gate and is owned by the
+		// other routine, so it is not passed
+		lockFirst(locks[3])
+		locks[3].Unlock()
+		locks[2].Unlock()
+		done <- struct{}{}
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the routines block each other on the gates")
+		}
+	}
+
+	if owner, _ := first.getOwner(); owner != 0 {
+		t.Error("the first gate was not left")
+	}
+	if owner, _ := second.getOwner(); owner != 0 {
+		t.Error("the second gate was not left")
+	}
+	if errs := deadlocks(); len(errs) != 0 {
+		t.Fatalf("unexpected deadlocks: %v", errs)
+	}
+}
+
+// A routine which waits for a gate is part of the wait-for graph, so that a
+// deadlock of a gate and a lock is detected.
+func TestAvoidanceGateWaitCycle(t *testing.T) {
+	d := NewDetector()
+	d.SetDeadlockAvoidance(true)
+
+	x := d.NewLock()
+	locks := make([]*Mutex, 2)
+	for i := range locks {
+		locks[i] = d.NewLock()
+	}
+	addGate(d, locks[0], lockFirst)
+
+	// the routine which closes the cycle releases its locks and thereby
+	// leaves the gate
+	var lock sync.Mutex
+	errs := make([]*DeadlockError, 0)
+	t.Cleanup(d.InterceptDeadlocks(func(err *DeadlockError) {
+		lock.Lock()
+		errs = append(errs, err)
+		lock.Unlock()
+		d.ReleaseLocks()
+	}))
+
+	inGate := make(chan struct{})
+	holding := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		lockFirst(locks[1])
+		close(inGate)
+		<-holding
+		waitUntil(t, func() bool {
+			return len(d.State().Waits) == 1
+		})
+		x.Lock()
+		x.Unlock()
+		locks[1].Unlock()
+	}()
+
+	<-inGate
+	x.Lock()
+	close(holding)
+	// waits for the gate owned by the other routine, which waits for x
+	lockFirst(locks[0])
+	locks[0].Unlock()
+	x.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the deadlock was not resolved")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(errs) != 1 || errs[0].Report.Kind != KindDeadlock {
+		t.Fatalf("expected a deadlock, got %v", errs)
+	}
+}
+
+// A routine which already passed a higher gate does not wait for a busy lower
+// gate. The acquisition is not serialized by the lower gate and the bypass
+// is reported.
+func TestAvoidanceGateBypass(t *testing.T) {
+	d := NewDetector()
+	d.SetDeadlockAvoidance(true)
+	deadlocks := interceptDeadlocks(t, d)
+
+	// all locks have the same class
+	locks := make([]*Mutex, 3)
+	for i := range locks {
+		locks[i] = d.NewLock()
+	}
+	first := addGate(d, locks[0], lockFirst)
+	addGate(d, locks[0], lockSecond)
+
+	inFirst := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lockFirst(locks[0])
+		close(inFirst)
+		<-release
+		locks[0].Unlock()
+	}()
+	<-inFirst
+
+	acquired := make(chan struct{})
+	go func() {
+		defer close(acquired)
+		lockSecond(locks[1])
+		// the first gate is lower than the second gate, which the routine
+		// already passed, and is owned by the other routine
+		lockFirst(locks[2])
+		locks[2].Unlock()
+		locks[1].Unlock()
+	}()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the routine waits for the lower gate")
+	}
+	close(release)
+	<-done
+
+	d.avoidanceLock.RLock()
+	bypasses := 0
+	for _, n := range d.avoidanceBypasses {
+		bypasses += n
+	}
+	d.avoidanceLock.RUnlock()
+	if bypasses != 1 {
+		t.Fatalf("expected one reported bypass, got %d", bypasses)
+	}
+	if owner, _ := first.getOwner(); owner != 0 {
+		t.Error("the first gate was not left")
+	}
+	if errs := deadlocks(); len(errs) != 0 {
+		t.Fatalf("unexpected deadlocks: %v", errs)
+	}
+}
@@ -1,3 +1,5 @@
+//go:build !deadlock_off
+
 package deadlock
 
 /*
@@ -28,6 +30,12 @@ callerInfo.go
 Implementation of a struct to save the caller info of locks
 */
 
+import (
+	"runtime"
+	"strconv"
+	"strings"
+)
+
 // Type to save info about caller.
 // A caller is an instance where a lock was created or locked.
 type callerInfo struct {
@@ -39,6 +47,8 @@ type callerInfo struct {
 	create bool
 	// string to save the call stack
 	callStacks string
+	// name of the function, only set for the creation of routines
+	function string
 }
 
 // newInfo creates and returns a new callerInfo
@@ -56,3 +66,90 @@ func newInfo(file string, line int, create bool, callStack string) callerInfo {
 		callStacks: callStack,
 	}
 }
+
+// newAcquisitionInfo creates the callerInfo for the acquisition of a lock.
+// The call stack is only collected if the collection of call stacks is enabled.
+//  Args:
+//   skip (int): number of stack frames between the caller of
+//    newAcquisitionInfo and the code which acquired the lock
+//  Returns:
+//   callerInfo: the created callerInfo
+func (d *Detector) newAcquisitionInfo(skip int) callerInfo {
+	_, file, line, _ := runtime.Caller(skip + 1)
+
+	callStack := ""
+	if d.opts.collectCallStack {
+		callStack = d.getCallStack(skip + 1)
+	}
+
+	return newInfo(file, line, false, callStack)
+}
+
+// getCallStack returns the call stack of the calling routine without the
+// frames of the detector
+//  Args:
+//   skip (int): number of stack frames between the caller of getCallStack
+//    and the code which acquired the lock
+//  Returns:
+//   string: the call stack
+func (d *Detector) getCallStack(skip int) string {
+	buf := make([]byte, d.opts.maxCallStackSize)
+	n := runtime.Stack(buf[:], false)
+	bufStringSplit := strings.Split(string(buf[:n]), "\n")
+
+	// the first line is the header of the routine, every frame consists of
+	// two lines
+	bufStringCleaned := bufStringSplit[0] + "\n"
+	for i := 1 + 2*(skip+1); i < len(bufStringSplit); i++ {
+		bufStringCleaned += bufStringSplit[i] + "\n"
+	}
+	return bufStringCleaned
+}
+
+// equalPosition checks if two callerInfos describe the same position
+//  Args:
+//   other (callerInfo): callerInfo to compare with
+//  Returns:
+//   bool: true if file and line are equal, false otherwise
+func (c callerInfo) equalPosition(other callerInfo) bool {
+	return c.file == other.file && c.line == other.line
+}
+
+// getRoutineStacks returns the current call stacks of the go routines with
+// the given ids
+//  Args:
+//   ids ([]int64): ids of the go routines
+//  Returns:
+//   map[int64]string: the call stacks by the id of the go routine
+func getRoutineStacks(ids []int64) map[int64]string {
+	wanted := make(map[int64]struct{}, len(ids))
+	for _, id := range ids {
+		wanted[id] = struct{}{}
+	}
+
+	// get the call stacks of all go routines
+	buf := make([]byte, 1<<16)
+	n := runtime.Stack(buf, true)
+	for n == len(buf) && len(buf) < 1<<26 {
+		buf = make([]byte, 2*len(buf))
+		n = runtime.Stack(buf, true)
+	}
+
+	// the call stacks of the go routines are separated by empty lines and
+	// start with "goroutine <id> ["
+	stacks := make(map[int64]string, len(ids))
+	for _, stack := range strings.Split(string(buf[:n]), "\n\n") {
+		fields := strings.Fields(stack)
+		if len(fields) < 2 || fields[0] != "goroutine" {
+			continue
+		}
+		id, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if _, ok := wanted[id]; ok {
+			stacks[id] = strings.TrimRight(stack, "\n") + "\n"
+		}
+	}
+	return stacks
+}
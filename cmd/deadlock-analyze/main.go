@@ -0,0 +1,121 @@
+package main
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: main
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+main.go
+deadlock-analyze reads a lock trace recorded with SetTraceFile, rebuilds the
+lock trees of the routines and runs the comprehensive detection on them.
+
+Usage:
+
+	deadlock-analyze [flags] trace.jsonl
+
+If the trace is "-", it is read from stdin. The reports are printed to stderr
+and written to the outputs set by the flags.
+The program exits with 1 if the trace could not be analyzed.
+*/
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	deadlock "github.com/ErikKassubek/Deadlock-Go"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, "deadlock-analyze:", err)
+		os.Exit(1)
+	}
+}
+
+// run parses the flags and analyzes the trace. The files are closed before
+// run returns, so that main can exit with the error.
+//  Returns:
+//   (error): error if the trace could not be analyzed
+func run() error {
+	jsonFile := flag.String("json", "", "write the reports as JSON lines into `file`")
+	sarifFile := flag.String("sarif", "", "write the reports in the SARIF format into `file`")
+	htmlFile := flag.String("html", "", "write the reports as HTML page into `file`")
+	dotFile := flag.String("dot", "", "write the lock graph in the DOT format into `file`")
+	byClass := flag.Bool("class", false, "treat all locks created at the same position as one lock")
+	filter := flag.String("filter", "", "only analyze locks created in files matching `regexp`")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: deadlock-analyze [flags] trace.jsonl\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		return errors.New("expected exactly one trace")
+	}
+
+	options := deadlock.ReplayOptions{ByClass: *byClass}
+	if *filter != "" {
+		re, err := regexp.Compile(*filter)
+		if err != nil {
+			return fmt.Errorf("invalid filter: %w", err)
+		}
+		options.Filter = re
+	}
+
+	// the outputs must be set before the trace is replayed
+	deadlock.SetPeriodicDetection(false)
+	deadlock.SetJSONOutputFile(*jsonFile)
+	deadlock.SetSARIFOutputFile(*sarifFile)
+	deadlock.SetHTMLOutputFile(*htmlFile)
+
+	var in io.Reader = os.Stdin
+	if path := flag.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	if err := deadlock.ReplayTrace(in, options); err != nil {
+		return err
+	}
+
+	if *dotFile != "" {
+		out, err := os.Create(*dotFile)
+		if err != nil {
+			return err
+		}
+		if err := deadlock.WriteLockGraph(out, deadlock.GraphDOT); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: main
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+main.go
+deadlock-lockorder runs the static analysis of the lock order on the given
+packages and reports the found cycles as potential deadlocks.
+
+Usage:
+
+	deadlock-lockorder [flags] [packages]
+
+Without packages, the package in the current directory is analyzed. The
+reports are printed to stderr and written to the outputs set by the flags.
+The program exits with 1 if the packages could not be analyzed and with 2 if
+potential deadlocks were found.
+*/
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	deadlock "github.com/ErikKassubek/Deadlock-Go"
+	"github.com/ErikKassubek/Deadlock-Go/lockorder"
+)
+
+func main() {
+	jsonFile := flag.String("json", "", "write the reports as JSON lines into `file`")
+	tags := flag.String("tags", "", "comma separated list of build `tags`")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: deadlock-lockorder [flags] [packages]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	patterns := flag.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+
+	reports, err := lockorder.Analyze(patterns, lockorder.Options{Tags: *tags})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "deadlock-lockorder:", err)
+		os.Exit(1)
+	}
+
+	for _, r := range reports {
+		printReport(os.Stderr, r)
+	}
+
+	if *jsonFile != "" {
+		if err := writeJSON(*jsonFile, reports); err != nil {
+			fmt.Fprintln(os.Stderr, "deadlock-lockorder:", err)
+			os.Exit(1)
+		}
+	}
+
+	if len(reports) != 0 {
+		os.Exit(2)
+	}
+}
+
+// printReport prints a report in the format of the detector
+//  Args:
+//   w (io.Writer): writer to print to
+//   r (*deadlock.Report): the report
+//  Returns:
+//   nil
+func printReport(w io.Writer, r *deadlock.Report) {
+	fmt.Fprint(w, "POTENTIAL DEADLOCK (STATIC)\n\n")
+	fmt.Fprint(w, "Locks involved in potential deadlock:\n\n")
+	created := make(map[string]deadlock.CallSite)
+	for _, l := range r.Locks {
+		created[l.ID] = l.Created
+		fmt.Fprintf(w, "%s (%s) created at %s:%d\n", l.ID, l.Type, l.Created.File,
+			l.Created.Line)
+	}
+	fmt.Fprint(w, "\nAcquisitions involved in potential deadlock:\n\n")
+
+	for i, edge := range r.Edges {
+		fmt.Fprintf(w, "Acquisition %d\n", i)
+		for _, h := range edge.Holding {
+			fmt.Fprintf(w, "\tholding %s %s, acquired at %s:%d\n", lockMode(h.RLock),
+				h.Lock, h.Acquired.File, h.Acquired.Line)
+			printStack(w, h.Acquired.Stack)
+		}
+		fmt.Fprintf(w, "\tacquiring %s %s at %s:%d\n", lockMode(edge.RLock),
+			edge.Lock, edge.Acquired.File, edge.Acquired.Line)
+		printStack(w, edge.Acquired.Stack)
+		fmt.Fprintln(w, "")
+	}
+	fmt.Fprintln(w, "")
+}
+
+// printStack prints the call chain of an acquisition, if it is known
+//  Args:
+//   w (io.Writer): writer to print to
+//   stack (string): the call chain
+//  Returns:
+//   nil
+func printStack(w io.Writer, stack string) {
+	if stack == "" {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimRight(stack, "\n"), "\n") {
+		fmt.Fprintf(w, "\t\t%s\n", line)
+	}
+}
+
+// lockMode returns "r-lock" for r-locks and "lock" otherwise
+//  Args:
+//   rLock (bool): true for r-locks
+//  Returns:
+//   (string): the mode
+func lockMode(rLock bool) string {
+	if rLock {
+		return "r-lock"
+	}
+	return "lock"
+}
+
+// writeJSON writes the reports as JSON lines, like SetJSONOutputFile
+//  Args:
+//   path (string): path of the file
+//   reports ([]*deadlock.Report): the reports
+//  Returns:
+//   (error): error if the file could not be written
+func writeJSON(path string, reports []*deadlock.Report) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	enc := json.NewEncoder(file)
+	for _, r := range reports {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
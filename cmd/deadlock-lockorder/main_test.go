@@ -0,0 +1,77 @@
+package main
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: main
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+main_test.go
+Tests of the output of deadlock-lockorder
+*/
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ErikKassubek/Deadlock-Go/lockorder"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// The report of the inversion sample of lockorder matches the golden file.
+// The golden file is written with go test -update.
+func TestPrintReport(t *testing.T) {
+	dir, err := filepath.Abs(filepath.Join("..", "..", "lockorder", "testdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reports, err := lockorder.Analyze([]string{"./inversion"}, lockorder.Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	for _, r := range reports {
+		printReport(&b, r)
+	}
+	// the positions are relative to the samples
+	got := strings.ReplaceAll(b.String(), dir+string(filepath.Separator), "")
+
+	golden := filepath.Join("testdata", "inversion.golden")
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Fatalf("the output does not match %s:\n%s", golden, got)
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: main
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+main.go
+deadlock-toolexec instruments unmodified code, including dependencies, with
+Deadlock-Go while it is built. It is used as toolexec program of go build:
+
+	go build -toolexec="deadlock-toolexec [flags]" ./...
+
+Before a package is compiled, sync.Mutex and sync.RWMutex are replaced by
+the locks of Deadlock-Go in its source files. By default, all packages except
+the standard library are rewritten, -pkgs selects the packages. The main
+module has to require Deadlock-Go, because it is linked into the program.
+The program exits with 1 if a package could not be rewritten and with the
+exit code of the tool if the tool fails.
+*/
+
+import (
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// import path of Deadlock-Go
+const deadlockPath = "github.com/ErikKassubek/Deadlock-Go"
+
+// settings of the rewriting
+type toolexec struct {
+	// patterns of the packages to rewrite, all packages if empty
+	patterns []string
+	// build tags for Deadlock-Go
+	tags string
+	// the flags of deadlock-toolexec
+	flags []string
+}
+
+func main() {
+	flags := flag.NewFlagSet("deadlock-toolexec", flag.ExitOnError)
+	pkgs := flags.String("pkgs", "", "comma separated `patterns` of the packages to rewrite, e.g. example.com/lib/..., default: all")
+	tags := flags.String("tags", "", "build `tags` for Deadlock-Go, e.g. the tags of the build")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: go build -toolexec=\"deadlock-toolexec [flags]\" packages\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+
+	args := flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	t := toolexec{
+		tags:  *tags,
+		flags: os.Args[1 : len(os.Args)-len(args)],
+	}
+	if *pkgs != "" {
+		t.patterns = strings.Split(*pkgs, ",")
+	}
+
+	tool, toolArgs := args[0], args[1:]
+	name := strings.TrimSuffix(filepath.Base(tool), ".exe")
+
+	var err error
+	switch {
+	case (name == "compile" || name == "link") && len(toolArgs) == 1 && toolArgs[0] == "-V=full":
+		err = t.version(tool, toolArgs)
+		if err == nil {
+			return
+		}
+	case name == "compile":
+		toolArgs, err = t.compile(toolArgs)
+	case name == "link":
+		toolArgs, err = t.link(toolArgs)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "deadlock-toolexec:", err)
+		os.Exit(1)
+	}
+
+	run(tool, toolArgs)
+}
+
+// run runs a tool and exits with its exit code if it fails
+//  Args:
+//   tool (string): path of the tool
+//   args ([]string): arguments of the tool
+//  Returns:
+//   nil
+func run(tool string, args []string) {
+	cmd := exec.Command(tool, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		fmt.Fprintln(os.Stderr, "deadlock-toolexec:", err)
+		os.Exit(1)
+	}
+}
+
+// version prints the version of the tool. The version is used by go build
+// to identify the results in the build cache. It is extended by a hash of
+// deadlock-toolexec, its flags and Deadlock-Go, so that the rewritten
+// packages are cached separately.
+//  Args:
+//   tool (string): path of the tool
+//   args ([]string): arguments of the tool
+//  Returns:
+//   (error): error if the version could not be determined
+func (t *toolexec) version(tool string, args []string) error {
+	cmd := exec.Command(tool, args...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	h.Write(out)
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(exe)
+	if err != nil {
+		return err
+	}
+	h.Write(content)
+	fmt.Fprintln(h, strings.Join(t.flags, " "))
+
+	exports, err := t.listDeadlock(nil)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(exports))
+	for path := range exports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintln(h, path, exports[path])
+	}
+
+	fmt.Printf("%s +deadlock-toolexec buildID=_/_/_/%x\n",
+		strings.TrimSpace(string(out)), h.Sum(nil)[:16])
+	return nil
+}
+
+// compile rewrites the source files of a package before it is compiled
+//  Args:
+//   args ([]string): arguments of the compiler
+//  Returns:
+//   ([]string): arguments with the rewritten files
+//   (error): error if a file could not be rewritten
+func (t *toolexec) compile(args []string) ([]string, error) {
+	importPath := strings.Fields(os.Getenv("TOOLEXEC_IMPORTPATH") + " ")[0]
+	if importPath == "" {
+		importPath = flagValue(args, "-p")
+	}
+	if hasFlag(args, "-std") || isDeadlockPath(importPath) || !t.selected(importPath) {
+		return args, nil
+	}
+
+	// the source files are the last arguments, the rewritten files are
+	// written into the working directory of the package
+	first := len(args)
+	for first > 0 && strings.HasSuffix(args[first-1], ".go") {
+		first--
+	}
+	dir := filepath.Dir(flagValue(args, "-o"))
+
+	newArgs := append([]string{}, args...)
+	rewritten := false
+	for i := first; i < len(args); i++ {
+		src, err := rewriteFile(args[i])
+		if err != nil {
+			return nil, err
+		}
+		if src == nil {
+			continue
+		}
+		path := filepath.Join(dir, "deadlock_"+strconv.Itoa(i)+"_"+filepath.Base(args[i]))
+		if err := os.WriteFile(path, src, 0644); err != nil {
+			return nil, err
+		}
+		newArgs[i] = path
+		rewritten = true
+	}
+	if !rewritten {
+		return args, nil
+	}
+
+	exports, err := t.listDeadlock(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Deadlock-Go can not use itself
+	if _, ok := exports[importPath]; ok {
+		return args, nil
+	}
+
+	importcfg, err := extendImportcfg(flagValue(args, "-importcfg"), exports,
+		[]string{deadlockPath})
+	if err != nil {
+		return nil, err
+	}
+	setFlagValue(newArgs, "-importcfg", importcfg)
+	return newArgs, nil
+}
+
+// link adds Deadlock-Go and its dependencies to the packages of the linker
+//  Args:
+//   args ([]string): arguments of the linker
+//  Returns:
+//   ([]string): arguments with the extended import configuration
+//   (error): error if Deadlock-Go could not be built
+func (t *toolexec) link(args []string) ([]string, error) {
+	// if the main package imports Deadlock-Go, all packages are known
+	content, err := os.ReadFile(flagValue(args, "-importcfg"))
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(string(content), "packagefile "+deadlockPath+"=") {
+		return args, nil
+	}
+
+	exports, err := t.listDeadlock(args)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(exports))
+	for path := range exports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	importcfg, err := extendImportcfg(flagValue(args, "-importcfg"), exports, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	newArgs := append([]string{}, args...)
+	setFlagValue(newArgs, "-importcfg", importcfg)
+	return newArgs, nil
+}
+
+// listDeadlock builds Deadlock-Go and its dependencies in the main module
+//  Args:
+//   args ([]string): arguments of the tool, used for flags like -race which
+//    change the compiled packages. The packages have to be compiled with the
+//    same flags as in the build, otherwise they can not be linked.
+//  Returns:
+//   (map[string]string): the export files by the import paths
+//   (error): error if Deadlock-Go could not be built
+func (t *toolexec) listDeadlock(args []string) (map[string]string, error) {
+	listArgs := []string{"list", "-export", "-deps",
+		"-f", "{{if .Export}}{{.ImportPath}}={{.Export}}{{end}}"}
+	for _, f := range []string{"-race", "-msan", "-asan"} {
+		if hasFlag(args, f) {
+			listArgs = append(listArgs, f)
+		}
+	}
+	// with -trimpath, the compiler gets rules which replace the directories
+	// of the packages by their import paths
+	for _, rule := range strings.Split(flagValue(args, "-trimpath"), ";") {
+		if _, to, ok := strings.Cut(rule, "=>"); ok && to != "" {
+			listArgs = append(listArgs, "-trimpath")
+			break
+		}
+	}
+	if t.tags != "" {
+		listArgs = append(listArgs, "-tags", t.tags)
+	}
+	listArgs = append(listArgs, deadlockPath)
+
+	goCmd := "go"
+	if goroot := os.Getenv("GOROOT"); goroot != "" {
+		goCmd = filepath.Join(goroot, "bin", "go")
+	}
+
+	cmd := exec.Command(goCmd, listArgs...)
+	cmd.Stderr = os.Stderr
+	cmd.Env = listEnv()
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not build %s, the main module has to require it: %v",
+			deadlockPath, err)
+	}
+
+	exports := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		if path, file, ok := strings.Cut(line, "="); ok {
+			exports[path] = file
+		}
+	}
+	return exports, nil
+}
+
+// listEnv returns the environment for go list, in which go list does not
+// run deadlock-toolexec itself
+//  Returns:
+//   ([]string): the environment
+func listEnv() []string {
+	env := os.Environ()
+	for i, e := range env {
+		if !strings.HasPrefix(e, "GOFLAGS=") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(e, "GOFLAGS="))
+		kept := fields[:0]
+		for _, f := range fields {
+			if !strings.HasPrefix(f, "-toolexec") {
+				kept = append(kept, f)
+			}
+		}
+		env[i] = "GOFLAGS=" + strings.Join(kept, " ")
+	}
+	return env
+}
+
+// extendImportcfg writes a copy of an import configuration with additional
+// packages
+//  Args:
+//   path (string): path of the import configuration
+//   exports (map[string]string): the export files by the import paths
+//   packages ([]string): import paths of the packages to add, packages which
+//    are already in the configuration are not added
+//  Returns:
+//   (string): path of the new configuration
+//   (error): error if the configuration could not be read or written
+func extendImportcfg(path string, exports map[string]string, packages []string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	known := make(map[string]bool)
+	for _, line := range strings.Split(string(content), "\n") {
+		if pkg, _, ok := strings.Cut(strings.TrimPrefix(line, "packagefile "), "="); ok {
+			known[pkg] = true
+		}
+	}
+
+	cfg := string(content)
+	if !strings.HasSuffix(cfg, "\n") {
+		cfg += "\n"
+	}
+	for _, pkg := range packages {
+		if file, ok := exports[pkg]; ok && !known[pkg] {
+			cfg += fmt.Sprintf("packagefile %s=%s\n", pkg, file)
+		}
+	}
+
+	newPath := path + ".deadlock"
+	if err := os.WriteFile(newPath, []byte(cfg), 0644); err != nil {
+		return "", err
+	}
+	return newPath, nil
+}
+
+// selected checks if a package is selected by the patterns
+//  Args:
+//   importPath (string): import path of the package
+//  Returns:
+//   (bool): true if the package should be rewritten, false otherwise
+func (t *toolexec) selected(importPath string) bool {
+	if len(t.patterns) == 0 {
+		return true
+	}
+	for _, pattern := range t.patterns {
+		if prefix := strings.TrimSuffix(pattern, "/..."); prefix != pattern {
+			if importPath == prefix || strings.HasPrefix(importPath, prefix+"/") {
+				return true
+			}
+		} else if importPath == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// isDeadlockPath checks if a package is part of Deadlock-Go
+//  Args:
+//   importPath (string): import path of the package
+//  Returns:
+//   (bool): true if the package is part of Deadlock-Go, false otherwise
+func isDeadlockPath(importPath string) bool {
+	return importPath == deadlockPath || strings.HasPrefix(importPath, deadlockPath+"/")
+}
+
+// hasFlag checks if the arguments contain a boolean flag
+//  Args:
+//   args ([]string): the arguments
+//   name (string): name of the flag, e.g. "-race"
+//  Returns:
+//   (bool): true if the flag is set, false otherwise
+func hasFlag(args []string, name string) bool {
+	for _, arg := range args {
+		if arg == name || arg == name+"=true" {
+			return true
+		}
+	}
+	return false
+}
+
+// flagValue returns the value of a flag
+//  Args:
+//   args ([]string): the arguments
+//   name (string): name of the flag, e.g. "-o"
+//  Returns:
+//   (string): the value, "" if the flag is not set
+func flagValue(args []string, name string) string {
+	for i, arg := range args {
+		if arg == name && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(arg, name+"=") {
+			return strings.TrimPrefix(arg, name+"=")
+		}
+	}
+	return ""
+}
+
+// setFlagValue replaces the value of a flag
+//  Args:
+//   args ([]string): the arguments, changed in place
+//   name (string): name of the flag, e.g. "-importcfg"
+//   value (string): the new value
+//  Returns:
+//   nil
+func setFlagValue(args []string, name string, value string) {
+	for i, arg := range args {
+		if arg == name && i+1 < len(args) {
+			args[i+1] = value
+			return
+		}
+		if strings.HasPrefix(arg, name+"=") {
+			args[i] = name + "=" + value
+			return
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: main
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+
+/*
+main_test.go
+Tests which build a program with deadlock-toolexec
+*/
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// program with a potential deadlock on locks of the sync package
+const program = `package main
+
+import (
+	"sync"
+
+	deadlock "github.com/ErikKassubek/Deadlock-Go"
+)
+
+type account struct {
+	mu sync.Mutex
+}
+
+func main() {
+	var a, b account
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		a.mu.Lock()
+		b.mu.Lock()
+		b.mu.Unlock()
+		a.mu.Unlock()
+		wg.Done()
+	}()
+	wg.Wait()
+
+	wg.Add(1)
+	go func() {
+		b.mu.Lock()
+		a.mu.Lock()
+		a.mu.Unlock()
+		b.mu.Unlock()
+		wg.Done()
+	}()
+	wg.Wait()
+
+	deadlock.FindPotentialDeadlocks()
+}
+`
+
+// runGo runs the go command in dir and fails the test if it fails
+//  Args:
+//   t (*testing.T): the test
+//   dir (string): working directory
+//   args (...string): arguments of the go command
+//  Returns:
+//   nil
+func runGo(t *testing.T, dir string, args ...string) {
+	t.Helper()
+
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	// the build must not download modules
+	cmd.Env = append(os.Environ(), "GOPROXY=off", "GOFLAGS=-mod=mod", "GOWORK=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+// The locks of the sync package in a program built with deadlock-toolexec
+// are replaced by the locks of Deadlock-Go, so the potential deadlock of the
+// program is reported.
+func TestBuildReportsDeadlock(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a program")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+
+	root, err := filepath.Abs(filepath.Join("..", ".."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	goMod, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	goSum, err := os.ReadFile(filepath.Join(root, "go.sum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	tool := filepath.Join(dir, "deadlock-toolexec")
+	runGo(t, ".", "build", "-o", tool, ".")
+
+	// the program requires the dependencies of Deadlock-Go from this tree
+	app := filepath.Join(dir, "app")
+	mod := "module example.com/app\n\ngo 1.18\n\n" +
+		"require " + deadlockPath + " v0.0.0\n\n" +
+		"replace " + deadlockPath + " => " + root + "\n"
+	for _, line := range strings.Split(string(goMod), "\n") {
+		if strings.HasPrefix(line, "require ") {
+			mod += line + "\n"
+		}
+	}
+	files := map[string]string{
+		"go.mod":  mod,
+		"go.sum":  string(goSum),
+		"main.go": program,
+	}
+	if err := os.Mkdir(app, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(app, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bin := filepath.Join(dir, "app.bin")
+	runGo(t, app, "build", "-toolexec", tool+" -pkgs=example.com/app", "-o", bin, ".")
+
+	out, err := exec.Command(bin).CombinedOutput()
+	if err != nil {
+		t.Fatalf("the program failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "POTENTIAL DEADLOCK") {
+		t.Fatalf("the potential deadlock was not reported:\n%s", out)
+	}
+}
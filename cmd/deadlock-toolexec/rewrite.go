@@ -0,0 +1,142 @@
+package main
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: main
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+rewrite.go
+This file implements the rewriting of the source files. sync.Mutex and
+sync.RWMutex are replaced by the locks of Deadlock-Go. The lines of the file
+are not changed, so that the positions in the reports are the positions in
+the original file.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// name of the import of Deadlock-Go in the rewritten files
+const importName = "__deadlock"
+
+// rewriteFile rewrites a source file. If the file uses sync.Mutex or
+// sync.RWMutex, the types are replaced by the types of Deadlock-Go.
+//  Args:
+//   path (string): path of the file
+//  Returns:
+//   ([]byte): the rewritten file, nil if the file does not need to be
+//    rewritten
+//   (error): error if the file could not be read or parsed
+func rewriteFile(path string) ([]byte, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, src, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// name of the sync package in the file, dot and blank imports are ignored
+	syncName := ""
+	for _, imp := range file.Imports {
+		importPath, err := strconv.Unquote(imp.Path.Value)
+		if err != nil || importPath != "sync" {
+			continue
+		}
+		if imp.Name == nil {
+			syncName = "sync"
+		} else if imp.Name.Name != "_" && imp.Name.Name != "." {
+			syncName = imp.Name.Name
+		}
+	}
+	if syncName == "" {
+		return nil, nil
+	}
+
+	// positions of the package names in sync.Mutex and sync.RWMutex. An
+	// identifier which is resolved by the parser is a local declaration and
+	// not the package.
+	offsets := make([]int, 0)
+	ast.Inspect(file, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		id, ok := sel.X.(*ast.Ident)
+		if !ok || id.Name != syncName || id.Obj != nil {
+			return true
+		}
+		if sel.Sel.Name == "Mutex" || sel.Sel.Name == "RWMutex" {
+			offsets = append(offsets, fset.Position(id.Pos()).Offset)
+		}
+		return true
+	})
+	if len(offsets) == 0 {
+		return nil, nil
+	}
+	sort.Ints(offsets)
+
+	// the import of Deadlock-Go is added in the line of the last import. The
+	// sync package is used in a declaration in case the locks were its only
+	// use.
+	var lastImport ast.Decl
+	for _, decl := range file.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			lastImport = decl
+		}
+	}
+	insert := fset.Position(lastImport.End()).Offset
+	addition := fmt.Sprintf("; import %s %q; var _ %s.Locker",
+		importName, deadlockPath, syncName)
+
+	// the line directive keeps the name of the original file in the positions
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "//line %s:1\n", absPath)
+
+	// the imports are in front of all uses of the locks
+	buf.Write(src[:insert])
+	buf.WriteString(addition)
+	last := insert
+	for _, offset := range offsets {
+		buf.Write(src[last:offset])
+		buf.WriteString(importName)
+		last = offset + len(syncName)
+	}
+	buf.Write(src[last:])
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,220 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+confirm.go
+This file implements the active confirmation of potential deadlocks by
+schedule perturbation. After the comprehensive detection found a cycle, the
+workload is run again. Every routine which makes an outer acquisition of the
+cycle, i.e. acquires a lock of the cycle at the position of the cycle, sleeps
+after the acquisition while holding the lock. This gives the other routines
+of the cycle time to make their outer acquisitions, so that the routines
+then block each other in their inner acquisitions. If the periodical
+detection observes a local deadlock with the same edges as the cycle, the
+potential deadlock is confirmed.
+*/
+
+import (
+	"time"
+)
+
+// a running confirmation of a cycle
+type confirmation struct {
+	// keys of the outer acquisitions of the cycle, see avoidanceKey
+	outer map[string]struct{}
+	// edges of the cycle, see Report.cycleEdges
+	edges string
+	// time a routine sleeps after an outer acquisition
+	delay time.Duration
+	// receives the report of the observed deadlock
+	observed chan *Report
+}
+
+// ConfirmPotentialDeadlocks runs the workload, searches for potential
+// deadlocks like FindPotentialDeadlocks and tries to confirm every found cycle
+// by running the workload again with delays at the outer acquisitions of the
+// cycle. Confirmed reports are marked as confirmed and contain the observed
+// deadlock. The reports are written to the outputs after all confirmations
+// have finished.
+// The workload must create its own locks, because the routines of a
+// confirmed deadlock stay blocked. The confirmation needs the periodical
+// and the comprehensive detection and should not be combined with the
+// avoidance of deadlocks.
+//  Args:
+//   workload (func()): the workload, is run multiple times
+//   options (ConfirmOptions): options of the confirmation
+//  Returns:
+//   ([]*Report): reports of the cycles which were found for the first time
+func (d *Detector) ConfirmPotentialDeadlocks(workload func(), options ConfirmOptions) []*Report {
+	if options.Delay <= 0 {
+		options.Delay = 100 * time.Millisecond
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 5 * time.Second
+	}
+	if options.Attempts <= 0 {
+		options.Attempts = 3
+	}
+
+	workload()
+
+	if !d.opts.comprehensiveDetection {
+		return nil
+	}
+
+	// write the collected reports to the outputs after the detection
+	defer d.flushReports()
+
+	cycles := d.findCycles()
+	reports := cycles.newReports()
+
+	// the observation of the deadlock requires the periodical detection
+	if d.opts.periodicDetection {
+		for _, r := range reports {
+			d.confirmReport(r, workload, options)
+		}
+	}
+
+	cycles.report()
+	return reports
+}
+
+// ConfirmPotentialDeadlocks calls Detector.ConfirmPotentialDeadlocks of the
+// default detector
+//  Args:
+//   workload (func()): the workload, is run multiple times
+//   options (ConfirmOptions): options of the confirmation
+//  Returns:
+//   ([]*Report): reports of the cycles which were found for the first time
+func ConfirmPotentialDeadlocks(workload func(), options ConfirmOptions) []*Report {
+	return defaultDetector.ConfirmPotentialDeadlocks(workload, options)
+}
+
+// confirmReport tries to confirm the cycle of a report by running the
+// workload with delays at the outer acquisitions of the cycle
+//  Args:
+//   r (*Report): report of the cycle, is marked if it is confirmed
+//   workload (func()): the workload
+//   options (ConfirmOptions): options of the confirmation
+//  Returns:
+//   nil
+func (d *Detector) confirmReport(r *Report, workload func(), options ConfirmOptions) {
+	keys := avoidanceSignature(r)
+	edges := r.cycleEdges()
+	if keys == nil || edges == "" {
+		return
+	}
+
+	c := &confirmation{
+		outer:    make(map[string]struct{}),
+		edges:    edges,
+		delay:    options.Delay,
+		observed: make(chan *Report, 1),
+	}
+	for _, key := range keys {
+		c.outer[key] = struct{}{}
+	}
+
+	for attempt := 0; attempt < options.Attempts && !r.Confirmed; attempt++ {
+		d.confirmation.Store(c)
+
+		done := make(chan struct{})
+		go func() {
+			workload()
+			close(done)
+		}()
+
+		timer := time.NewTimer(options.Timeout)
+		select {
+		case observed := <-c.observed:
+			r.Confirmed = true
+			r.Confirmation = observed
+		case <-done:
+		case <-timer.C:
+		}
+		timer.Stop()
+
+		d.confirmation.Store((*confirmation)(nil))
+	}
+}
+
+// getConfirmation returns the running confirmation
+//  Returns:
+//   (*confirmation): the confirmation, nil if no confirmation is running
+func (d *Detector) getConfirmation() *confirmation {
+	c, _ := d.confirmation.Load().(*confirmation)
+	return c
+}
+
+// observeDeadlock is called if the periodical detection found a local
+// deadlock. If the deadlock has the same edges as the cycle of the running
+// confirmation, the cycle is confirmed.
+//  Args:
+//   r (*Report): report of the local deadlock
+//  Returns:
+//   (bool): true if the deadlock confirmed the cycle and must therefore not
+//    be reported as deadlock of the program
+func (d *Detector) observeDeadlock(r *Report) bool {
+	c := d.getConfirmation()
+	if c == nil || r.cycleEdges() != c.edges {
+		return false
+	}
+
+	select {
+	case c.observed <- r:
+	default:
+	}
+	return true
+}
+
+// perturbAcquisition is called after a lock was acquired. If the acquisition
+// is an outer acquisition of the cycle of the running confirmation, the
+// routine sleeps while holding the lock.
+//  Args:
+//   m (mutexInt): the acquired lock
+//   info (callerInfo): position of the acquisition
+//  Returns:
+//   nil
+func (d *Detector) perturbAcquisition(m mutexInt, info callerInfo) {
+	c := d.getConfirmation()
+	if c == nil || info.file == "" {
+		return
+	}
+
+	// get the class of the lock
+	class := CallSite{}
+	for _, created := range *m.getContext() {
+		if created.create {
+			class = CallSite{File: created.file, Line: created.line}
+			break
+		}
+	}
+
+	if _, ok := c.outer[avoidanceKey(class, CallSite{File: info.file, Line: info.line})]; ok {
+		time.Sleep(c.delay)
+	}
+}
@@ -0,0 +1,122 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+confirm_test.go
+Tests for the confirmation of potential deadlocks.
+*/
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Only the acquisition at the outer position of the cycle is delayed. The
+// position is the call of Lock in the code, not a frame of the detector.
+func TestPerturbAcquisitionSite(t *testing.T) {
+	d := NewDetector()
+	delay := 100 * time.Millisecond
+
+	x := d.NewLock()
+	_, file, line, _ := runtime.Caller(0)
+	lockOuter := func() { x.Lock() }
+
+	class := CallSite{File: file, Line: line - 1}
+	site := CallSite{File: file, Line: line + 1}
+	d.confirmation.Store(&confirmation{
+		outer:    map[string]struct{}{avoidanceKey(class, site): {}},
+		delay:    delay,
+		observed: make(chan *Report, 1),
+	})
+	defer d.confirmation.Store((*confirmation)(nil))
+
+	start := time.Now()
+	lockOuter()
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Errorf("the outer acquisition was not delayed, it took %v", elapsed)
+	}
+	x.Unlock()
+
+	start = time.Now()
+	x.Lock()
+	if elapsed := time.Since(start); elapsed >= delay {
+		t.Errorf("another acquisition was delayed, it took %v", elapsed)
+	}
+	x.Unlock()
+}
+
+// A lock inversion is confirmed by driving the routines into the deadlock.
+// The observed deadlock blocks at the inner acquisitions of the cycle.
+func TestConfirmPotentialDeadlocks(t *testing.T) {
+	d := NewDetector()
+	// the routines of the observed deadlock stay blocked, if they are found
+	// again after the confirmation, they must not terminate the test
+	d.SetDeadlockPolicy(PolicyContinue)
+
+	workload := func() {
+		x := d.NewLock()
+		y := d.NewLock()
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			x.Lock()
+			y.Lock()
+			y.Unlock()
+			x.Unlock()
+		}()
+		go func() {
+			defer wg.Done()
+			// the inversion is made after the first routine has finished,
+			// unless the confirmation delays the first routine
+			time.Sleep(10 * time.Millisecond)
+			y.Lock()
+			x.Lock()
+			x.Unlock()
+			y.Unlock()
+		}()
+		wg.Wait()
+	}
+
+	reports := d.ConfirmPotentialDeadlocks(workload, ConfirmOptions{
+		Delay:   200 * time.Millisecond,
+		Timeout: 2 * time.Second,
+	})
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 potential deadlock, got %d", len(reports))
+	}
+	r := reports[0]
+	if !r.Confirmed || r.Confirmation == nil {
+		t.Fatal("the potential deadlock was not confirmed")
+	}
+	if r.Confirmation.cycleEdges() != r.cycleEdges() {
+		t.Fatalf("the observed deadlock %s does not match the cycle %s",
+			r.Confirmation.cycleEdges(), r.cycleEdges())
+	}
+}
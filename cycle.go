@@ -0,0 +1,201 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+cycle.go
+This file implements the canonicalization of the cycles found by the
+comprehensive detection. The detection can find the same cycle multiple
+times, e.g. once for every rotation of the cycle, for every combination of
+routines which created the same dependencies or in multiple runs of the
+detection. Every cycle is therefore identified by a canonical key and is only
+reported once, together with the number of distinct combinations of routines
+in which it was found.
+*/
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// a cycle found by the detection
+type foundCycle struct {
+	// report of the cycle, created when the cycle was found the first time
+	report *Report
+	// keys of the combinations of routines in which the cycle was found
+	routines map[string]struct{}
+}
+
+// collection of the cycles found in one run of the comprehensive detection
+type cycleCollection struct {
+	// detector which found the cycles
+	detector *Detector
+	// found cycles by their key
+	cycles map[string]*foundCycle
+	// keys of the cycles in the order in which they were found
+	order []string
+}
+
+// newCycleCollection creates an empty collection of cycles
+//  Args:
+//   d (*Detector): detector which finds the cycles
+//  Returns:
+//   (*cycleCollection): the collection
+func newCycleCollection(d *Detector) *cycleCollection {
+	return &cycleCollection{
+		detector: d,
+		cycles:   make(map[string]*foundCycle),
+		order:    make([]string, 0),
+	}
+}
+
+// add adds the cycle represented by stack to the collection. If the same
+// cycle was already found, only the combination of routines is added.
+//  Args:
+//   stack (*depStack): stack which represents the cycle
+//  Returns:
+//   nil
+func (c *cycleCollection) add(stack *depStack) {
+	key, routineKey := cycleKey(stack)
+
+	cycle, ok := c.cycles[key]
+	if !ok {
+		cycle = &foundCycle{
+			report:   c.detector.newReportPotentialDeadlock(stack),
+			routines: make(map[string]struct{}),
+		}
+		c.cycles[key] = cycle
+		c.order = append(c.order, key)
+		c.detector.markCycle(stack)
+	}
+	cycle.routines[routineKey] = struct{}{}
+}
+
+// report reports all cycles in the collection which have not been reported
+// in a previous run of the detection
+//  Returns:
+//   nil
+func (c *cycleCollection) report() {
+	d := c.detector
+
+	d.reportedCyclesLock.Lock()
+	defer d.reportedCyclesLock.Unlock()
+
+	for _, key := range c.order {
+		cycle := c.cycles[key]
+
+		// the cycle was already reported, only remember the routines
+		if reported, ok := d.reportedCycles[key]; ok {
+			for r := range cycle.routines {
+				reported.routines[r] = struct{}{}
+			}
+			continue
+		}
+
+		d.reportedCycles[key] = cycle
+		cycle.report.Count = len(cycle.routines)
+		d.reportDeadlock(cycle.report)
+		d.addAvoidanceSignature(cycle.report)
+	}
+}
+
+// newReports returns the reports of the cycles in the collection which have
+// not been reported in a previous run of the detection
+//  Returns:
+//   ([]*Report): the reports in the order in which the cycles were found
+func (c *cycleCollection) newReports() []*Report {
+	d := c.detector
+
+	d.reportedCyclesLock.Lock()
+	defer d.reportedCyclesLock.Unlock()
+
+	reports := make([]*Report, 0)
+	for _, key := range c.order {
+		if _, ok := d.reportedCycles[key]; !ok {
+			reports = append(reports, c.cycles[key].report)
+		}
+	}
+	return reports
+}
+
+// cycleKey calculates the canonical key of a cycle and the key of the
+// combination of routines which created it.
+// The cycle key consists of the sorted edges of the cycle, where each edge
+// is given by the lock which was held, the lock which was acquired and the
+// positions of both acquisitions.
+// It is therefore independent of the rotation of the cycle and of the
+// routines which created the dependencies. The routine key contains the
+// indices of the routines in the same order as the edges in the cycle key.
+//  Args:
+//   stack (*depStack): stack which represents the cycle
+//  Returns:
+//   (string): key of the cycle
+//   (string): key of the combination of routines
+func cycleKey(stack *depStack) (string, string) {
+	type edge struct {
+		key     string
+		routine int
+	}
+	edges := make([]edge, 0)
+
+	// the lock of each dependency is in the holding set of the next dependency,
+	// the lock of the last dependency is in the holding set of the first
+	for cl := stack.stack.next; cl != nil; cl = cl.next {
+		prev := cl.prev
+		if prev == stack.stack {
+			prev = stack.top
+		}
+		dep := cl.depEntry
+		held := prev.depEntry.mu
+		key := fmt.Sprintf("%x", held.getMemoryPosition())
+		for i := 0; i < dep.holdingCount; i++ {
+			if mutexHaveEqualLock(dep.holdingSet[i], held) {
+				key += fmt.Sprintf("@%s:%d", dep.holdingInfo[i].file,
+					dep.holdingInfo[i].line)
+				break
+			}
+		}
+		key += fmt.Sprintf("->%x@%s:%d", dep.mu.getMemoryPosition(),
+			dep.muInfo.file, dep.muInfo.line)
+
+		edges = append(edges, edge{
+			key:     key,
+			routine: cl.index,
+		})
+	}
+
+	sort.Slice(edges, func(i, j int) bool { return edges[i].key < edges[j].key })
+
+	keys := make([]string, 0, len(edges))
+	routineKeys := make([]string, 0, len(edges))
+	for _, e := range edges {
+		keys = append(keys, e.key)
+		routineKeys = append(routineKeys, fmt.Sprint(e.routine))
+	}
+
+	return strings.Join(keys, ","), strings.Join(routineKeys, ",")
+}
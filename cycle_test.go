@@ -0,0 +1,128 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+cycle_test.go
+Tests for the canonical keys of the cycles found by the detection.
+*/
+
+import (
+	"testing"
+)
+
+// cycleEdge is an edge of a cycle for the tests of cycleKey
+type cycleEdge struct {
+	// index of the held lock
+	held int
+	// index of the acquired lock
+	acquired int
+	// line of the acquisition of the held lock
+	heldLine int
+	// line of the acquisition of the acquired lock
+	acquiredLine int
+	// index of the routine which created the dependency
+	routine int
+}
+
+// newCycleStack creates the stack of a cycle. The lock acquired by every
+// edge is held by the next edge.
+//  Args:
+//   locks ([]*Mutex): the locks of the cycle
+//   edges ([]cycleEdge): the edges of the cycle
+//  Returns:
+//   (*depStack): the stack
+func newCycleStack(locks []*Mutex, edges []cycleEdge) *depStack {
+	stack := newDepStack()
+	for _, e := range edges {
+		dep := &dependency{
+			mu:           locks[e.acquired],
+			holdingSet:   []mutexInt{locks[e.held]},
+			holdingCount: 1,
+			muInfo:       callerInfo{file: "cycle.go", line: e.acquiredLine},
+			holdingInfo:  []callerInfo{{file: "cycle.go", line: e.heldLine}},
+		}
+		stack.push(dep, e.routine, nil)
+	}
+	return &stack
+}
+
+// The key of a cycle does not depend on the rotation of the cycle and on the
+// routines which created the dependencies, but on the positions of the
+// acquisitions. The routine key follows the edges.
+func TestCycleKey(t *testing.T) {
+	d := NewDetector()
+	locks := []*Mutex{d.NewLock(), d.NewLock(), d.NewLock()}
+
+	ab := cycleEdge{held: 0, acquired: 1, heldLine: 10, acquiredLine: 11, routine: 0}
+	bc := cycleEdge{held: 1, acquired: 2, heldLine: 20, acquiredLine: 21, routine: 1}
+	ca := cycleEdge{held: 2, acquired: 0, heldLine: 30, acquiredLine: 31, routine: 2}
+
+	base, baseRoutines := cycleKey(newCycleStack(locks, []cycleEdge{ab, bc, ca}))
+
+	// the routines are swapped between the edges bc and ca
+	bcSwapped, caSwapped := bc, ca
+	bcSwapped.routine, caSwapped.routine = 2, 1
+
+	// the lock b is acquired at another position
+	bcMoved, abMoved := bc, ab
+	bcMoved.heldLine, abMoved.acquiredLine = 40, 41
+
+	tests := []struct {
+		name string
+		// edges of the cycle
+		edges []cycleEdge
+		// true if the key is the key of base
+		sameKey bool
+		// true if the routine key is the routine key of base
+		sameRoutines bool
+	}{
+		{"same", []cycleEdge{ab, bc, ca}, true, true},
+		{"rotation 1", []cycleEdge{bc, ca, ab}, true, true},
+		{"rotation 2", []cycleEdge{ca, ab, bc}, true, true},
+		{"other routines", []cycleEdge{ab, bcSwapped, caSwapped}, true, false},
+		{"rotated other routines", []cycleEdge{caSwapped, ab, bcSwapped}, true, false},
+		{"other position", []cycleEdge{abMoved, bcMoved, ca}, false, false},
+		{"reversed", []cycleEdge{
+			{held: 1, acquired: 0, heldLine: 11, acquiredLine: 10, routine: 0},
+			{held: 0, acquired: 2, heldLine: 31, acquiredLine: 30, routine: 2},
+			{held: 2, acquired: 1, heldLine: 21, acquiredLine: 20, routine: 1},
+		}, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, routines := cycleKey(newCycleStack(locks, test.edges))
+			if (key == base) != test.sameKey {
+				t.Errorf("key %q, base %q, expected equal: %t", key, base, test.sameKey)
+			}
+			if test.sameKey && (routines == baseRoutines) != test.sameRoutines {
+				t.Errorf("routine key %q, base %q, expected equal: %t", routines,
+					baseRoutines, test.sameRoutines)
+			}
+		})
+	}
+}
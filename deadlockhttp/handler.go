@@ -0,0 +1,239 @@
+//go:build !deadlock_off
+
+package deadlockhttp
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlockhttp
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+handler.go
+Package deadlockhttp implements an http.Handler, which shows the current state
+of a detector, e.g. to inspect a process which seems to be stuck without
+terminating it. It shows the locks held by every routine, the routines which
+wait for a lock, the number of dependencies and the lock graph. The
+comprehensive detection can be started from the page. If the profiling is
+enabled, it also serves the profile of the locks.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+
+	deadlock "github.com/ErikKassubek/Deadlock-Go"
+)
+
+// handler which shows the state of a detector
+type debugHandler struct {
+	// the detector
+	detector *deadlock.Detector
+}
+
+// data of the HTML pages
+type debugPage struct {
+	State   deadlock.DetectorState
+	Reports []*deadlock.Report
+	// true if the page shows the result of a detection
+	Detected bool
+}
+
+// Handler returns an http.Handler which shows the current state of the
+// detector d. It is mounted like net/http/pprof, e.g.
+// http.Handle("/debug/deadlock/", deadlockhttp.Handler(deadlock.Default())).
+// It serves
+//  - /: HTML page with the locks held by every routine, the waiting routines,
+//    the number of dependencies and a button to run the detection
+//  - state: the state as JSON, see Detector.State
+//  - graph: the lock graph in the DOT format, with ?format=json as JSON
+//  - stats: the statistics of the profiling as JSON, see Detector.Stats
+//  - profile: the profile of the locks in the format of pprof, see
+//    Detector.WriteProfile
+//  - detect: runs the comprehensive detection with a POST request and shows
+//    the found potential deadlocks, with ?format=json as JSON
+//  Args:
+//   d (*deadlock.Detector): the detector
+//  Returns:
+//   (http.Handler): the handler
+func Handler(d *deadlock.Detector) http.Handler {
+	return &debugHandler{detector: d}
+}
+
+// ServeHTTP serves a request
+//  Args:
+//   w (http.ResponseWriter): writer for the response
+//   r (*http.Request): the request
+//  Returns:
+//   nil
+func (h *debugHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d := h.detector
+	asJSON := r.URL.Query().Get("format") == "json"
+
+	// the handler can be mounted at any path, so only the last element is used
+	switch name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]; name {
+	case "":
+		h.writeHTML(w, debugPage{State: d.State()})
+
+	case "state":
+		h.writeJSON(w, d.State())
+
+	case "graph":
+		format := deadlock.GraphDOT
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		if asJSON {
+			format = deadlock.GraphJSON
+			w.Header().Set("Content-Type", "application/json")
+		}
+		if err := d.WriteLockGraph(w, format); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+	case "stats":
+		h.writeJSON(w, d.Stats())
+
+	case "profile":
+		// the profile is written into a buffer first, so that an error can
+		// still be sent as response
+		var buf bytes.Buffer
+		if err := d.WriteProfile(&buf); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="profile.pb.gz"`)
+		w.Write(buf.Bytes())
+
+	case "detect":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "detect requires a POST request", http.StatusMethodNotAllowed)
+			return
+		}
+		reports := d.DetectNow()
+		if reports == nil {
+			reports = make([]*deadlock.Report, 0)
+		}
+		if asJSON {
+			h.writeJSON(w, reports)
+			return
+		}
+		h.writeHTML(w, debugPage{State: d.State(), Reports: reports, Detected: true})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeJSON writes a value as JSON response
+//  Args:
+//   w (http.ResponseWriter): writer for the response
+//   v (interface{}): the value
+//  Returns:
+//   nil
+func (h *debugHandler) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writeHTML writes a page as HTML response
+//  Args:
+//   w (http.ResponseWriter): writer for the response
+//   page (debugPage): data of the page
+//  Returns:
+//   nil
+func (h *debugHandler) writeHTML(w http.ResponseWriter, page debugPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := debugTemplate.Execute(w, page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ============ template ============
+
+var debugTemplate = template.Must(template.New("debug").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Deadlock-Go</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.5em; }
+h2 { font-size: 1.2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { border: 1px solid #ddd; padding: 2px 8px; text-align: left; font-family: monospace; vertical-align: top; }
+pre { background: #f6f6f6; padding: 0.5em; overflow-x: auto; margin: 0; }
+.finding { border: 1px solid #ccc; border-radius: 4px; padding: 0.5em 1em; margin-bottom: 1em; }
+.finding h3 { color: #a0522d; font-size: 1em; }
+</style>
+</head>
+<body>
+<h1>Deadlock-Go</h1>
+<p>
+<a href="state">state as JSON</a> |
+<a href="graph">lock graph (DOT)</a> |
+<a href="graph?format=json">lock graph (JSON)</a>
+</p>
+<form method="post" action="detect"><button type="submit">Run comprehensive detection</button></form>
+
+{{if .Detected}}<h2>Detection</h2>
+{{if not .Reports}}<p>No new potential deadlocks found.</p>{{end}}
+{{range $i, $r := .Reports}}<div class="finding">
+<h3>Potential deadlock {{$r.Signature}}</h3>
+<table>
+<tr><th>Lock</th><th>Type</th><th>Created</th></tr>
+{{range $r.Locks}}<tr><td>{{.ID}}</td><td>{{.Type}}</td><td>{{.Created.File}}:{{.Created.Line}}</td></tr>
+{{end}}</table>
+<table>
+<tr><th>Routine</th><th>Acquired</th><th>At</th><th>Holding</th></tr>
+{{range $r.Edges}}<tr><td>{{.Routine}} (goroutine {{.Goroutine}})</td><td>{{if .RLock}}r-lock{{else}}lock{{end}} {{.Lock}}</td><td>{{.Acquired.File}}:{{.Acquired.Line}}</td><td>{{range .Holding}}{{if .RLock}}r-lock{{else}}lock{{end}} {{.Lock}} at {{.Acquired.File}}:{{.Acquired.Line}}<br>{{end}}</td></tr>
+{{end}}</table>
+</div>
+{{end}}{{end}}
+
+<h2>Held Locks</h2>
+<table>
+<tr><th>Routine</th><th>Goroutine</th><th>Created by</th><th>Lock</th><th>Created</th><th>Acquired</th></tr>
+{{range .State.Routines}}{{$r := .}}{{range .Holding}}<tr><td>{{$r.Routine}}</td><td>{{$r.Goroutine}}</td><td>{{with $r.CreatedBy}}{{.Function}} at {{.File}}:{{.Line}}{{end}}</td><td>{{if .RLock}}r-lock{{else}}lock{{end}} {{.Lock.ID}} ({{.Lock.Type}})</td><td>{{.Lock.Created.File}}:{{.Lock.Created.Line}}</td><td>{{.Acquired.File}}:{{.Acquired.Line}}{{if .Acquired.Stack}}<details><summary>stack</summary><pre>{{.Acquired.Stack}}</pre></details>{{end}}</td></tr>
+{{end}}{{end}}</table>
+
+<h2>Waiting Routines</h2>
+{{if .State.WaitsTracked}}<table>
+<tr><th>Goroutine</th><th>Lock</th><th>Created</th><th>Acquired</th><th>Waiting</th><th>Held by</th></tr>
+{{range .State.Waits}}<tr><td>{{.Goroutine}}{{if ge .Routine 0}} (routine {{.Routine}}){{end}}</td><td>{{if .RLock}}r-lock{{else}}lock{{end}} {{.Lock.ID}} ({{.Lock.Type}})</td><td>{{.Lock.Created.File}}:{{.Lock.Created.Line}}</td><td>{{.Acquired.File}}:{{.Acquired.Line}}{{if .Acquired.Stack}}<details><summary>stack</summary><pre>{{.Acquired.Stack}}</pre></details>{{end}}</td><td>{{.WaitingMs}} ms</td><td>{{range $i, $g := .HeldBy}}{{if $i}}, {{end}}goroutine {{$g}}{{end}}</td></tr>
+{{end}}</table>
+{{else}}<p>The waiting routines are only tracked with the periodic detection or the lock wait timeout.</p>{{end}}
+
+<h2>Dependencies</h2>
+<p>{{.State.Dependencies}} dependencies in {{len .State.Routines}} routine(s)</p>
+<table>
+<tr><th>Routine</th><th>Goroutine</th><th>Dependencies</th></tr>
+{{range .State.Routines}}<tr><td>{{.Routine}}</td><td>{{.Goroutine}}</td><td>{{.Dependencies}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,174 @@
+//go:build !deadlock_off
+
+package deadlockhttp
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlockhttp
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+handler_test.go
+Tests of the handler, which serve it while other routines acquire locks. The
+tests are meant to be run with the race detector.
+*/
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	deadlock "github.com/ErikKassubek/Deadlock-Go"
+)
+
+// get requests a page of the handler and checks that it was served
+//  Args:
+//   t (*testing.T): the test
+//   method (string): method of the request
+//   url (string): url of the page
+//  Returns:
+//   ([]byte): the body of the response
+func get(t *testing.T, method string, url string) []byte {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s %s: %s: %s", method, url, resp.Status, body)
+	}
+	return body
+}
+
+// The handler reads the state of the detector while other routines acquire
+// and release locks.
+func TestHandlerWhileLocking(t *testing.T) {
+	d := deadlock.NewDetector()
+	x := d.NewLock()
+	y := d.NewRWLock()
+
+	srv := httptest.NewServer(Handler(d))
+	defer srv.Close()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				x.Lock()
+				if i%2 == 0 {
+					y.RLock()
+					y.RUnlock()
+				} else {
+					y.Lock()
+					y.Unlock()
+				}
+				x.Unlock()
+			}
+		}(i)
+	}
+
+	for i := 0; i < 5; i++ {
+		get(t, http.MethodGet, srv.URL+"/")
+		get(t, http.MethodGet, srv.URL+"/graph")
+		get(t, http.MethodGet, srv.URL+"/graph?format=json")
+		get(t, http.MethodGet, srv.URL+"/stats")
+		get(t, http.MethodPost, srv.URL+"/detect")
+
+		var state deadlock.DetectorState
+		if err := json.Unmarshal(get(t, http.MethodGet, srv.URL+"/state"), &state); err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range state.Routines {
+			if len(r.Holding) > 2 {
+				t.Fatalf("routine %d holds %d locks", r.Routine, len(r.Holding))
+			}
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+
+	var reports []*deadlock.Report
+	if err := json.Unmarshal(get(t, http.MethodPost, srv.URL+"/detect?format=json"), &reports); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 0 {
+		t.Fatalf("unexpected potential deadlock: %s", reports[0].Signature)
+	}
+}
+
+// The detection started by the handler returns the found potential deadlocks.
+func TestHandlerDetect(t *testing.T) {
+	d := deadlock.NewDetector()
+	x := d.NewLock()
+	y := d.NewLock()
+
+	srv := httptest.NewServer(Handler(d))
+	defer srv.Close()
+
+	for _, order := range [][2]*deadlock.Mutex{{x, y}, {y, x}} {
+		done := make(chan struct{})
+		go func(first, second *deadlock.Mutex) {
+			defer close(done)
+			first.Lock()
+			second.Lock()
+			second.Unlock()
+			first.Unlock()
+		}(order[0], order[1])
+		<-done
+	}
+
+	var reports []*deadlock.Report
+	if err := json.Unmarshal(get(t, http.MethodPost, srv.URL+"/detect?format=json"), &reports); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Kind != deadlock.KindPotentialDeadlock {
+		t.Fatalf("expected one potential deadlock, got %d reports", len(reports))
+	}
+
+	// the detection only returns cycles which were found for the first time
+	if err := json.Unmarshal(get(t, http.MethodPost, srv.URL+"/detect?format=json"), &reports); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 0 {
+		t.Fatalf("the potential deadlock was returned again")
+	}
+}
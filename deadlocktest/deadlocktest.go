@@ -0,0 +1,218 @@
+package deadlocktest
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlocktest
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+deadlocktest.go
+Package deadlocktest integrates the deadlock detection with the testing
+package. Check runs the detection for a single test and reports the findings
+as errors of the test. Main and Run run the detection for all tests of a
+package. Detected deadlocks fail the tests instead of terminating the test
+binary.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	deadlock "github.com/ErikKassubek/Deadlock-Go"
+	"github.com/petermattis/goid"
+)
+
+// a finding of a test
+type finding struct {
+	// name of the test, empty if the finding does not belong to a test
+	test string
+	// report of the finding
+	report *deadlock.Report
+}
+
+// all findings, used for the JUnit output
+var findings = make([]finding, 0)
+
+// lock to prevent concurrent access to findings
+var findingsLock sync.Mutex
+
+// Check runs the deadlock detection for the test t. It has to be called at
+// the start of the test. Double locking and local deadlocks are reported as
+// errors of the test as soon as they are detected. At the end of the test,
+// the comprehensive detection runs on the locks used during the test and
+// every potential deadlock is reported as error of the test.
+// The routine which detected a deadlock releases all locks it holds, so that
+// the other routines of the test are not blocked by them, and continues. If
+// this is the routine of the test, the test ends.
+// Check uses the default detector and can therefore not be used in parallel
+// tests. Parallel tests can use CheckDetector with their own detector.
+//  Args:
+//   t (testing.TB): the test
+//  Returns:
+//   nil
+func Check(t testing.TB) {
+	t.Helper()
+	CheckDetector(t, deadlock.Default())
+}
+
+// CheckDetector works like Check but runs the detection for the locks of the
+// detector d. If every test uses its own detector, the tests can run in
+// parallel.
+//  Args:
+//   t (testing.TB): the test
+//   d (*deadlock.Detector): the detector of the locks used in the test
+//  Returns:
+//   nil
+func CheckDetector(t testing.TB, d *deadlock.Detector) {
+	t.Helper()
+
+	// only detect the locks used in this test. Routines which hold locks,
+	// e.g. background workers, keep them
+	d.Reset()
+
+	// Check is called in the routine of the test
+	testGoID := goid.Get()
+
+	stop := d.InterceptDeadlocks(func(err *deadlock.DeadlockError) {
+		fail(t, err.Report)
+		d.ReleaseLocks()
+		if goid.Get() == testGoID {
+			t.FailNow()
+		}
+	})
+
+	t.Cleanup(func() {
+		stop()
+		for _, r := range d.DetectNow() {
+			fail(t, r)
+		}
+	})
+}
+
+// Main runs the tests of a package with Run and exits with its result. It is
+// meant to be called from TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		deadlocktest.Main(m)
+//	}
+//
+//  Args:
+//   m (*testing.M): the tests
+//  Returns:
+//   nil
+func Main(m *testing.M) {
+	os.Exit(Run(m))
+}
+
+// Run runs the tests of a package and the deadlock detection for all of
+// them. Deadlocks detected outside of tests using Check and potential
+// deadlocks found at the end of the tests fail the package. As in Check,
+// the routine which detected a deadlock releases its locks and continues.
+// If a JUnit file is set, the findings are written to it.
+//  Args:
+//   m (*testing.M): the tests
+//  Returns:
+//   (int): exit code of the tests, 1 if the tests passed but deadlocks
+//    were found
+func Run(m *testing.M) int {
+	stop := deadlock.InterceptDeadlocks(func(err *deadlock.DeadlockError) {
+		addFinding("", err.Report)
+		deadlock.ReleaseLocks()
+	})
+	code := m.Run()
+	stop()
+
+	for _, r := range deadlock.DetectNow() {
+		addFinding("", r)
+	}
+
+	findingsLock.Lock()
+	defer findingsLock.Unlock()
+
+	if err := writeJUnit(findings); err != nil {
+		fmt.Fprintln(os.Stderr, "deadlocktest: could not write JUnit output:", err)
+	}
+
+	// the findings of tests using Check already failed the tests
+	failed := 0
+	for _, f := range findings {
+		if f.test == "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "deadlocktest: found %d deadlock(s) outside of checked tests\n", failed)
+		if code == 0 {
+			code = 1
+		}
+	}
+
+	return code
+}
+
+// fail reports a finding as error of a test
+//  Args:
+//   t (testing.TB): the test
+//   r (*deadlock.Report): report of the finding
+//  Returns:
+//   nil
+func fail(t testing.TB, r *deadlock.Report) {
+	t.Helper()
+
+	addFinding(t.Name(), r)
+
+	report, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		report = []byte(err.Error())
+	}
+	t.Errorf("%s\n%s", describe(r), report)
+}
+
+// addFinding adds a finding to the findings of the package
+//  Args:
+//   test (string): name of the test, empty if the finding does not belong
+//    to a test
+//   r (*deadlock.Report): report of the finding
+//  Returns:
+//   nil
+func addFinding(test string, r *deadlock.Report) {
+	findingsLock.Lock()
+	findings = append(findings, finding{test: test, report: r})
+	findingsLock.Unlock()
+}
+
+// describe returns a short description of a finding
+//  Args:
+//   r (*deadlock.Report): report of the finding
+//  Returns:
+//   (string): the description
+func describe(r *deadlock.Report) string {
+	if r.Kind != deadlock.KindPotentialDeadlock || len(r.Edges) == 0 {
+		return (&deadlock.DeadlockError{Report: r}).Error()
+	}
+
+	acquired := r.Edges[0].Acquired
+	return fmt.Sprintf("potential deadlock of %d routines at %s:%d",
+		len(r.Edges), acquired.File, acquired.Line)
+}
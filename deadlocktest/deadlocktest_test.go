@@ -0,0 +1,260 @@
+//go:build !deadlock_off
+
+package deadlocktest
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlocktest
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+deadlocktest_test.go
+Tests of the integration of the detection with the testing package
+*/
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	deadlock "github.com/ErikKassubek/Deadlock-Go"
+)
+
+// recorder is a test, which records its errors instead of failing
+type recorder struct {
+	testing.TB
+	// lock to prevent concurrent access to errors
+	lock sync.Mutex
+	// the errors of the test
+	errors []string
+	// the functions registered with Cleanup
+	cleanups []func()
+}
+
+// Helper does nothing, the errors do not contain positions
+//  Returns:
+//   nil
+func (r *recorder) Helper() {}
+
+// Name returns the name of the test
+//  Returns:
+//   (string): the name
+func (r *recorder) Name() string {
+	return "recorder"
+}
+
+// Errorf records an error
+//  Args:
+//   format (string): format of the error
+//   args (...interface{}): arguments of the format
+//  Returns:
+//   nil
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.lock.Lock()
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+	r.lock.Unlock()
+}
+
+// FailNow ends the routine of the test
+//  Returns:
+//   nil
+func (r *recorder) FailNow() {
+	runtime.Goexit()
+}
+
+// Cleanup registers a function to run at the end of the test
+//  Args:
+//   f (func()): the function
+//  Returns:
+//   nil
+func (r *recorder) Cleanup(f func()) {
+	r.cleanups = append(r.cleanups, f)
+}
+
+// runChecked runs f as test checked by CheckDetector and returns the errors
+// of the test. The test fails if f does not end.
+//  Args:
+//   t (*testing.T): the test running f
+//   d (*deadlock.Detector): the detector of the locks used by f
+//   f (func()): the code under test
+//  Returns:
+//   ([]string): the errors of f
+func runChecked(t *testing.T, d *deadlock.Detector, f func()) []string {
+	rec := &recorder{TB: t}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			for i := len(rec.cleanups) - 1; i >= 0; i-- {
+				rec.cleanups[i]()
+			}
+		}()
+
+		CheckDetector(rec, d)
+		f()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the checked test does not end")
+	}
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	return rec.errors
+}
+
+// A lock which is held by a background routine while the check starts must
+// not lead to a false double locking.
+func TestCheckWhileLocked(t *testing.T) {
+	d := deadlock.NewDetector()
+	l := d.NewLock()
+	other := d.NewLock()
+
+	holding := make(chan struct{})
+	release := make(chan struct{})
+	released := make(chan struct{})
+	go func() {
+		l.Lock()
+		close(holding)
+		<-release
+		l.Unlock()
+		close(released)
+	}()
+	<-holding
+
+	errs := runChecked(t, d, func() {
+		close(release)
+		<-released
+
+		other.Lock()
+		other.Unlock()
+
+		held := make(chan struct{})
+		go func() {
+			l.Lock()
+			close(held)
+			time.Sleep(20 * time.Millisecond)
+			l.Unlock()
+		}()
+		<-held
+
+		l.Lock()
+		l.Unlock()
+	})
+
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+}
+
+// A routine of the test, which detects double locking, releases its locks and
+// continues, so that the test does not hang.
+func TestCheckReleasesLocks(t *testing.T) {
+	d := deadlock.NewDetector()
+	x := d.NewLock()
+	y := d.NewLock()
+
+	errs := runChecked(t, d, func() {
+		done := make(chan struct{})
+		go func() {
+			y.Lock()
+			x.Lock()
+			x.Lock()
+			x.Unlock()
+			x.Unlock()
+			y.Unlock()
+			close(done)
+		}()
+		<-done
+
+		y.Lock()
+		x.Lock()
+		x.Unlock()
+		y.Unlock()
+	})
+
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %d: %v", len(errs), errs)
+	}
+}
+
+// If the routine of the test detects double locking, the test ends and the
+// locks held by it are released.
+func TestCheckEndsTest(t *testing.T) {
+	d := deadlock.NewDetector()
+	x := d.NewLock()
+
+	waiting := make(chan struct{})
+	acquired := make(chan struct{})
+	errs := runChecked(t, d, func() {
+		x.Lock()
+
+		// a routine waits for the lock held by the test
+		go func() {
+			close(waiting)
+			x.Lock()
+			x.Unlock()
+			close(acquired)
+		}()
+		<-waiting
+
+		x.Lock()
+		t.Error("the test continued after double locking")
+	})
+
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %d: %v", len(errs), errs)
+	}
+
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the lock held by the test was not released")
+	}
+}
+
+// The seeds of the fuzz test run without failures for a body without
+// deadlocks.
+func FuzzScheduleLockOrder(f *testing.F) {
+	d := deadlock.NewDetector()
+	FuzzScheduleDetector(f, d, func() {
+		x := d.NewLock()
+		y := d.NewLock()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				x.Lock()
+				y.Lock()
+				y.Unlock()
+				x.Unlock()
+			}()
+		}
+		wg.Wait()
+	})
+}
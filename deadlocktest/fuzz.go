@@ -0,0 +1,84 @@
+package deadlocktest
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlocktest
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+fuzz.go
+This file implements the integration with the native fuzzing of go. The
+bytes of the fuzz input decide at which points of the acquisitions and
+releases of locks the go routines yield or sleep. The fuzz engine can
+therefore explore different schedules of the routines. An actual deadlock or
+double locking found with a schedule is a failure of the fuzz test.
+*/
+
+import (
+	"encoding/json"
+	"testing"
+
+	deadlock "github.com/ErikKassubek/Deadlock-Go"
+)
+
+// FuzzSchedule runs a fuzz test in which the fuzz input decides at which
+// acquisitions and releases of locks the routines of body yield or sleep.
+// Every actual deadlock and double locking found by the detection while
+// body is running is reported as failure of the fuzz test, so that the
+// schedule is stored in the corpus. A body which does not finish within the
+// schedule timeout of the detector is a failure as well. Because the schedule only influences the
+// go scheduler, a failing input will reproduce the deadlock in most, but not
+// necessarily in all runs.
+// The body must create its own locks, because the routines of a deadlock
+// stay blocked. The actual deadlocks are only found if the periodical
+// detection is enabled.
+//  Args:
+//   f (*testing.F): the fuzz test
+//   body (func()): the code under test, is run for every fuzz input
+//  Returns:
+//   nil
+func FuzzSchedule(f *testing.F, body func()) {
+	f.Helper()
+	FuzzScheduleDetector(f, deadlock.Default(), body)
+}
+
+// FuzzScheduleDetector works like FuzzSchedule but runs the detection for
+// the locks of the detector d
+//  Args:
+//   f (*testing.F): the fuzz test
+//   d (*deadlock.Detector): the detector of the locks used in body
+//   body (func()): the code under test, is run for every fuzz input
+//  Returns:
+//   nil
+func FuzzScheduleDetector(f *testing.F, d *deadlock.Detector, body func()) {
+	f.Helper()
+
+	f.Add([]byte{})
+	f.Add([]byte{1, 1, 1, 1})
+	f.Add([]byte{2, 254, 2, 254})
+
+	f.Fuzz(func(t *testing.T, schedule []byte) {
+		if err := d.RunSchedule(schedule, body); err != nil {
+			report, _ := json.MarshalIndent(err.Report, "", "  ")
+			t.Fatalf("%s\nschedule: %v\n%s", err, err.Schedule, report)
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package deadlocktest
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlocktest
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+junit.go
+This file implements the output of the findings as JUnit XML, which can be
+read by most CI systems. Every finding is a failed test case. If no deadlock
+was found, the file contains a single passed test case.
+*/
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"os"
+)
+
+// name of the environment variable to set the JUnit output file
+const envJUnitOutput = "DEADLOCK_GO_JUNIT"
+
+// file the JUnit output is written to, empty if not set
+var junitFile string
+
+// SetJUnitFile sets the file the findings of Run are written to as JUnit
+// XML. If it is not set, the environment variable DEADLOCK_GO_JUNIT is used.
+//  Args:
+//   path (string): path of the file
+//  Returns:
+//   nil
+func SetJUnitFile(path string) {
+	junitFile = path
+}
+
+// root element of the JUnit output
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+// test suite containing the findings
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+// test case of a finding
+type junitCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+// failure of a test case, containing the report of the finding
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes the findings to the JUnit output file, if it is set
+//  Args:
+//   findings ([]finding): the findings
+//  Returns:
+//   (error): error if the file could not be written
+func writeJUnit(findings []finding) error {
+	path := junitFile
+	if path == "" {
+		path = os.Getenv(envJUnitOutput)
+	}
+	if path == "" {
+		return nil
+	}
+
+	suite := junitSuite{
+		Name:  "deadlock",
+		Tests: len(findings),
+		Cases: make([]junitCase, 0, len(findings)),
+	}
+
+	for _, f := range findings {
+		test := f.test
+		if test == "" {
+			test = "package"
+		}
+
+		report, err := json.MarshalIndent(f.report, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		suite.Cases = append(suite.Cases, junitCase{
+			ClassName: "deadlock",
+			Name:      test + " " + string(f.report.Kind) + " " + f.report.Signature,
+			Failure: &junitFailure{
+				Message: describe(f.report),
+				Type:    string(f.report.Kind),
+				Text:    string(report),
+			},
+		})
+		suite.Failures++
+	}
+
+	// a passed test case, so that the output is not empty
+	if len(findings) == 0 {
+		suite.Tests = 1
+		suite.Cases = append(suite.Cases, junitCase{
+			ClassName: "deadlock",
+			Name:      "no deadlocks",
+		})
+	}
+
+	data, err := xml.MarshalIndent(junitSuites{Suites: []junitSuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
@@ -0,0 +1,185 @@
+package deadlockvet
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlockvet
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+analyzer.go
+This file implements an analyzer for go vet, which reports misuses of
+Deadlock-Go which can be found statically. Such misuses lead to confusing
+panics or to deadlocks which are not detected at runtime.
+This file contains the analyzer and the checks of the types which are used.
+*/
+
+import (
+	"go/ast"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+)
+
+// import path of Deadlock-Go
+const deadlockPath = "github.com/ErikKassubek/Deadlock-Go"
+
+const doc = `check for misuses of Deadlock-Go
+
+The deadlock analyzer reports
+- copies of deadlock.Mutex and deadlock.RWMutex values
+- RUnlock of a lock which was locked with Lock and Unlock of a lock which was
+  locked with RLock
+- Lock and RLock without Unlock or RUnlock on some path of a function
+- sync.Mutex and sync.RWMutex in packages which use Deadlock-Go`
+
+// Analyzer reports misuses of Deadlock-Go
+var Analyzer = &analysis.Analyzer{
+	Name:     "deadlock",
+	Doc:      doc,
+	Requires: []*analysis.Analyzer{inspect.Analyzer},
+	Run:      run,
+}
+
+// run runs the analyzer on a package
+//  Args:
+//   pass (*analysis.Pass): the package
+//  Returns:
+//   (interface{}): always nil
+//   (error): always nil
+func run(pass *analysis.Pass) (interface{}, error) {
+	// Deadlock-Go and its tools are implemented with the locks of the sync
+	// package
+	if isDeadlockPackage(pass.Pkg) ||
+		strings.HasPrefix(pass.Pkg.Path(), deadlockPath+"/") {
+		return nil, nil
+	}
+
+	usesDeadlock := false
+	for _, imp := range pass.Pkg.Imports() {
+		if isDeadlockPackage(imp) {
+			usesDeadlock = true
+		}
+	}
+
+	insp := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+	insp.WithStack(nil, func(n ast.Node, push bool, stack []ast.Node) bool {
+		if !push {
+			return true
+		}
+		switch n := n.(type) {
+		case *ast.Ident:
+			checkTypeUse(pass, n, stack, usesDeadlock)
+		case *ast.FuncDecl:
+			checkCopyParams(pass, n.Name.Name, n.Recv, n.Type.Params)
+			checkFunc(pass, n.Body)
+		case *ast.FuncLit:
+			checkCopyParams(pass, "func literal", n.Type.Params)
+			checkFunc(pass, n.Body)
+		default:
+			checkCopy(pass, n)
+		}
+		return true
+	})
+
+	return nil, nil
+}
+
+// isDeadlockPackage checks if a package is Deadlock-Go
+//  Args:
+//   pkg (*types.Package): the package
+//  Returns:
+//   (bool): true if pkg is Deadlock-Go, false otherwise
+func isDeadlockPackage(pkg *types.Package) bool {
+	if pkg == nil {
+		return false
+	}
+	return pkg.Path() == deadlockPath ||
+		strings.HasSuffix(pkg.Path(), "/vendor/"+deadlockPath)
+}
+
+// lockName returns the name of the type if it is deadlock.Mutex or
+// deadlock.RWMutex
+//  Args:
+//   t (types.Type): the type
+//  Returns:
+//   (string): "Mutex" or "RWMutex" if t is a lock of Deadlock-Go, "" otherwise
+func lockName(t types.Type) string {
+	named, ok := t.(*types.Named)
+	if !ok || !isDeadlockPackage(named.Obj().Pkg()) {
+		return ""
+	}
+	switch name := named.Obj().Name(); name {
+	case "Mutex", "RWMutex":
+		return name
+	}
+	return ""
+}
+
+// constructor returns the name of the function which creates a lock
+//  Args:
+//   name (string): "Mutex" or "RWMutex"
+//  Returns:
+//   (string): the name of the constructor
+func constructor(name string) string {
+	if name == "RWMutex" {
+		return "NewRWLock"
+	}
+	return "NewLock"
+}
+
+// checkTypeUse reports the use of the locks of the sync package in packages
+// which use Deadlock-Go. Values of deadlock.Mutex and deadlock.RWMutex are
+// valid, because they are created on their first use, only their copies are
+// reported.
+//  Args:
+//   pass (*analysis.Pass): the package
+//   id (*ast.Ident): the identifier which may refer to a type
+//   stack ([]ast.Node): the nodes enclosing id, including id
+//   usesDeadlock (bool): true if the package imports Deadlock-Go
+//  Returns:
+//   nil
+func checkTypeUse(pass *analysis.Pass, id *ast.Ident, stack []ast.Node, usesDeadlock bool) {
+	if !usesDeadlock {
+		return
+	}
+
+	obj, ok := pass.TypesInfo.Uses[id].(*types.TypeName)
+	if !ok || obj.Pkg() == nil || obj.Pkg().Path() != "sync" {
+		return
+	}
+
+	// the type expression is either id or the qualified identifier pkg.id
+	var expr ast.Expr = id
+	if parent := len(stack) - 2; parent >= 0 {
+		if sel, ok := stack[parent].(*ast.SelectorExpr); ok && sel.Sel == id {
+			expr = sel
+		}
+	}
+
+	switch name := obj.Name(); name {
+	case "Mutex", "RWMutex":
+		pass.ReportRangef(expr, "sync.%s in a package which uses Deadlock-Go is not checked for deadlocks, use deadlock.%s instead",
+			name, constructor(name))
+	}
+}
@@ -0,0 +1,173 @@
+package deadlockvet
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlockvet
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+copy.go
+This file implements the check for copies of locks. A copy of a
+deadlock.Mutex or deadlock.RWMutex shares the underlying lock with the
+original, but not the information of the detector about it.
+*/
+
+import (
+	"go/ast"
+	"go/types"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+// containedLock returns the lock which is contained in a value of type t
+//  Args:
+//   t (types.Type): the type
+//  Returns:
+//   (string): "deadlock.Mutex" or "deadlock.RWMutex" if a value of type t
+//    contains such a lock, "" otherwise
+func containedLock(t types.Type) string {
+	if t == nil {
+		return ""
+	}
+	if name := lockName(t); name != "" {
+		return "deadlock." + name
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Struct:
+		for i := 0; i < u.NumFields(); i++ {
+			if lock := containedLock(u.Field(i).Type()); lock != "" {
+				return lock
+			}
+		}
+	case *types.Array:
+		return containedLock(u.Elem())
+	}
+	return ""
+}
+
+// copiedLock returns the lock which is copied by using the value of the
+// expression. Composite literals and results of calls are new values and
+// therefore not copies, types, e.g. the argument of new, are no values.
+//  Args:
+//   pass (*analysis.Pass): the package
+//   expr (ast.Expr): the expression
+//  Returns:
+//   (string): the copied lock or "" if no lock is copied
+func copiedLock(pass *analysis.Pass, expr ast.Expr) string {
+	for {
+		paren, ok := expr.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		expr = paren.X
+	}
+
+	switch expr.(type) {
+	case *ast.CompositeLit, *ast.CallExpr:
+		return ""
+	}
+
+	tv, ok := pass.TypesInfo.Types[expr]
+	if !ok || tv.IsType() {
+		return ""
+	}
+	return containedLock(tv.Type)
+}
+
+// checkCopy reports the copies of locks in assignments, calls, returns,
+// range loops and composite literals
+//  Args:
+//   pass (*analysis.Pass): the package
+//   n (ast.Node): the node to check
+//  Returns:
+//   nil
+func checkCopy(pass *analysis.Pass, n ast.Node) {
+	switch n := n.(type) {
+	case *ast.AssignStmt:
+		for _, rhs := range n.Rhs {
+			if lock := copiedLock(pass, rhs); lock != "" {
+				pass.ReportRangef(rhs, "assignment copies lock value: %s contains %s",
+					types.ExprString(rhs), lock)
+			}
+		}
+	case *ast.ValueSpec:
+		for _, value := range n.Values {
+			if lock := copiedLock(pass, value); lock != "" {
+				pass.ReportRangef(value, "variable declaration copies lock value: %s contains %s",
+					types.ExprString(value), lock)
+			}
+		}
+	case *ast.CallExpr:
+		for _, arg := range n.Args {
+			if lock := copiedLock(pass, arg); lock != "" {
+				pass.ReportRangef(arg, "call of %s copies lock value: %s contains %s",
+					types.ExprString(n.Fun), types.ExprString(arg), lock)
+			}
+		}
+	case *ast.ReturnStmt:
+		for _, result := range n.Results {
+			if lock := copiedLock(pass, result); lock != "" {
+				pass.ReportRangef(result, "return copies lock value: %s contains %s",
+					types.ExprString(result), lock)
+			}
+		}
+	case *ast.RangeStmt:
+		if n.Value != nil {
+			if lock := containedLock(pass.TypesInfo.TypeOf(n.Value)); lock != "" {
+				pass.ReportRangef(n.Value, "range var %s copies lock value: %s",
+					types.ExprString(n.Value), lock)
+			}
+		}
+	case *ast.CompositeLit:
+		for _, elt := range n.Elts {
+			if kv, ok := elt.(*ast.KeyValueExpr); ok {
+				elt = kv.Value
+			}
+			if lock := copiedLock(pass, elt); lock != "" {
+				pass.ReportRangef(elt, "literal copies lock value: %s contains %s",
+					types.ExprString(elt), lock)
+			}
+		}
+	}
+}
+
+// checkCopyParams reports functions which receive a lock by value as
+// receiver or parameter
+//  Args:
+//   pass (*analysis.Pass): the package
+//   name (string): name of the function, "func literal" for literals
+//   fields (...*ast.FieldList): the receiver and the parameters
+//  Returns:
+//   nil
+func checkCopyParams(pass *analysis.Pass, name string, fields ...*ast.FieldList) {
+	for _, list := range fields {
+		if list == nil {
+			continue
+		}
+		for _, field := range list.List {
+			if lock := containedLock(pass.TypesInfo.TypeOf(field.Type)); lock != "" {
+				pass.ReportRangef(field.Type, "%s passes lock by value: %s",
+					name, lock)
+			}
+		}
+	}
+}
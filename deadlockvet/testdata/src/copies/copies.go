@@ -0,0 +1,73 @@
+// Package copies contains copies of locks
+package copies
+
+import deadlock "github.com/ErikKassubek/Deadlock-Go"
+
+type account struct {
+	// values of the locks are created on their first use
+	mu      deadlock.Mutex
+	balance int
+}
+
+type registry struct {
+	mu       *deadlock.RWMutex
+	accounts []account
+}
+
+// a lock value is not a copy
+var global deadlock.Mutex
+
+func (a account) value() int { // want `value passes lock by value: deadlock.Mutex`
+	return a.balance
+}
+
+func (a *account) deposit(n int) {
+	a.mu.Lock()
+	a.balance += n
+	a.mu.Unlock()
+}
+
+func transfer(from, to account) { // want `transfer passes lock by value: deadlock.Mutex`
+}
+
+func pointers(from, to *account, r registry) {
+}
+
+func literal() {
+	f := func(a account) {} // want `func literal passes lock by value: deadlock.Mutex`
+	_ = f
+}
+
+func assign(a *account) {
+	b := *a // want `assignment copies lock value: \*a contains deadlock.Mutex`
+	_ = b.balance
+	var c = *a // want `variable declaration copies lock value: \*a contains deadlock.Mutex`
+	_ = c.balance
+	d := account{}
+	_ = d.balance
+	e := &account{}
+	_ = e
+}
+
+func loop(r *registry) {
+	for _, a := range r.accounts { // want `range var a copies lock value: deadlock.Mutex`
+		_ = a.balance
+	}
+	for i := range r.accounts {
+		r.accounts[i].deposit(1)
+	}
+}
+
+func pass(a *account) {
+	show(*a) // want `call of show copies lock value: \*a contains deadlock.Mutex`
+}
+
+func show(v interface{}) {}
+
+func get(a *account) account {
+	return *a // want `return copies lock value: \*a contains deadlock.Mutex`
+}
+
+func wrap(a *account) []account {
+	return []account{*a} // want `literal copies lock value: \*a contains deadlock.Mutex`
+}
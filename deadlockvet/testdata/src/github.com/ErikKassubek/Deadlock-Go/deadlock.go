@@ -0,0 +1,25 @@
+// Package deadlock is a stub of Deadlock-Go for the tests of the analyzer
+package deadlock
+
+type Mutex struct {
+	locked bool
+}
+
+func NewLock() *Mutex { return &Mutex{} }
+
+func (m *Mutex) Lock()         {}
+func (m *Mutex) TryLock() bool { return true }
+func (m *Mutex) Unlock()       {}
+
+type RWMutex struct {
+	locked bool
+}
+
+func NewRWLock() *RWMutex { return &RWMutex{} }
+
+func (m *RWMutex) Lock()          {}
+func (m *RWMutex) RLock()         {}
+func (m *RWMutex) TryLock() bool  { return true }
+func (m *RWMutex) RTryLock() bool { return true }
+func (m *RWMutex) Unlock()        {}
+func (m *RWMutex) RUnlock()       {}
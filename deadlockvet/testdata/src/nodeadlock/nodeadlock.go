@@ -0,0 +1,9 @@
+// Package nodeadlock does not use Deadlock-Go, so its locks of the sync
+// package are not reported
+package nodeadlock
+
+import "sync"
+
+type service struct {
+	mu sync.Mutex
+}
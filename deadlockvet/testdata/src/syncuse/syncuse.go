@@ -0,0 +1,16 @@
+// Package syncuse uses the locks of the sync package together with
+// Deadlock-Go
+package syncuse
+
+import (
+	"sync"
+
+	deadlock "github.com/ErikKassubek/Deadlock-Go"
+)
+
+type service struct {
+	mu      *deadlock.Mutex
+	stateMu sync.Mutex    // want `sync.Mutex in a package which uses Deadlock-Go is not checked for deadlocks, use deadlock.NewLock instead`
+	cacheMu *sync.RWMutex // want `sync.RWMutex in a package which uses Deadlock-Go is not checked for deadlocks, use deadlock.NewRWLock instead`
+	wg      sync.WaitGroup
+}
@@ -0,0 +1,47 @@
+// Package unlock contains mismatched and missing releases of locks
+package unlock
+
+import (
+	"errors"
+
+	deadlock "github.com/ErikKassubek/Deadlock-Go"
+)
+
+type cache struct {
+	mu      *deadlock.RWMutex
+	entries map[string]string
+}
+
+func (c *cache) get(key string) string {
+	c.mu.RLock()
+	v := c.entries[key]
+	c.mu.Unlock() // want `Unlock of c.mu, which was locked with RLock in line 16`
+	return v
+}
+
+func (c *cache) set(key, value string) {
+	c.mu.Lock()
+	c.entries[key] = value
+	c.mu.RUnlock() // want `RUnlock of c.mu, which was locked with Lock in line 23`
+}
+
+func (c *cache) deferred(key string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.entries[key]
+}
+
+func (c *cache) update(key, value string) error {
+	c.mu.Lock() // want `Lock of c.mu is not followed by Unlock on all paths`
+	if _, ok := c.entries[key]; !ok {
+		return errors.New("not found") // want `this return may be reached without Unlock of c.mu locked in line 35`
+	}
+	c.entries[key] = value
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *cache) caller() {
+	// the lock is released by the caller
+	c.mu.Lock()
+}
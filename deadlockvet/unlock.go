@@ -0,0 +1,423 @@
+package deadlockvet
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlockvet
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+unlock.go
+This file implements the checks of the lock and unlock operations of a
+function. An unlock has to match the kind of the lock operation, and a lock
+which is released in the function has to be released on all paths.
+*/
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/cfg"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+// lock or unlock operation of a function
+type lockOp struct {
+	// the lock as expression, e.g. "s.mu"
+	key string
+	// name of the method, e.g. "Lock"
+	name string
+	// the call of the method
+	call *ast.CallExpr
+	// the statement of the call if the call is a statement, nil otherwise
+	stmt *ast.ExprStmt
+	// true if the call is deferred
+	deferred bool
+}
+
+// functions which never return
+var noReturnFuncs = map[string]bool{
+	"os.Exit":                   true,
+	"log.Fatal":                 true,
+	"log.Fatalf":                true,
+	"log.Fatalln":               true,
+	"log.Panic":                 true,
+	"log.Panicf":                true,
+	"log.Panicln":               true,
+	"runtime.Goexit":            true,
+	"(*testing.common).FailNow": true,
+	"(*testing.common).Fatal":   true,
+	"(*testing.common).Fatalf":  true,
+	"(*testing.common).SkipNow": true,
+	"(*testing.common).Skip":    true,
+	"(*testing.common).Skipf":   true,
+}
+
+// isAcquire checks if an operation acquires a lock
+//  Args:
+//   name (string): name of the method
+//  Returns:
+//   (bool): true for Lock, RLock, TryLock and RTryLock, false otherwise
+func isAcquire(name string) bool {
+	switch name {
+	case "Lock", "RLock", "TryLock", "RTryLock":
+		return true
+	}
+	return false
+}
+
+// isRelease checks if an operation releases a lock
+//  Args:
+//   name (string): name of the method
+//  Returns:
+//   (bool): true for Unlock and RUnlock, false otherwise
+func isRelease(name string) bool {
+	return name == "Unlock" || name == "RUnlock"
+}
+
+// isRead checks if an operation is a read operation of a rw-lock
+//  Args:
+//   name (string): name of the method
+//  Returns:
+//   (bool): true for RLock, RTryLock and RUnlock, false otherwise
+func isRead(name string) bool {
+	return name == "RLock" || name == "RTryLock" || name == "RUnlock"
+}
+
+// lockCall returns the operation if the call is a call of a method of
+// deadlock.Mutex or deadlock.RWMutex
+//  Args:
+//   pass (*analysis.Pass): the package
+//   call (*ast.CallExpr): the call
+//  Returns:
+//   (lockOp): the operation
+//   (bool): true if the call is an operation of a lock, false otherwise
+func lockCall(pass *analysis.Pass, call *ast.CallExpr) (lockOp, bool) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return lockOp{}, false
+	}
+	fn, ok := pass.TypesInfo.Uses[sel.Sel].(*types.Func)
+	if !ok {
+		return lockOp{}, false
+	}
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return lockOp{}, false
+	}
+	t := recv.Type()
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	if lockName(t) == "" || !(isAcquire(fn.Name()) || isRelease(fn.Name())) {
+		return lockOp{}, false
+	}
+	return lockOp{key: types.ExprString(sel.X), name: fn.Name(), call: call}, true
+}
+
+// collectOps returns the lock operations of a function in the order of the
+// source code. Nested functions are ignored, except for deferred function
+// literals, which are executed at the end of the function.
+//  Args:
+//   pass (*analysis.Pass): the package
+//   body (*ast.BlockStmt): the body of the function
+//  Returns:
+//   ([]lockOp): the operations
+func collectOps(pass *analysis.Pass, body *ast.BlockStmt) []lockOp {
+	ops := make([]lockOp, 0)
+
+	var collect func(n ast.Node, deferred bool)
+	collect = func(n ast.Node, deferred bool) {
+		ast.Inspect(n, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FuncLit:
+				return false
+			case *ast.DeferStmt:
+				if lit, ok := n.Call.Fun.(*ast.FuncLit); ok {
+					collect(lit.Body, true)
+				} else if op, ok := lockCall(pass, n.Call); ok {
+					op.deferred = true
+					ops = append(ops, op)
+				}
+				return false
+			case *ast.ExprStmt:
+				if call, ok := n.X.(*ast.CallExpr); ok {
+					if op, ok := lockCall(pass, call); ok {
+						op.stmt = n
+						op.deferred = deferred
+						ops = append(ops, op)
+						return false
+					}
+				}
+			case *ast.CallExpr:
+				if op, ok := lockCall(pass, n); ok {
+					op.deferred = deferred
+					ops = append(ops, op)
+				}
+			}
+			return true
+		})
+	}
+	collect(body, false)
+
+	return ops
+}
+
+// checkFunc checks the lock operations of a function
+//  Args:
+//   pass (*analysis.Pass): the package
+//   body (*ast.BlockStmt): the body of the function, nil for external
+//    functions
+//  Returns:
+//   nil
+func checkFunc(pass *analysis.Pass, body *ast.BlockStmt) {
+	if body == nil {
+		return
+	}
+
+	ops := collectOps(pass, body)
+	if len(ops) == 0 {
+		return
+	}
+
+	checkPairs(pass, ops)
+	checkPaths(pass, body, ops)
+}
+
+// checkPairs reports unlock operations whose kind does not match the kind of
+// the last lock operation of the same lock in the function
+//  Args:
+//   pass (*analysis.Pass): the package
+//   ops ([]lockOp): the operations of the function
+//  Returns:
+//   nil
+func checkPairs(pass *analysis.Pass, ops []lockOp) {
+	held := make(map[string][]lockOp)
+	for _, op := range ops {
+		if isAcquire(op.name) {
+			held[op.key] = append(held[op.key], op)
+			continue
+		}
+
+		// the lock may have been acquired by the caller
+		stack := held[op.key]
+		if len(stack) == 0 {
+			continue
+		}
+		last := stack[len(stack)-1]
+		held[op.key] = stack[:len(stack)-1]
+
+		if isRead(last.name) != isRead(op.name) {
+			pass.ReportRangef(op.call, "%s of %s, which was locked with %s in line %d",
+				op.name, op.key, last.name, pass.Fset.Position(last.call.Pos()).Line)
+		}
+	}
+}
+
+// checkPaths reports Lock and RLock operations after which the function can
+// return without releasing the lock. Only locks which are released in the
+// function and not by a deferred call are checked, because locks which are
+// never released in the function are normally released by the caller.
+//  Args:
+//   pass (*analysis.Pass): the package
+//   body (*ast.BlockStmt): the body of the function
+//   ops ([]lockOp): the operations of the function
+//  Returns:
+//   nil
+func checkPaths(pass *analysis.Pass, body *ast.BlockStmt, ops []lockOp) {
+	released := make(map[string]bool)
+	deferred := make(map[string]bool)
+	for _, op := range ops {
+		if isRelease(op.name) {
+			if op.deferred {
+				deferred[op.key] = true
+			} else {
+				released[op.key] = true
+			}
+		}
+	}
+
+	var g *cfg.CFG
+	noReturn := make(map[*ast.CallExpr]bool)
+
+	for _, op := range ops {
+		if (op.name != "Lock" && op.name != "RLock") || op.stmt == nil ||
+			op.deferred || deferred[op.key] || !released[op.key] {
+			continue
+		}
+
+		if g == nil {
+			g = cfg.New(body, func(call *ast.CallExpr) bool {
+				if mayReturn(pass, call) {
+					return true
+				}
+				noReturn[call] = true
+				return false
+			})
+		}
+
+		exit := unreleasedPath(pass, g, body, op, noReturn)
+		if !exit.IsValid() {
+			continue
+		}
+		unlock := "Unlock"
+		if op.name == "RLock" {
+			unlock = "RUnlock"
+		}
+		pass.ReportRangef(op.call, "%s of %s is not followed by %s on all paths",
+			op.name, op.key, unlock)
+		end := "this return"
+		if exit == body.Rbrace {
+			end = "the end of the function"
+		}
+		pass.Reportf(exit, "%s may be reached without %s of %s locked in line %d",
+			end, unlock, op.key, pass.Fset.Position(op.call.Pos()).Line)
+	}
+}
+
+// mayReturn checks if a call may return
+//  Args:
+//   pass (*analysis.Pass): the package
+//   call (*ast.CallExpr): the call
+//  Returns:
+//   (bool): false if the call is a call of panic or of a function which never
+//    returns, true otherwise
+func mayReturn(pass *analysis.Pass, call *ast.CallExpr) bool {
+	switch fn := typeutil.Callee(pass.TypesInfo, call).(type) {
+	case *types.Builtin:
+		return fn.Name() != "panic"
+	case *types.Func:
+		return !noReturnFuncs[fn.FullName()]
+	}
+	return true
+}
+
+// unreleasedPath searches a path from a lock operation to the end of the
+// function on which the lock is not released
+//  Args:
+//   pass (*analysis.Pass): the package
+//   g (*cfg.CFG): the control flow graph of the function
+//   body (*ast.BlockStmt): the body of the function
+//   op (lockOp): the lock operation
+//   noReturn (map[*ast.CallExpr]bool): calls which never return
+//  Returns:
+//   (token.Pos): position of the return or of the end of the function,
+//    token.NoPos if the lock is released on all paths
+func unreleasedPath(pass *analysis.Pass, g *cfg.CFG, body *ast.BlockStmt,
+	op lockOp, noReturn map[*ast.CallExpr]bool) token.Pos {
+
+	// find the block of the operation and the rest of the block
+	var start *cfg.Block
+	var rest []ast.Node
+outer:
+	for _, b := range g.Blocks {
+		for i, n := range b.Nodes {
+			if n == op.stmt {
+				start = b
+				rest = b.Nodes[i+1:]
+				break outer
+			}
+		}
+	}
+	if start == nil || releases(pass, rest, op.key) {
+		return token.NoPos
+	}
+	if exit := blockExit(start, body, noReturn); exit.IsValid() {
+		return exit
+	}
+
+	seen := make(map[*cfg.Block]bool)
+	var search func(blocks []*cfg.Block) token.Pos
+	search = func(blocks []*cfg.Block) token.Pos {
+		for _, b := range blocks {
+			if seen[b] {
+				continue
+			}
+			seen[b] = true
+
+			if releases(pass, b.Nodes, op.key) {
+				continue
+			}
+			if exit := blockExit(b, body, noReturn); exit.IsValid() {
+				return exit
+			}
+			if exit := search(b.Succs); exit.IsValid() {
+				return exit
+			}
+		}
+		return token.NoPos
+	}
+	return search(start.Succs)
+}
+
+// blockExit returns the position where the function is left at the end of a
+// block
+//  Args:
+//   b (*cfg.Block): the block
+//   body (*ast.BlockStmt): the body of the function
+//   noReturn (map[*ast.CallExpr]bool): calls which never return
+//  Returns:
+//   (token.Pos): position of the return statement or of the end of the
+//    function, token.NoPos if the function is not left at the end of b
+func blockExit(b *cfg.Block, body *ast.BlockStmt, noReturn map[*ast.CallExpr]bool) token.Pos {
+	if ret := b.Return(); ret != nil {
+		return ret.Pos()
+	}
+	if len(b.Succs) > 0 {
+		return token.NoPos
+	}
+
+	// the block ends with a call which never returns
+	if len(b.Nodes) > 0 {
+		if stmt, ok := b.Nodes[len(b.Nodes)-1].(*ast.ExprStmt); ok {
+			if call, ok := stmt.X.(*ast.CallExpr); ok && noReturn[call] {
+				return token.NoPos
+			}
+		}
+	}
+	return body.Rbrace
+}
+
+// releases checks if one of the nodes releases a lock
+//  Args:
+//   pass (*analysis.Pass): the package
+//   nodes ([]ast.Node): the nodes
+//   key (string): the lock as expression
+//  Returns:
+//   (bool): true if the lock is released, false otherwise
+func releases(pass *analysis.Pass, nodes []ast.Node, key string) bool {
+	found := false
+	for _, n := range nodes {
+		ast.Inspect(n, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FuncLit:
+				return false
+			case *ast.CallExpr:
+				if op, ok := lockCall(pass, n); ok && op.key == key && isRelease(op.name) {
+					found = true
+				}
+			}
+			return !found
+		})
+	}
+	return found
+}
@@ -1,3 +1,5 @@
+//go:build !deadlock_off
+
 package deadlock
 
 /*
@@ -37,25 +39,34 @@ acquired.
 // i.e. all lock which were already locked by the same routine, when
 // l was acquired.
 type dependency struct {
-	mu           mutexInt   // lock
-	holdingSet   []mutexInt // locks which where locked while mu was acquired
-	holdingCount int        // on how many locks does mu depend
+	mu           mutexInt     // lock
+	rLock        bool         // true if mu was acquired as r-lock
+	holdingSet   []mutexInt   // locks which where locked while mu was acquired
+	holdingCount int          // on how many locks does mu depend
+	muInfo       callerInfo   // position where mu was acquired
+	holdingInfo  []callerInfo // positions where the locks in holdingSet were acquired
 }
 
 // newDependency creates and returns a new dependency object
 //  Args:
 //   mu (mutexInt): lock of the dependency
+//   rLock (bool): true if mu was acquired as r-lock
 //   currentLocks ([]mutexInt): list of locks mu depends on
 //   numberOfLocks (int): number of locks lock depends on
+//   info (callerInfo): position where lock was acquired
+//   currentInfo ([]callerInfo): positions where the locks in currentLocks were acquired
 //  Returns:
 //   (dependency) : the created dependency
-func newDependency(lock mutexInt, currentLocks []mutexInt,
-	numberOfLocks int) dependency {
+func newDependency(lock mutexInt, rLock bool, currentLocks []mutexInt,
+	numberOfLocks int, info callerInfo, currentInfo []callerInfo) dependency {
 	// create dependency
 	d := dependency{
 		mu:           lock,
+		rLock:        rLock,
 		holdingCount: numberOfLocks,
-		holdingSet:   make([]mutexInt, opts.maxNumberOfDependentLocks),
+		holdingSet:   make([]mutexInt, lock.getDetector().opts.maxNumberOfDependentLocks),
+		muInfo:       info,
+		holdingInfo:  make([]callerInfo, numberOfLocks),
 	}
 
 	// copy currentLocks into d.holding set
@@ -63,6 +74,9 @@ func newDependency(lock mutexInt, currentLocks []mutexInt,
 		d.holdingSet = append(d.holdingSet, currentLocks[i])
 	}
 
+	// copy the positions of the acquisitions of currentLocks
+	copy(d.holdingInfo, currentInfo[:numberOfLocks])
+
 	return d
 }
 
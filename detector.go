@@ -1,3 +1,5 @@
+//go:build !deadlock_off
+
 package deadlock
 
 /*
@@ -26,19 +28,17 @@ Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
 /*
 detector.go
 This file contains all the functionality to detect circles in the lock-trees
-and therefor actual or potential deadlocks. It implements the periodical
-detection during the runtime of the program as well as the comprehensive
-detection after the program has finished.
-The periodical detection searches for actual deadlocks and can stop the
-program if it is in a deadlock situation.
+and the wait-for graph and therefor actual or potential deadlocks. It
+implements the periodical detection during the runtime of the program as well
+as the comprehensive detection after the program has finished.
+The periodical detection searches for actual deadlocks in the wait-for graph
+every time a routine starts to wait for a lock.
 The comprehensive detection should run as soon as the actual program has finished.
 It is based on iGoodLock and reports potential deadlocks in the code.
 */
 
 import (
 	"fmt"
-	"os"
-	"runtime"
 )
 
 // ================ Comprehensive Detection ================
@@ -53,33 +53,112 @@ import (
 // program.
 //  Returns:
 //   nil
+func (d *Detector) FindPotentialDeadlocks() {
+	d.DetectNow()
+}
+
+// FindPotentialDeadlocks calls Detector.FindPotentialDeadlocks of the
+// default detector
+//  Returns:
+//   nil
 func FindPotentialDeadlocks() {
+	defaultDetector.FindPotentialDeadlocks()
+}
+
+// DetectNow runs the comprehensive detection on the lock trees collected so
+// far and reports the found cycles like FindPotentialDeadlocks. In contrast
+// to FindPotentialDeadlocks, the reports are also returned, e.g. to check
+// them in tests.
+//  Returns:
+//   ([]*Report): reports of the cycles which were found for the first time
+func (d *Detector) DetectNow() []*Report {
+	// write the collected reports to the outputs after the detection
+	defer d.flushReports()
+
 	// check if comprehensive detection is disabled, and if do abort deadlock
 	//detection
-	if !opts.comprehensiveDetection {
-		return
+	if !d.opts.comprehensiveDetection {
+		return nil
 	}
 
+	// report all unique cycles
+	cycles := d.findCycles()
+	reports := cycles.newReports()
+	cycles.report()
+	return reports
+}
+
+// DetectNow calls Detector.DetectNow of the default detector
+//  Returns:
+//   ([]*Report): reports of the cycles which were found for the first time
+func DetectNow() []*Report {
+	return defaultDetector.DetectNow()
+}
+
+// Reset removes the lock trees of all routines and forgets the reported
+// cycles and their marks in the lock graph, so that the next detection only
+// contains the locks acquired after the reset, e.g. in a single test. The
+// collected statistics of the profiling are removed as well. Reset can be
+// called while other routines hold locks, e.g. background workers. These
+// routines keep the locks they hold, so that releasing them and acquiring
+// further locks is still tracked correctly.
+//  Returns:
+//   nil
+func (d *Detector) Reset() {
+	d.resetRoutines()
+
+	d.reportedCyclesLock.Lock()
+	d.reportedCycles = make(map[string]*foundCycle)
+	d.reportedCyclesLock.Unlock()
+
+	d.cycleEdgesLock.Lock()
+	d.cycleEdges = make(map[[2]uintptr]struct{})
+	d.cycleEdgesLock.Unlock()
+
+	d.resetProfile()
+}
+
+// Reset calls Detector.Reset of the default detector
+//  Returns:
+//   nil
+func Reset() {
+	defaultDetector.Reset()
+}
+
+// findCycles runs the detection of potential deadlocks without reporting
+// the found cycles
+//  Returns:
+//   (*cycleCollection): the found cycles
+func (d *Detector) findCycles() *cycleCollection {
+	// the routines are locked during the detection, so that their lock trees
+	// do not change while they are searched
+	routines := d.lockRoutines()
+	defer unlockRoutines(routines)
+
 	// only run detector if at least two routines were running during the
 	// execution of the program
-	if numberRoutines > 1 {
+	if len(routines) > 1 {
 		// abort check if the lock trees contain less than 2 unique dependencies
-		if !isNumberDependenciesGreaterEqualTwo() {
-			return
+		if !isNumberDependenciesGreaterEqualTwo(routines) {
+			return newCycleCollection(d)
 		}
 
 		// start the detection of potential deadlocks
-		detect()
+		return d.detect(routines)
 	}
+
+	return newCycleCollection(d)
 }
 
 // isNumberDependenciesGreaterEqualTwo counts the number of unique dependencies in
 // all and checks if it is greater or equal two lock trees.
 // It is not necessary to run comprehensive detection if less then
 // two unique dependencies exists.
+//  Args:
+//   routines ([]*routine): the routines
 //  Returns:
 //   (bool) : true, if number of unique dependencies is greater or equal than 2,false otherwise
-func isNumberDependenciesGreaterEqualTwo() bool {
+func isNumberDependenciesGreaterEqualTwo(routines []*routine) bool {
 	// number of already found unique dependencies
 	depCount := 0
 
@@ -90,8 +169,7 @@ func isNumberDependenciesGreaterEqualTwo() bool {
 	dependencyMap := make(map[string]struct{})
 
 	// parse all routines
-	for i := 0; i < numberRoutines; i++ {
-		current := routines[i]
+	for _, current := range routines {
 
 		// parse routine i
 		for j := 0; j < current.depCount; j++ {
@@ -137,9 +215,11 @@ func getDependencyString(str *string, dep *dependency) {
 }
 
 // detect runs the detection for loops in the lock trees
+//  Args:
+//   routines ([]*routine): the routines
 //  Returns:
-//   nil
-func detect() {
+//   (*cycleCollection): the found cycles
+func (d *Detector) detect(routines []*routine) *cycleCollection {
 	// visiting gets set to index of the routine on which the search for circles is started
 	var visiting int
 
@@ -153,12 +233,14 @@ func detect() {
 	// of the search.
 	// They can also be temporarily ignored, if a dependency of this routine
 	// is already in the path which is currently explored
-	isTraversed := make([]bool, numberRoutines)
+	isTraversed := make([]bool, len(routines))
 
-	// traverse all routines as starting routine for the loop search
-	for i := 0; i < numberRoutines; i++ {
-		routine := routines[i]
+	// The same cycle can be found multiple times. The found cycles are
+	// therefore collected and reported after the search has finished.
+	cycles := newCycleCollection(d)
 
+	// traverse all routines as starting routine for the loop search
+	for i, routine := range routines {
 		visiting = i
 
 		// traverse all dependencies of the given routine as starting routine
@@ -169,15 +251,17 @@ func detect() {
 
 			// push the dependency on the stack as first element of the currently
 			// explored path
-			stack.push(dep, i)
+			stack.push(dep, i, routine)
 
 			// start the depth-first search to find potential circular paths
-			dfs(&stack, visiting, &isTraversed)
+			d.dfs(routines, &stack, visiting, &isTraversed, cycles)
 
 			// remove dep from the stack
 			stack.pop()
 		}
 	}
+
+	return cycles
 }
 
 // dfs runs the recursive depth-first search.
@@ -185,17 +269,20 @@ func detect() {
 // After a new dependency is added to the currently explored path, it is checked,
 // if the path forms a circle.
 //  Args:
+//   routines ([]*routine): the routines
 //   stack (*depStack): stack witch represent the currently explored path
 //   visiting int: index of the routine of the first element in the currently explored path
 //   isTraversed (*([]bool)): list which stores which routines have already been traversed
 //    (either as starting routine or as a routine which already has a dep in the current path)
+//   cycles (*cycleCollection): collection in which found cycles are stored
 //  Returns:
 //   nil
-func dfs(stack *depStack, visiting int, isTraversed *([]bool)) {
+func (d *Detector) dfs(routines []*routine, stack *depStack, visiting int,
+	isTraversed *([]bool), cycles *cycleCollection) {
 	// Traverse through all routines to find the potential next step in the path.
 	// Routines with index <= visiting have already been used as starting routine
 	// and therefore don't have to been considered again.
-	for i := visiting + 1; i < numberRoutines; i++ {
+	for i := visiting + 1; i < len(routines); i++ {
 		routine := routines[i]
 
 		// continue if the routine has already been traversed
@@ -207,20 +294,20 @@ func dfs(stack *depStack, visiting int, isTraversed *([]bool)) {
 		for j := 0; j < routine.depCount; j++ {
 			dep := routine.dependencies[j]
 			// check if adding dep to the stack would still be a valid path
-			if isChain(stack, dep, i) {
+			if isChain(stack, dep, routine) {
 				// check if adding dep to the stack would lead to a cycle
-				if isCycleChain(stack, dep, i) {
-					// report the found potential deadlock
-					stack.push(dep, j)
-					reportDeadlock(stack)
+				if isCycleChain(stack, dep, routine) {
+					// store the found potential deadlock
+					stack.push(dep, i, routine)
+					cycles.add(stack)
 					stack.pop()
 				} else { // the path is not a cycle yet
 					// add dep to the current path
-					stack.push(dep, i)
+					stack.push(dep, i, routine)
 					(*isTraversed)[i] = true
 
 					// call dfs recursively to traverse the path further
-					dfs(stack, visiting, isTraversed)
+					d.dfs(routines, stack, visiting, isTraversed, cycles)
 
 					// dep did not lead to a cycle in the lock trees.
 					// It is removed to explore different paths
@@ -234,183 +321,123 @@ func dfs(stack *depStack, visiting int, isTraversed *([]bool)) {
 
 // ================ Periodical Detection ================
 
-// periodicalDetection is the main function to start the periodical detection.
-// It is called periodically to detect if the program is in a local deadlock
-// state i.e. a state in which only a subset of the running routines are in
-// a deadlock position.
-//  The program will be terminated if such a situation occurs and the comprehensive
-//  detection will automatically be started.
-//  If the program is in a total deadlock, i.e. no routine is running anymore,
-//  it is normally automatically terminated my the go-runtime deadlock detection.
-//  In this case the comprehensive detection can not be started.
-// To detect such local deadlocks, the detector uses the dependency of each
-// routine, which was last added to the routine and searches for circles in
-// this set of dependencies.
+// findWaitCycle searches for a cycle in the wait-for graph which contains
+// the wait w. The nodes of the wait-for graph are the routines. A routine
+// which waits for a lock has an edge to every routine which holds this lock,
+// except if both only need the lock as r-lock.
+// The search is run every time a routine starts to wait for a lock. A cycle
+// in the wait-for graph means, that the routines in the cycle are actually in
+// a deadlock. w does not need to be registered yet, but lockWaitLock must be
+// held.
 //  Args:
-//   lastHolding (*[]mutexInt): list of the dependencies which were considered
-//    in the last run
+//   w (*lockWait): the wait the cycle must contain
 //  Returns:
-//   nil
-func periodicalDetection(lastHolding *[]mutexInt) {
-	// only check if at least two routines are currently running
-	if runtime.NumGoroutine() < 2 {
-		return
-	}
+//   ([]*lockWait): the waits of the routines in the cycle in the order of
+//    the cycle, starting with w, or nil if no cycle exists
+func (d *Detector) findWaitCycle(w *lockWait) []*lockWait {
+	// the currently explored path in the wait-for graph
+	path := []*lockWait{w}
 
-	// A stack is used to represent the currently explored path in the lock trees.
-	// A dependency is added to the path by pushing it on top of the stack.
+	// every routine can only be used once in the path
+	isTraversed := map[int64]bool{w.goID: true}
 
-	// the detection is only run if the number of routines which hold at least two
-	// locks is at least 2 and the situation has changed since the late periodical check
-	nrThreadsHoldingLocks := 0
-	sthNew := false
-
-	// traverse all routines
-	for index, r := range routines {
-		// check if the routine holds at least two lock and the last added dependency
-		// has changed since the last check
-		holds := r.holdingCount - 1
-		if holds >= 0 && (*lastHolding)[index] != r.holdingSet[holds] {
-			(*lastHolding)[index] = r.holdingSet[holds]
-			sthNew = true
-			if holds > 0 {
-				nrThreadsHoldingLocks++
-			}
-		} else if holds < 0 && (*lastHolding)[index] != nil {
-			(*lastHolding)[index] = nil
-			sthNew = true
-		}
-	}
-
-	// abort the detection if nothing has changed or not enough routines hold locks
-	if !sthNew || nrThreadsHoldingLocks <= 1 {
-		return
+	if d.dfsWait(w, &path, isTraversed) {
+		return path
 	}
-
-	// run the detection
-	detectionPeriodical(lastHolding)
+	return nil
 }
 
-// detectPeriodical starts the search for local deadlocks.
-// It uses depth-first search to search for cyclic chains in the set of
-// dependencies which contain the dependencies which were last added to each
-// routine
-// 	Args:
-//   lastHolding (*[]mutexInt): list with dependencies
+// dfsWait runs the recursive depth-first search in the wait-for graph
+//  Args:
+//   w (*lockWait): the last wait in the currently explored path
+//   path (*[]*lockWait): the currently explored path, starting with the wait
+//    the cycle must contain
+//   isTraversed (map[int64]bool): routines which have already been traversed
 //  Returns:
-//   nil
-func detectionPeriodical(lastHolding *[]mutexInt) {
-	// A stack is used to represent the currently explored path in the lock trees.
-	// A dependency is added to the path by pushing it on top of the stack.
-	stack := newDepStack()
-
-	// every dependency can only be used once in the path
-	isTraversed := make([]bool, opts.maxRoutines)
+//   (bool): true if a cycle was found, false otherwise
+func (d *Detector) dfsWait(w *lockWait, path *[]*lockWait, isTraversed map[int64]bool) bool {
+	start := (*path)[0]
+
+	// traverse all routines which hold the lock or the gate w waits for
+	for _, h := range d.getWaitHolders(w) {
+		// two r-locks do not block each other
+		if w.rLock && h.rLock {
+			continue
+		}
 
-	// traverse all routines as starting routine
-	for index, r := range routines {
-		// routines with an index >= routinesIndex have not been used in the program
-		if index >= numberRoutines {
-			break
+		// the path leads back to the first routine -> cycle
+		if h.goID == start.goID {
+			return true
 		}
 
-		// continue if the routine has not acquired a dependency
-		if r.curDep == nil {
+		if isTraversed[h.goID] {
 			continue
 		}
+		isTraversed[h.goID] = true
 
-		isTraversed[index] = true
+		// only a holder which waits itself can continue the path
+		next, ok := d.lockWaits[h.goID]
+		if !ok {
+			continue
+		}
 
-		// add the dependency as first dependency of the path to the stack and
-		// start the recursive search for a cyclic path
-		stack.push(r.curDep, index)
-		dfsPeriodical(&stack, index, isTraversed, lastHolding)
+		*path = append(*path, next)
+		if d.dfsWait(next, path, isTraversed) {
+			return true
+		}
 
-		// if no cycle is found with this dependency it is removed from the path
-		stack.pop()
-		r.curDep = nil
+		// if no cycle has been found with next, it is removed from the path
+		*path = (*path)[:len(*path)-1]
 	}
+
+	return false
 }
 
-// dfsPeriodical runs the recursive depth-first search.
-// Only paths which build a valid chain are explored.
-// After a new dependency is added to the currently explored path, it is checked,
-// if the path forms a circle.
+// checkWaitCycle is called if a routine is still waiting for a lock after
+// the threshold of the periodical detection. It searches again for a cycle
+// in the wait-for graph containing the wait and reports it. It is called in
+// the waiting routine, so the deadlock is handled in this routine.
 //  Args:
-//   stack (*depStack): stack witch represent the currently explored path
-//   visiting int: index of the routine of the first element in the currently explored path
-//   isTraversed (*([]bool)): list which stores which routines have already been traversed
-//    (either as starting routine or as a routine which already has a dep in the current path)
-//   lastHolding (*[]mutexInt): list with dependencies
+//   w (*lockWait): the wait
 //  Returns:
 //   nil
-func dfsPeriodical(stack *depStack, visiting int, isTraversed []bool,
-	lastHolding *[]mutexInt) {
-	// Traverse through all routines to find the potential next step in the path.
-	// Routines with index <= visiting have already been used as starting routine
-	// and therefore don't have to been considered again.
-	for i := visiting + 1; i < numberRoutines; i++ {
-		r := routines[i]
-
-		// continue if the routine has no current dependency or has already be traversed
-		if r.curDep == nil || isTraversed[i] {
-			continue
-		}
+func (d *Detector) checkWaitCycle(w *lockWait) {
+	d.lockWaitLock.Lock()
 
-		dep := r.curDep
+	// the wait may have ended or already been reported
+	if d.lockWaits[w.goID] != w || w.deadlockReported {
+		d.lockWaitLock.Unlock()
+		return
+	}
 
-		// check if adding dep to the current path would lead to a valid dependency
-		// chain
-		if !isChain(stack, dep, i) {
-			continue
-		}
+	cycle := d.findWaitCycle(w)
+	if cycle == nil {
+		d.lockWaitLock.Unlock()
+		return
+	}
+	for _, c := range cycle {
+		c.deadlockReported = true
+	}
+	r := newReportActualDeadlock(cycle)
+	d.lockWaitLock.Unlock()
 
-		// check if adding dep to the curring path would lead to a cyclic dependency
-		// chain. This would indicate a deadlock.
-		if isCycleChain(stack, dep, i) {
-			stack.push(dep, i)
-
-			// check if the last added dependency in on of the routines in the path
-			// has changed since the beginning of the detection. In this case, the
-			// program will assume it was a false alarm and will not terminate the
-			// program
-			sthNew := false
-
-			// traverse alle routines in the current dependency chain
-			for cl := stack.stack.next; cl != nil; cl = cl.next {
-				routineInChain := routines[cl.index]
-
-				// check if the last added dependency has changed
-				holds := routineInChain.holdingCount - 1
-				if (holds >= 0 &&
-					(*lastHolding)[cl.index] != routineInChain.holdingSet[holds]) ||
-					(holds < 0 && (*lastHolding)[cl.index] != nil) {
-					sthNew = true
-					break
-				}
-			}
+	d.foundLocalDeadlock(r)
+}
 
-			// if nothing has changed the program assumes a deadlock.
-			// Therefore it reports the deadlock, starts the comprehensive detection
-			// to search for other possible deadlocks and terminates the program.
-			if !sthNew {
-				reportDeadlockPeriodical()
-				FindPotentialDeadlocks()
-				os.Exit(2)
-			}
-			stack.pop()
-		} else {
-			// if the chain is not a cycle, the dependency is added to the current
-			// path and the search is continued recursively
-			isTraversed[numberRoutines] = true
-			stack.push(dep, numberRoutines)
-			dfsPeriodical(stack, visiting, isTraversed, lastHolding)
-
-			// if no cycle has been found with dep, it is removed from the path
-			stack.pop()
-			isTraversed[numberRoutines] = false
-		}
+// foundLocalDeadlock is called if the periodical detection found a local
+// deadlock. It reports the deadlock and reacts according to the policy for
+// deadlocks, unless the deadlock was expected by a running confirmation.
+//  Args:
+//   r (*Report): report of the deadlock
+//  Returns:
+//   nil
+func (d *Detector) foundLocalDeadlock(r *Report) {
+	if d.observeDeadlock(r) {
+		return
 	}
+
+	d.reportDeadlockPeriodical(r)
+	d.handleDeadlock(r)
 }
 
 // ================ Checks for chains and Cycles ================
@@ -427,10 +454,10 @@ func dfsPeriodical(stack *depStack, visiting int, isTraversed []bool,
 //   stack (*depStack): stack representing the current path
 //   dep (*dependency): dependency for which it should be checked if it can be
 //    added to the path
-//   routineIndex (int): index of the routine the dependency is from
+//   r (*routine): routine the dependency is from
 //  Returns:
 //   (bool): true if dep can be added to the current path, false otherwise
-func isChain(stack *depStack, dep *dependency, routineIndex int) bool {
+func isChain(stack *depStack, dep *dependency, r *routine) bool {
 	// the mutex of the depEntry at the top of the stack mut be in the
 	// holding set of dep
 	found := false
@@ -438,7 +465,7 @@ func isChain(stack *depStack, dep *dependency, routineIndex int) bool {
 		mutexInHs := dep.holdingSet[i]
 		if mutexHaveEqualLock(mutexInHs, stack.top.depEntry.mu) {
 			// if mutexInHs is read, the mutex at the top of the stack can not also be read
-			if !(mutexInHs.getRLock(routineIndex) && stack.top.depEntry.mu.getRLock(stack.top.index)) {
+			if !(mutexInHs.getRLock(r.goID) && stack.top.isRLock(stack.top.depEntry.mu)) {
 				found = true
 				break
 			}
@@ -461,7 +488,7 @@ func isChain(stack *depStack, dep *dependency, routineIndex int) bool {
 				lockInDepHs := dep.holdingSet[i]
 				lockInCHoldingSet := c.depEntry.holdingSet[j]
 				if mutexHaveEqualLock(lockInDepHs, lockInCHoldingSet) {
-					if !(lockInCHoldingSet.getRLock(c.index) && lockInDepHs.getRLock(routineIndex)) {
+					if !(c.isRLock(lockInCHoldingSet) && lockInDepHs.getRLock(r.goID)) {
 						return false
 					}
 				}
@@ -484,12 +511,12 @@ func isChain(stack *depStack, dep *dependency, routineIndex int) bool {
 //  stack (*depStack): stack representing the current path
 //  dep (*dependency): dependency for which it should be checked if adding dep
 //   to the path would lead to a cyclic path
-//  routineIndex (int): index of the routine from which dep originated
+//  r (*routine): routine from which dep originated
 // Returns:
 //  (bool): true if dep can be added to the current path to create a valid cyclic
 //   chain, false if the path is no cycle, or it contains RW-lock with which
 //   the cycle does not indicate a deadlock
-func isCycleChain(dStack *depStack, dep *dependency, routineIndex int) bool {
+func isCycleChain(dStack *depStack, dep *dependency, r *routine) bool {
 	// the mutex dep must be in the holding set of the depEntry at the bottom of
 	// the stack
 	found := false
@@ -497,7 +524,7 @@ func isCycleChain(dStack *depStack, dep *dependency, routineIndex int) bool {
 		mutexInHs := dStack.stack.next.depEntry.holdingSet[i]
 		if mutexHaveEqualLock(mutexInHs, dep.mu) {
 			// if mutexInHs is read, the mutex at the top of the stack can not also be read
-			if !(mutexInHs.getRLock(dStack.stack.index) && dep.mu.getRLock(routineIndex)) {
+			if !(dStack.stack.isRLock(mutexInHs) && dep.mu.getRLock(r.goID)) {
 				found = true
 				break
 			}
@@ -0,0 +1,610 @@
+//go:build deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+detectorOff.go
+This file implements the detector for the build with the tag deadlock_off.
+All options and detection functions exist, so that the same code can be
+built with and without the tag, but they have no effect.
+*/
+
+import (
+	"io"
+	"time"
+)
+
+// Detector is disabled by the tag deadlock_off
+type Detector struct{}
+
+// the detector used by the package level functions
+var defaultDetector = &Detector{}
+
+// Default returns the detector used by the package level functions
+//  Returns:
+//   (*Detector): the default detector
+func Default() *Detector {
+	return defaultDetector
+}
+
+// NewDetector creates a new detector, which does not detect anything
+//  Returns:
+//   (*Detector): the detector
+func NewDetector() *Detector {
+	return &Detector{}
+}
+
+// Close does nothing with the tag deadlock_off
+//  Returns:
+//   nil
+func (d *Detector) Close() {}
+
+// FindPotentialDeadlocks does nothing with the tag deadlock_off
+//  Returns:
+//   nil
+func (d *Detector) FindPotentialDeadlocks() {}
+
+// FindPotentialDeadlocks does nothing with the tag deadlock_off
+//  Returns:
+//   nil
+func FindPotentialDeadlocks() {}
+
+// DetectNow does nothing with the tag deadlock_off
+//  Returns:
+//   ([]*Report): always nil
+func (d *Detector) DetectNow() []*Report {
+	return nil
+}
+
+// DetectNow does nothing with the tag deadlock_off
+//  Returns:
+//   ([]*Report): always nil
+func DetectNow() []*Report {
+	return nil
+}
+
+// Reset does nothing with the tag deadlock_off
+//  Returns:
+//   nil
+func (d *Detector) Reset() {}
+
+// Reset does nothing with the tag deadlock_off
+//  Returns:
+//   nil
+func Reset() {}
+
+// ReleaseLocks does nothing with the tag deadlock_off
+//  Returns:
+//   nil
+func (d *Detector) ReleaseLocks() {}
+
+// ReleaseLocks does nothing with the tag deadlock_off
+//  Returns:
+//   nil
+func ReleaseLocks() {}
+
+// InterceptDeadlocks does nothing with the tag deadlock_off, because no
+// deadlocks are detected
+//  Returns:
+//   (func()): function which does nothing
+func (d *Detector) InterceptDeadlocks(handler func(*DeadlockError)) (stop func()) {
+	return func() {}
+}
+
+// InterceptDeadlocks does nothing with the tag deadlock_off, because no
+// deadlocks are detected
+//  Returns:
+//   (func()): function which does nothing
+func InterceptDeadlocks(handler func(*DeadlockError)) (stop func()) {
+	return func() {}
+}
+
+// ConfirmPotentialDeadlocks runs the workload once without any detection
+//  Returns:
+//   ([]*Report): always nil
+func (d *Detector) ConfirmPotentialDeadlocks(workload func(), options ConfirmOptions) []*Report {
+	workload()
+	return nil
+}
+
+// ConfirmPotentialDeadlocks runs the workload once without any detection
+//  Returns:
+//   ([]*Report): always nil
+func ConfirmPotentialDeadlocks(workload func(), options ConfirmOptions) []*Report {
+	workload()
+	return nil
+}
+
+// RunSchedule runs body without perturbing the schedule and without any
+// detection
+//  Returns:
+//   (*DeadlockError): always nil
+func (d *Detector) RunSchedule(schedule []byte, body func()) *DeadlockError {
+	body()
+	return nil
+}
+
+// RunSchedule runs body without perturbing the schedule and without any
+// detection
+//  Returns:
+//   (*DeadlockError): always nil
+func RunSchedule(schedule []byte, body func()) *DeadlockError {
+	body()
+	return nil
+}
+
+// LoadAvoidanceSignatures does nothing with the tag deadlock_off
+//  Returns:
+//   (error): always nil
+func (d *Detector) LoadAvoidanceSignatures(r io.Reader) error {
+	return nil
+}
+
+// LoadAvoidanceSignatures does nothing with the tag deadlock_off
+//  Returns:
+//   (error): always nil
+func LoadAvoidanceSignatures(r io.Reader) error {
+	return nil
+}
+
+// ReplayTrace does nothing with the tag deadlock_off
+//  Returns:
+//   (error): always nil
+func (d *Detector) ReplayTrace(r io.Reader, options ReplayOptions) error {
+	return nil
+}
+
+// ReplayTrace does nothing with the tag deadlock_off
+//  Returns:
+//   (error): always nil
+func ReplayTrace(r io.Reader, options ReplayOptions) error {
+	return nil
+}
+
+// WriteLockGraph does nothing with the tag deadlock_off
+//  Returns:
+//   (error): always nil
+func (d *Detector) WriteLockGraph(w io.Writer, format GraphFormat) error {
+	return nil
+}
+
+// WriteLockGraph does nothing with the tag deadlock_off
+//  Returns:
+//   (error): always nil
+func WriteLockGraph(w io.Writer, format GraphFormat) error {
+	return nil
+}
+
+// Stats does nothing with the tag deadlock_off
+//  Returns:
+//   ([]LockStats): always an empty list
+func (d *Detector) Stats() []LockStats {
+	return make([]LockStats, 0)
+}
+
+// Stats does nothing with the tag deadlock_off
+//  Returns:
+//   ([]LockStats): always an empty list
+func Stats() []LockStats {
+	return make([]LockStats, 0)
+}
+
+// WriteProfile does nothing with the tag deadlock_off
+//  Returns:
+//   (error): always nil
+func (d *Detector) WriteProfile(w io.Writer) error {
+	return nil
+}
+
+// WriteProfile does nothing with the tag deadlock_off
+//  Returns:
+//   (error): always nil
+func WriteProfile(w io.Writer) error {
+	return nil
+}
+
+// State does nothing with the tag deadlock_off
+//  Returns:
+//   (DetectorState): empty state
+func (d *Detector) State() DetectorState {
+	return DetectorState{
+		Routines: make([]RoutineState, 0),
+		Waits:    make([]WaitState, 0),
+	}
+}
+
+// State does nothing with the tag deadlock_off
+//  Returns:
+//   (DetectorState): empty state
+func State() DetectorState {
+	return defaultDetector.State()
+}
+
+// ====== OPTIONS ==============================================================
+
+// SetActivated has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetActivated(enable bool) bool {
+	return true
+}
+
+// SetActivated has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetActivated(enable bool) bool {
+	return true
+}
+
+// SetPeriodicDetection has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetPeriodicDetection(enable bool) bool {
+	return true
+}
+
+// SetPeriodicDetection has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetPeriodicDetection(enable bool) bool {
+	return true
+}
+
+// SetComprehensiveDetection has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetComprehensiveDetection(enable bool) bool {
+	return true
+}
+
+// SetComprehensiveDetection has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetComprehensiveDetection(enable bool) bool {
+	return true
+}
+
+// SetPeriodicDetectionTime has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetPeriodicDetectionTime(seconds int) bool {
+	return true
+}
+
+// SetPeriodicDetectionTime has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetPeriodicDetectionTime(seconds int) bool {
+	return true
+}
+
+// SetPeriodicDetectionThreshold has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetPeriodicDetectionThreshold(threshold time.Duration) bool {
+	return true
+}
+
+// SetPeriodicDetectionThreshold has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetPeriodicDetectionThreshold(threshold time.Duration) bool {
+	return true
+}
+
+// SetCollectCallStack has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetCollectCallStack(enable bool) bool {
+	return true
+}
+
+// SetCollectCallStack has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetCollectCallStack(enable bool) bool {
+	return true
+}
+
+// SetCollectSingleLevelLockInformation has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetCollectSingleLevelLockInformation(enable bool) bool {
+	return true
+}
+
+// SetCollectSingleLevelLockInformation has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetCollectSingleLevelLockInformation(enable bool) bool {
+	return true
+}
+
+// SetDoubleLockingDetection has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetDoubleLockingDetection(enable bool) bool {
+	return true
+}
+
+// SetDoubleLockingDetection has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetDoubleLockingDetection(enable bool) bool {
+	return true
+}
+
+// SetMaxDependencies has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetMaxDependencies(number int) bool {
+	return true
+}
+
+// SetMaxDependencies has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetMaxDependencies(number int) bool {
+	return true
+}
+
+// SetMaxNumberOfDependentLocks has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetMaxNumberOfDependentLocks(number int) bool {
+	return true
+}
+
+// SetMaxNumberOfDependentLocks has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetMaxNumberOfDependentLocks(number int) bool {
+	return true
+}
+
+// SetMaxRoutines has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetMaxRoutines(number int) bool {
+	return true
+}
+
+// SetMaxRoutines has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetMaxRoutines(number int) bool {
+	return true
+}
+
+// SetMaxCallStackSize has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetMaxCallStackSize(number int) bool {
+	return true
+}
+
+// SetMaxCallStackSize has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetMaxCallStackSize(number int) bool {
+	return true
+}
+
+// SetJSONOutput has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetJSONOutput(w io.Writer) bool {
+	return true
+}
+
+// SetJSONOutput has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetJSONOutput(w io.Writer) bool {
+	return true
+}
+
+// SetJSONOutputFile has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetJSONOutputFile(path string) bool {
+	return true
+}
+
+// SetJSONOutputFile has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetJSONOutputFile(path string) bool {
+	return true
+}
+
+// SetSARIFOutputFile has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetSARIFOutputFile(path string) bool {
+	return true
+}
+
+// SetSARIFOutputFile has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetSARIFOutputFile(path string) bool {
+	return true
+}
+
+// SetHTMLOutputFile has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetHTMLOutputFile(path string) bool {
+	return true
+}
+
+// SetHTMLOutputFile has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetHTMLOutputFile(path string) bool {
+	return true
+}
+
+// SetLockWaitTimeout has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetLockWaitTimeout(timeout time.Duration) bool {
+	return true
+}
+
+// SetLockWaitTimeout has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetLockWaitTimeout(timeout time.Duration) bool {
+	return true
+}
+
+// SetScheduleTimeout has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetScheduleTimeout(timeout time.Duration) bool {
+	return true
+}
+
+// SetScheduleTimeout has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetScheduleTimeout(timeout time.Duration) bool {
+	return true
+}
+
+// SetDeadlockAvoidance has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetDeadlockAvoidance(enable bool) bool {
+	return true
+}
+
+// SetDeadlockAvoidance has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetDeadlockAvoidance(enable bool) bool {
+	return true
+}
+
+// SetHistoryFile has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetHistoryFile(path string) bool {
+	return true
+}
+
+// SetHistoryFile has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetHistoryFile(path string) bool {
+	return true
+}
+
+// SetSuppressKnownDeadlocks has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetSuppressKnownDeadlocks(enable bool) bool {
+	return true
+}
+
+// SetSuppressKnownDeadlocks has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetSuppressKnownDeadlocks(enable bool) bool {
+	return true
+}
+
+// SetTraceFile has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetTraceFile(path string) bool {
+	return true
+}
+
+// SetTraceFile has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetTraceFile(path string) bool {
+	return true
+}
+
+// SetProfiling has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetProfiling(enable bool) bool {
+	return true
+}
+
+// SetProfiling has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetProfiling(enable bool) bool {
+	return true
+}
+
+// SetDeadlockPolicy has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetDeadlockPolicy(policy Policy) bool {
+	return true
+}
+
+// SetDeadlockPolicy has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetDeadlockPolicy(policy Policy) bool {
+	return true
+}
+
+// SetExitCode has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetExitCode(code int) bool {
+	return true
+}
+
+// SetExitCode has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetExitCode(code int) bool {
+	return true
+}
+
+// SetDeadlockCallback has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func (d *Detector) SetDeadlockCallback(callback func(*DeadlockError)) bool {
+	return true
+}
+
+// SetDeadlockCallback has no effect with the tag deadlock_off
+//  Returns:
+//   (bool): always true
+func SetDeadlockCallback(callback func(*DeadlockError)) bool {
+	return true
+}
@@ -0,0 +1,646 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+detector_test.go
+Tests for the comprehensive detection of potential deadlocks.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/petermattis/goid"
+)
+
+// run runs f in a new routine and waits until it has finished
+//  Args:
+//   f (func()): function to run
+//  Returns:
+//   nil
+func run(f func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	<-done
+}
+
+// The edges of a potential deadlock must contain the routines which created
+// the dependencies. The dependency which closes the cycle was reported with
+// the index of the dependency instead of the index of its routine.
+func TestPotentialDeadlockRoutines(t *testing.T) {
+	var out bytes.Buffer
+	d := NewDetector()
+	d.SetJSONOutput(&out)
+
+	x := d.NewLock()
+	y := d.NewLock()
+
+	run(func() {
+		x.Lock()
+		y.Lock()
+		y.Unlock()
+		x.Unlock()
+	})
+	run(func() {
+		y.Lock()
+		x.Lock()
+		x.Unlock()
+		y.Unlock()
+	})
+
+	d.FindPotentialDeadlocks()
+
+	found := false
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var r Report
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Kind != KindPotentialDeadlock {
+			continue
+		}
+		found = true
+		if len(r.Edges) != 2 {
+			t.Fatalf("expected 2 edges, got %d", len(r.Edges))
+		}
+		if r.Edges[0].Routine == r.Edges[1].Routine {
+			t.Errorf("both edges are reported for routine %d",
+				r.Edges[0].Routine)
+		}
+	}
+	if !found {
+		t.Fatal("the potential deadlock was not reported")
+	}
+}
+
+// interceptDeadlocks collects the deadlocks detected by d until the end of
+// the test
+//  Args:
+//   t (*testing.T): the test
+//   d (*Detector): the detector
+//  Returns:
+//   (func() []*DeadlockError): function to get the collected deadlocks
+func interceptDeadlocks(t *testing.T, d *Detector) func() []*DeadlockError {
+	var lock sync.Mutex
+	errs := make([]*DeadlockError, 0)
+	t.Cleanup(d.InterceptDeadlocks(func(err *DeadlockError) {
+		lock.Lock()
+		errs = append(errs, err)
+		lock.Unlock()
+	}))
+	return func() []*DeadlockError {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]*DeadlockError(nil), errs...)
+	}
+}
+
+// A lock which is held while the detector is reset and released afterwards
+// must not be counted as still held by the routine which reuses the index of
+// the holder after the reset.
+func TestResetWhileLocked(t *testing.T) {
+	d := NewDetector()
+	deadlocks := interceptDeadlocks(t, d)
+
+	l := d.NewLock()
+	other := d.NewLock()
+
+	holding := make(chan struct{})
+	release := make(chan struct{})
+	released := make(chan struct{})
+	go func() {
+		l.Lock()
+		close(holding)
+		<-release
+		l.Unlock()
+		close(released)
+	}()
+	<-holding
+
+	d.Reset()
+
+	// release l after the reset
+	close(release)
+	<-released
+
+	// the test routine gets the first index after the reset
+	other.Lock()
+	other.Unlock()
+
+	held := make(chan struct{})
+	go func() {
+		l.Lock()
+		close(held)
+		time.Sleep(20 * time.Millisecond)
+		l.Unlock()
+	}()
+	<-held
+
+	l.Lock()
+	l.Unlock()
+
+	if errs := deadlocks(); len(errs) != 0 {
+		t.Fatalf("unexpected deadlock: %v", errs[0])
+	}
+}
+
+// A routine which holds a lock while the detector is reset keeps the lock in
+// its holding set, so that the dependencies it creates afterwards are found.
+func TestResetKeepsHeldLocks(t *testing.T) {
+	d := NewDetector()
+	interceptDeadlocks(t, d)
+
+	x := d.NewLock()
+	y := d.NewLock()
+
+	holding := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		x.Lock()
+		close(holding)
+		<-release
+		y.Lock()
+		y.Unlock()
+		x.Unlock()
+	}()
+	<-holding
+
+	d.Reset()
+	close(release)
+	<-done
+
+	run(func() {
+		y.Lock()
+		x.Lock()
+		x.Unlock()
+		y.Unlock()
+	})
+
+	reports := d.DetectNow()
+	if len(reports) != 1 || reports[0].Kind != KindPotentialDeadlock {
+		t.Fatalf("expected one potential deadlock, got %d reports", len(reports))
+	}
+}
+
+// A deadlock, which is only found when the wait-for graph is checked again
+// after the threshold, is handled in the waiting routine, so that the panic
+// of the policy can be recovered.
+func TestWaitCycleAfterThreshold(t *testing.T) {
+	d := NewDetector()
+	d.SetDeadlockPolicy(PolicyPanic)
+	d.SetComprehensiveDetection(false)
+	d.SetPeriodicDetectionThreshold(200 * time.Millisecond)
+
+	x := d.NewLock()
+	y := d.NewLock()
+
+	// y is held without being tracked, so that no cycle is found when the
+	// routines start to wait
+	y.mu.Lock()
+
+	holding := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		x.Lock()
+		close(holding)
+		y.Lock()
+		y.Unlock()
+		x.Unlock()
+	}()
+	<-holding
+
+	recovered := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			recovered <- recover()
+			y.mu.Unlock()
+		}()
+		x.Lock()
+		x.Unlock()
+	}()
+
+	// register the routine waiting for x as holder of y after both routines
+	// wait
+	for {
+		d.lockWaitLock.Lock()
+		n := len(d.lockWaits)
+		d.lockWaitLock.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	d.lockWaitLock.Lock()
+	var holder int64
+	for id, w := range d.lockWaits {
+		if w.m == x {
+			holder = id
+		}
+	}
+	d.lockWaitLock.Unlock()
+	d.addLockHold(y, false, holder, callerInfo{})
+
+	select {
+	case r := <-recovered:
+		if err, ok := r.(*DeadlockError); !ok || err.Report.Kind != KindDeadlock {
+			t.Fatalf("expected a deadlock, got %v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the deadlock was not handled in the waiting routine")
+	}
+
+	d.removeLockHold(y)
+	<-done
+}
+
+// RunSchedule returns the double locking found while the body is running
+// instead of handling it according to the policy and returns nil for a body
+// without deadlocks.
+func TestRunSchedule(t *testing.T) {
+	d := NewDetector()
+	// the routine of the double locking stays blocked and is later found in
+	// a local deadlock, which must not terminate the test
+	d.SetDeadlockPolicy(PolicyContinue)
+
+	err := d.RunSchedule([]byte{1, 2, 3}, func() {
+		x := d.NewLock()
+		y := d.NewLock()
+		x.Lock()
+		y.Lock()
+		y.Unlock()
+		x.Unlock()
+	})
+	if err != nil {
+		t.Fatalf("unexpected deadlock: %v", err)
+	}
+
+	err = d.RunSchedule([]byte{1, 2, 3}, func() {
+		x := d.NewLock()
+		x.Lock()
+		x.Lock()
+	})
+	if err == nil || err.Report.Kind != KindDoubleLocking {
+		t.Fatalf("expected double locking, got %v", err)
+	}
+}
+
+// signalWriter is a writer which signals every write
+type signalWriter struct {
+	// closed with the first write
+	written chan struct{}
+	// protects written from being closed twice
+	once sync.Once
+}
+
+// Write signals the write and discards the data
+//  Args:
+//   p ([]byte): the data
+//  Returns:
+//   (int): length of p
+//   (error): always nil
+func (w *signalWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {
+		close(w.written)
+	})
+	return len(p), nil
+}
+
+// Double locking is reported and handled according to the policy: PolicyPanic
+// panics in the routine with a *DeadlockError, PolicyCallback calls the
+// callback and continues and PolicyContinue only continues.
+func TestDeadlockPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		panics   bool
+		callback bool
+	}{
+		{"panic", PolicyPanic, true, false},
+		{"callback", PolicyCallback, false, true},
+		{"continue", PolicyContinue, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDetector()
+			d.SetDeadlockPolicy(tt.policy)
+			called := make(chan *DeadlockError, 1)
+			d.SetDeadlockCallback(func(err *DeadlockError) {
+				called <- err
+			})
+			out := &signalWriter{written: make(chan struct{})}
+			d.SetJSONOutput(out)
+
+			x := d.NewLock()
+
+			recovered := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					recovered <- recover()
+				}()
+				x.Lock()
+				x.Lock()
+			}()
+
+			select {
+			case <-out.written:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the double locking was not reported")
+			}
+			if tt.callback {
+				err := <-called
+				if err.Report.Kind != KindDoubleLocking {
+					t.Fatalf("expected double locking, got %s", err.Report.Kind)
+				}
+			}
+			if !tt.panics {
+				// the routine continues and waits for the lock
+				x.mu.Unlock()
+			}
+
+			r := <-recovered
+			err, ok := r.(*DeadlockError)
+			if tt.panics != ok {
+				t.Fatalf("expected panic %t, got %v", tt.panics, r)
+			}
+			if ok && err.Report.Kind != KindDoubleLocking {
+				t.Fatalf("expected double locking, got %s", err.Report.Kind)
+			}
+			if !tt.callback && len(called) != 0 {
+				t.Fatal("the callback was called")
+			}
+		})
+	}
+}
+
+// PolicyExit terminates the program with the exit code of the options. The
+// program is run in a subprocess, which runs only this test.
+func TestDeadlockPolicyExit(t *testing.T) {
+	if os.Getenv("DEADLOCK_GO_TEST_EXIT") == "1" {
+		d := NewDetector()
+		d.SetExitCode(3)
+		x := d.NewLock()
+		x.Lock()
+		x.Lock()
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDeadlockPolicyExit$")
+	cmd.Env = append(os.Environ(), "DEADLOCK_GO_TEST_EXIT=1")
+	err := cmd.Run()
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		t.Fatalf("expected the program to exit with an error, got %v", err)
+	}
+	if code := exitErr.ExitCode(); code != 3 {
+		t.Fatalf("expected exit code 3, got %d", code)
+	}
+}
+
+// A body which is blocked in a deadlock which is not detected, here of a
+// channel and a lock, ends with the schedule timeout. The error contains the
+// schedule and the routine waiting for the lock.
+func TestRunScheduleTimeout(t *testing.T) {
+	d := NewDetector()
+	d.SetScheduleTimeout(50 * time.Millisecond)
+
+	x := d.NewLock()
+	release := make(chan struct{})
+	done := make(chan struct{})
+	err := d.RunSchedule([]byte{1, 2, 3}, func() {
+		x.Lock()
+		go func() {
+			defer close(done)
+			x.Lock()
+			x.Unlock()
+		}()
+		<-release
+		x.Unlock()
+	})
+	close(release)
+	<-done
+
+	if err == nil || err.Report.Kind != KindScheduleTimeout {
+		t.Fatalf("expected a schedule timeout, got %v", err)
+	}
+	if !bytes.Equal(err.Schedule, []byte{1, 2, 3}) {
+		t.Fatalf("expected the schedule in the error, got %v", err.Schedule)
+	}
+	if len(err.Report.Edges) != 1 || len(err.Report.Edges[0].HeldBy) != 1 {
+		t.Fatalf("expected the routine waiting for the lock, got %+v", err.Report.Edges)
+	}
+}
+
+// potentialInversion creates a lock inversion of two locks in two routines
+//  Args:
+//   t (*testing.T): the test
+//  Returns:
+//   (*Report): the reported potential deadlock
+//   ([2]int64): ids of the go routines, which acquire the locks in the
+//     order x, y and y, x
+func potentialInversion(t *testing.T) (*Report, [2]int64) {
+	d := NewDetector()
+	d.SetPeriodicDetection(false)
+
+	x := d.NewLock()
+	y := d.NewLock()
+
+	var routines [2]int64
+	run(func() {
+		routines[0] = goid.Get()
+		x.Lock()
+		y.Lock()
+		y.Unlock()
+		x.Unlock()
+	})
+	run(func() {
+		routines[1] = goid.Get()
+		y.Lock()
+		x.Lock()
+		x.Unlock()
+		y.Unlock()
+	})
+
+	reports := d.DetectNow()
+	if len(reports) != 1 || reports[0].Kind != KindPotentialDeadlock {
+		t.Fatalf("expected one potential deadlock, got %v", reports)
+	}
+	if len(reports[0].Edges) != 2 {
+		t.Fatalf("expected 2 edges, got %d", len(reports[0].Edges))
+	}
+	return reports[0], routines
+}
+
+// Every edge of a potential deadlock names the go routine, which created the
+// dependency, the creation of the go routine, the held lock and the acquired
+// lock, so that both interleavings can be reconstructed from the report.
+func TestPotentialDeadlockGoroutines(t *testing.T) {
+	r, routines := potentialInversion(t)
+
+	seen := make(map[int64]bool)
+	for i, e := range r.Edges {
+		seen[e.Goroutine] = true
+		if e.CreatedBy == nil || !strings.HasSuffix(e.CreatedBy.Function, ".run") ||
+			!strings.HasSuffix(e.CreatedBy.File, "detector_test.go") {
+			t.Errorf("edge %d: expected the routine to be created by run, got %+v",
+				i, e.CreatedBy)
+		}
+		if len(e.Holding) != 1 {
+			t.Fatalf("edge %d: expected 1 held lock, got %d", i, len(e.Holding))
+		}
+		// the held lock of an edge is acquired by the other edge
+		other := r.Edges[1-i]
+		if e.Holding[0].Lock != other.Lock || e.Lock != other.Holding[0].Lock {
+			t.Errorf("edge %d: holds %s and acquires %s, the other edge holds %s and acquires %s",
+				i, e.Holding[0].Lock, e.Lock, other.Holding[0].Lock, other.Lock)
+		}
+		// x is created before y, the first routine acquires y while holding x
+		xFirst := r.lock(e.Holding[0].Lock).Created.Line < r.lock(e.Lock).Created.Line
+		if xFirst != (e.Goroutine == routines[0]) {
+			t.Errorf("edge %d: the order of the locks does not match goroutine %d",
+				i, e.Goroutine)
+		}
+	}
+	if !seen[routines[0]] || !seen[routines[1]] {
+		t.Fatalf("expected the go routines %v, got %v", routines, seen)
+	}
+}
+
+// Every dependency stores the positions of its own acquisitions. The held lock
+// of an edge is acquired in the line before the acquired lock, and the same
+// lock is reported with different positions in the two edges.
+func TestPotentialDeadlockAcquisitions(t *testing.T) {
+	r, _ := potentialInversion(t)
+
+	for i, e := range r.Edges {
+		held := e.Holding[0].Acquired
+		if !strings.HasSuffix(e.Acquired.File, "detector_test.go") ||
+			!strings.HasSuffix(held.File, "detector_test.go") {
+			t.Fatalf("edge %d: expected the acquisitions in detector_test.go, got %s and %s",
+				i, held.File, e.Acquired.File)
+		}
+		if held.Line+1 != e.Acquired.Line {
+			t.Errorf("edge %d: expected the held lock to be acquired in the line before %d, got %d",
+				i, e.Acquired.Line, held.Line)
+		}
+		other := r.Edges[1-i]
+		if e.Acquired.Line == other.Holding[0].Acquired.Line {
+			t.Errorf("edge %d: the acquisitions of lock %s in both routines are reported at line %d",
+				i, e.Lock, e.Acquired.Line)
+		}
+	}
+}
+
+// The report of a local deadlock contains the cycle: every waiting go routine,
+// the lock it waits for, the go routine holding it and the current stacks.
+func TestLocalDeadlockReport(t *testing.T) {
+	d := NewDetector()
+	d.SetDeadlockPolicy(PolicyPanic)
+	d.SetComprehensiveDetection(false)
+
+	x := d.NewLock()
+	y := d.NewLock()
+
+	// each routine holds one lock and waits for the other one. The routine
+	// which closes the cycle panics and releases its lock.
+	var routines [2]int64
+	holding := make(chan struct{}, 2)
+	recovered := make(chan interface{}, 2)
+	lockBoth := func(i int, first *Mutex, second *Mutex) {
+		defer func() {
+			r := recover()
+			if r != nil {
+				first.Unlock()
+			}
+			recovered <- r
+		}()
+		routines[i] = goid.Get()
+		first.Lock()
+		holding <- struct{}{}
+		for len(holding) < 2 {
+			time.Sleep(time.Millisecond)
+		}
+		second.Lock()
+		second.Unlock()
+		first.Unlock()
+	}
+	go lockBoth(0, x, y)
+	go lockBoth(1, y, x)
+
+	var err *DeadlockError
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-recovered:
+			if e, ok := r.(*DeadlockError); ok {
+				err = e
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the local deadlock was not resolved")
+		}
+	}
+	if err == nil || err.Report.Kind != KindDeadlock {
+		t.Fatalf("expected a local deadlock, got %v", err)
+	}
+
+	edges := err.Report.Edges
+	if len(edges) != 2 {
+		t.Fatalf("expected 2 edges, got %d", len(edges))
+	}
+	for i, e := range edges {
+		other := edges[1-i]
+		if e.Goroutine != routines[0] && e.Goroutine != routines[1] {
+			t.Errorf("edge %d: unexpected goroutine %d", i, e.Goroutine)
+		}
+		if len(e.HeldBy) != 1 || e.HeldBy[0] != other.Goroutine {
+			t.Errorf("edge %d: expected the lock to be held by goroutine %d, got %v",
+				i, other.Goroutine, e.HeldBy)
+		}
+		if len(e.Holding) != 1 || e.Holding[0].Lock != other.Lock {
+			t.Errorf("edge %d: expected the routine to hold %s, got %+v",
+				i, other.Lock, e.Holding)
+		}
+		if !strings.HasPrefix(e.CurrentStack, fmt.Sprintf("goroutine %d ", e.Goroutine)) ||
+			!strings.Contains(e.CurrentStack, "TestLocalDeadlockReport") {
+			t.Errorf("edge %d: expected the current stack of the routine, got %q",
+				i, e.CurrentStack)
+		}
+	}
+}
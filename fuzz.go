@@ -0,0 +1,159 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+fuzz.go
+This file implements the schedules used by the integration with the native
+fuzzing of go in deadlocktest. The bytes of the fuzz input decide at which
+points of the acquisitions and releases of locks the go routines yield or
+sleep. The fuzz engine can therefore explore different schedules of the
+routines.
+*/
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// schedule of a run of the fuzz body
+type fuzzSchedule struct {
+	// fuzz input which decides the schedule
+	data []byte
+	// position of the next byte in data
+	next int64
+}
+
+// RunSchedule runs body once with the schedule given by the bytes of
+// schedule. Every byte decides at one acquisition or release of a lock,
+// whether the routine continues, yields or sleeps. RunSchedule returns as
+// soon as body has finished or the first actual deadlock or double locking
+// was detected. The deadlock is not handled according to the deadlock
+// policy. If body does not finish within the schedule timeout, e.g. because
+// of a deadlock which is not detected, RunSchedule returns an error with a
+// report of the kind KindScheduleTimeout. The returned error contains the
+// schedule. Because the schedule only influences the go scheduler, a schedule
+// which led to a deadlock will reproduce it in most, but not necessarily in
+// all runs.
+// The body must create its own locks, because the routines of a deadlock
+// stay blocked. The actual deadlocks are only found if the periodical
+// detection is enabled. RunSchedule is used by deadlocktest.FuzzSchedule
+// to explore the schedules with the native fuzzing of go.
+//  Args:
+//   schedule ([]byte): bytes which decide the schedule
+//   body (func()): the code under test
+//  Returns:
+//   (*DeadlockError): the first deadlock found while body was running, nil
+//    if body finished without a deadlock
+func (d *Detector) RunSchedule(schedule []byte, body func()) *DeadlockError {
+	// the routines of earlier runs are not needed to detect actual
+	// deadlocks and would exceed the maximum number of routines
+	d.resetRoutines()
+	d.schedule.Store(&fuzzSchedule{data: schedule})
+	defer d.schedule.Store((*fuzzSchedule)(nil))
+
+	failed := make(chan *DeadlockError, 1)
+	var once sync.Once
+	stop := d.InterceptDeadlocks(func(err *DeadlockError) {
+		once.Do(func() {
+			failed <- err
+		})
+	})
+	defer stop()
+
+	done := make(chan struct{})
+	go func() {
+		body()
+		close(done)
+	}()
+
+	timer := time.NewTimer(d.opts.scheduleTimeout)
+	defer timer.Stop()
+
+	// the fuzz engine can reuse the bytes of the input
+	schedule = append([]byte(nil), schedule...)
+	select {
+	case <-done:
+		return nil
+	case err := <-failed:
+		err.Schedule = schedule
+		return err
+	case <-timer.C:
+		return &DeadlockError{
+			Report:   d.newReportScheduleTimeout(),
+			Schedule: schedule,
+		}
+	}
+}
+
+// RunSchedule calls Detector.RunSchedule of the default detector
+//  Args:
+//   schedule ([]byte): bytes which decide the schedule
+//   body (func()): the code under test
+//  Returns:
+//   (*DeadlockError): the first deadlock found while body was running, nil
+//    if body finished without a deadlock
+func RunSchedule(schedule []byte, body func()) *DeadlockError {
+	return defaultDetector.RunSchedule(schedule, body)
+}
+
+// getSchedule returns the schedule of the running fuzz body
+//  Returns:
+//   (*fuzzSchedule): the schedule, nil if no fuzz body is running
+func (d *Detector) getSchedule() *fuzzSchedule {
+	s, _ := d.schedule.Load().(*fuzzSchedule)
+	return s
+}
+
+// schedulePoint is called at every acquisition and release of a lock. If a
+// fuzz body is running, the next byte of the fuzz input decides if the
+// routine continues, yields or sleeps. The lowest two bits select the action,
+// the other bits the duration of the sleep.
+//  Returns:
+//   nil
+func (d *Detector) schedulePoint() {
+	s := d.getSchedule()
+	if s == nil {
+		return
+	}
+
+	i := atomic.AddInt64(&s.next, 1) - 1
+	if i >= int64(len(s.data)) {
+		return
+	}
+
+	b := s.data[i]
+	switch b & 3 {
+	case 1:
+		runtime.Gosched()
+	case 2:
+		time.Sleep(time.Duration(b>>2) * 10 * time.Microsecond)
+	case 3:
+		time.Sleep(time.Duration(b>>2) * 100 * time.Microsecond)
+	}
+}
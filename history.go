@@ -0,0 +1,224 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+history.go
+This file implements the history of found deadlocks. Every time a potential
+or actual deadlock is found, a record with its signature is appended to a
+local history file, which is loaded when the detector is initialized.
+Deadlocks which were already found in earlier runs can therefore be marked or
+suppressed and the avoidance can use the signatures of earlier runs. Because
+the records are only appended, multiple processes can share the same history
+file without overwriting the records of each other.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// name of the environment variable to set the history file
+const envHistoryFile = "DEADLOCK_GO_HISTORY"
+
+// record of the history file, written every time a deadlock is found
+type historyRecord struct {
+	// signature of the deadlock
+	Signature string `json:"signature"`
+	// kind of the deadlock
+	Kind ReportKind `json:"kind"`
+	// time at which the deadlock was found
+	Time time.Time `json:"time"`
+	// report of the deadlock
+	Report *Report `json:"report"`
+}
+
+// entry of the history for one signature, folded from all its records
+type historyEntry struct {
+	// number of times the deadlock was found
+	count int
+	// last report of the deadlock
+	report *Report
+}
+
+// history of found deadlocks
+type deadlockHistory struct {
+	// path of the history file
+	path string
+	// lock to prevent concurrent access of the routines of the detector
+	lock sync.Mutex
+}
+
+// initializeHistory loads the history file set in the options or the
+// environment. The signatures of the loaded cycles are added to the
+// avoidance.
+//  Returns:
+//   nil
+func (d *Detector) initializeHistory() {
+	path := d.outputPath(d.opts.historyFile, envHistoryFile)
+	if path == "" {
+		return
+	}
+
+	d.history = &deadlockHistory{
+		path: path,
+	}
+
+	entries, _, err := d.history.load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Deadlock-Go: could not read history file:", err)
+		return
+	}
+
+	for _, e := range entries {
+		if e.report != nil {
+			d.addAvoidanceSignature(e.report)
+		}
+	}
+}
+
+// recordHistory appends a report to the history and sets the number of
+// times the deadlock was found before. The file is read again, so that the
+// records of other detectors and processes which use the same file are
+// counted.
+//  Args:
+//   r (*Report): the report
+//  Returns:
+//   (bool): true if the report should be suppressed, because it is a
+//    potential deadlock which was already found in an earlier run
+func (d *Detector) recordHistory(r *Report) bool {
+	if d.history == nil || r.Signature == "" {
+		return false
+	}
+
+	d.history.lock.Lock()
+	defer d.history.lock.Unlock()
+
+	entries, complete, err := d.history.load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Deadlock-Go: could not read history file:", err)
+	}
+	if e, ok := entries[r.Signature]; ok {
+		r.SeenBefore = e.count
+	}
+
+	// save immediately, so that the history is kept if the process is killed
+	d.history.append(historyRecord{
+		Signature: r.Signature,
+		Kind:      r.Kind,
+		Time:      time.Now(),
+		Report:    r,
+	}, !complete)
+
+	return r.SeenBefore > 0 && d.opts.suppressKnownDeadlocks &&
+		r.Kind == KindPotentialDeadlock
+}
+
+// load reads the history file and folds the records by their signature.
+// Invalid records, e.g. the last record of a process which was killed while
+// writing it, are skipped.
+//  Returns:
+//   (map[string]*historyEntry): the entries by their signature
+//   (bool): false if the last record is not terminated by a newline
+//   (error): error if the file could not be read
+func (h *deadlockHistory) load() (map[string]*historyEntry, bool, error) {
+	entries := make(map[string]*historyEntry)
+
+	data, err := os.ReadFile(h.path)
+	if os.IsNotExist(err) {
+		return entries, true, nil
+	}
+	if err != nil {
+		return entries, true, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var record historyRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil || record.Signature == "" {
+			fmt.Fprintf(os.Stderr, "Deadlock-Go: skipping invalid history record in line %d\n",
+				lineNumber)
+			continue
+		}
+
+		// the records are in the order in which they were written
+		e, ok := entries[record.Signature]
+		if !ok {
+			e = &historyEntry{}
+			entries[record.Signature] = e
+		}
+		e.count++
+		e.report = record.Report
+	}
+
+	complete := len(data) == 0 || data[len(data)-1] == '\n'
+	return entries, complete, scanner.Err()
+}
+
+// append appends a record to the history file. The record is written with a
+// single write to a file opened with O_APPEND, so that the records of
+// concurrent processes are not mixed.
+//  Args:
+//   record (historyRecord): the record
+//   newline (bool): if true, the record starts with a newline to terminate
+//    an incomplete last record
+//  Returns:
+//   nil
+func (h *deadlockHistory) append(record historyRecord, newline bool) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Deadlock-Go: could not encode history:", err)
+		return
+	}
+	if newline {
+		data = append([]byte{'\n'}, data...)
+	}
+
+	file, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Deadlock-Go: could not write history file:", err)
+		return
+	}
+	_, err = file.Write(append(data, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Deadlock-Go: could not write history file:", err)
+	}
+}
@@ -0,0 +1,245 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+history_test.go
+Tests for the history of found deadlocks.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newHistoryDetector creates a detector which uses the history file at path.
+// The routines which stay blocked after the double locking of
+// historyDoubleLocking are later found in a local deadlock, which must not
+// terminate the test.
+//  Args:
+//   path (string): path of the history file
+//  Returns:
+//   (*Detector): the detector
+func newHistoryDetector(path string) *Detector {
+	d := NewDetector()
+	d.SetHistoryFile(path)
+	d.SetDeadlockPolicy(PolicyContinue)
+	return d
+}
+
+// historyInversion acquires two locks of d in both orders and runs the
+// detection. The locks are created and acquired at the same positions for
+// every detector, so that the signature of the deadlock is the same.
+//  Args:
+//   d (*Detector): the detector
+//  Returns:
+//   ([]*Report): the reports of the detection
+func historyInversion(d *Detector) []*Report {
+	x := d.NewLock()
+	y := d.NewLock()
+	run(func() {
+		x.Lock()
+		y.Lock()
+		y.Unlock()
+		x.Unlock()
+	})
+	run(func() {
+		y.Lock()
+		x.Lock()
+		x.Unlock()
+		y.Unlock()
+	})
+	return d.DetectNow()
+}
+
+// historyDoubleLocking locks a lock of d twice
+//  Args:
+//   d (*Detector): the detector
+//  Returns:
+//   (*DeadlockError): the double locking
+func historyDoubleLocking(d *Detector) *DeadlockError {
+	return d.RunSchedule(nil, func() {
+		x := d.NewLock()
+		x.Lock()
+		x.Lock()
+	})
+}
+
+// The history written by one detector is loaded by the next one, which
+// counts the deadlock as seen before.
+func TestHistoryRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	first := newHistoryDetector(path)
+	reports := historyInversion(first)
+	first.Close()
+	if len(reports) != 1 || reports[0].SeenBefore != 0 {
+		t.Fatalf("expected one new potential deadlock, got %v", reports)
+	}
+
+	second := newHistoryDetector(path)
+	defer second.Close()
+	second.SetDeadlockAvoidance(true)
+	second.initialize()
+	entries, complete, err := second.history.load()
+	if err != nil || !complete {
+		t.Fatalf("could not load the history: %v", err)
+	}
+	e, ok := entries[reports[0].Signature]
+	if !ok || e.count != 1 || e.report == nil || e.report.Signature != reports[0].Signature {
+		t.Fatalf("the deadlock is not in the history: %v", entries)
+	}
+	if len(second.avoidanceSignatures) != 1 {
+		t.Fatal("the signature of the history was not added to the avoidance")
+	}
+
+	reports = historyInversion(second)
+	if len(reports) != 1 || reports[0].SeenBefore != 1 {
+		t.Fatalf("expected the potential deadlock to be seen once before, got %v", reports)
+	}
+}
+
+// Two detectors which use the same file at the same time count the records
+// of each other and do not overwrite them.
+func TestHistorySharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	first := newHistoryDetector(path)
+	defer first.Close()
+	second := newHistoryDetector(path)
+	defer second.Close()
+	first.initialize()
+	second.initialize()
+
+	signature := ""
+	for i, d := range []*Detector{first, second, first} {
+		err := historyDoubleLocking(d)
+		if err == nil {
+			t.Fatal("the double locking was not found")
+		}
+		if err.Report.SeenBefore != i {
+			t.Fatalf("expected the double locking to be seen %d times before, got %d",
+				i, err.Report.SeenBefore)
+		}
+		signature = err.Report.Signature
+	}
+
+	entries, _, err := first.history.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := entries[signature]; e == nil || e.count != 3 {
+		t.Fatalf("expected 3 records of the double locking, got %v", e)
+	}
+}
+
+// With SetSuppressKnownDeadlocks, only potential deadlocks of the history are
+// not reported, actual deadlocks are always reported.
+func TestHistorySuppressKnown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	first := newHistoryDetector(path)
+	historyInversion(first)
+	historyDoubleLocking(first)
+	first.Close()
+
+	var out bytes.Buffer
+	second := newHistoryDetector(path)
+	defer second.Close()
+	second.SetSuppressKnownDeadlocks(true)
+	second.SetJSONOutput(&out)
+
+	historyInversion(second)
+	if err := historyDoubleLocking(second); err == nil || err.Report.SeenBefore != 1 {
+		t.Fatalf("expected the known double locking, got %v", err)
+	}
+
+	kinds := make(map[ReportKind]int)
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var r Report
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		kinds[r.Kind]++
+	}
+	if kinds[KindPotentialDeadlock] != 0 {
+		t.Fatal("the known potential deadlock was reported")
+	}
+	if kinds[KindDoubleLocking] != 1 {
+		t.Fatal("the known double locking was not reported")
+	}
+}
+
+// Invalid records and an incomplete last record, e.g. of a process which was
+// killed while writing it, are skipped. The next record starts in a new line.
+func TestHistoryCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	first := newHistoryDetector(path)
+	reports := historyInversion(first)
+	first.Close()
+	if len(reports) != 1 {
+		t.Fatalf("expected one potential deadlock, got %d", len(reports))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append([]byte("not json\n"), data...)
+	data = append(data, []byte(`{"signature":"`+reports[0].Signature+`","kind":`)...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	second := newHistoryDetector(path)
+	defer second.Close()
+	reports = historyInversion(second)
+	if len(reports) != 1 || reports[0].SeenBefore != 1 {
+		t.Fatalf("expected the potential deadlock to be seen once before, got %v", reports)
+	}
+
+	entries, complete, err := second.history.load()
+	if err != nil || !complete {
+		t.Fatalf("the history was not completed: %v", err)
+	}
+	if e := entries[reports[0].Signature]; e == nil || e.count != 2 {
+		t.Fatalf("expected 2 valid records, got %v", entries)
+	}
+
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 4 {
+		t.Fatalf("expected 4 lines, got %d", lines)
+	}
+}
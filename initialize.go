@@ -1,3 +1,5 @@
+//go:build !deadlock_off
+
 package deadlock
 
 /*
@@ -25,45 +27,40 @@ Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
 
 /*
 initialize.go
-This code initializes the deadlock detector. Its main task is to start
-and periodically run the periodical deadlock detection.
+This code initializes the deadlock detector. Its main task is to open the
+outputs for the reports and to start the watchdog for lock waits.
 */
 
-import (
-	"time"
-)
-
-// global variable to check whether the detector was already initialized
-var initialized = false
-
-// initialize initializes the deadlock detector.
-// This starts the periodical detection.
+// initialize initializes the deadlock detector, if it is not initialized yet.
+// This opens the outputs and starts the watchdog for lock waits.
 //  Returns:
 //   nil
-func initialize() {
-	initialized = true
-
-	// reinitialize routines to set size
-	routines = make([]routine, opts.maxRoutines)
+func (d *Detector) initialize() {
+	d.initializeLock.Lock()
+	defer d.initializeLock.Unlock()
 
-	// return if periodical detection is disabled
-	if !opts.periodicDetection {
+	if d.initialized {
 		return
 	}
+	d.initialized = true
 
-	// go routine to run the periodical detection in the background
-	go func() {
-		// timer to send a signals at equal intervals
-		timer := time.NewTicker(opts.periodicDetectionTime)
+	// reinitialize routines to set size
+	d.routines = make([]*routine, d.opts.maxRoutines)
+
+	// open the outputs for the structured reports
+	d.initializeReportOutputs()
+
+	// load the deadlocks found in earlier runs
+	d.initializeHistory()
 
-		// initialize lashHolding. This slice stores the dependencies which were
-		// considered in the last detection round, so that the detection only takes
-		// place, if the situation has changed
-		lastHolding := make([]mutexInt, opts.maxRoutines)
+	// open the trace file
+	d.initializeTrace()
 
-		// run the periodical detection if a timer signal is received
-		for range timer.C {
-			periodicalDetection(&lastHolding)
-		}
-	}()
+	// start the profiling of the locks
+	d.initializeProfile()
+
+	// start the watchdog for routines waiting too long for a lock
+	if d.opts.lockWaitTimeout > 0 {
+		go d.runLockWaitWatchdog()
+	}
 }
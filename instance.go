@@ -0,0 +1,183 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+instance.go
+This file implements the Detector type, which contains the complete state
+of a deadlock detector: its options, the lock trees of the routines, the
+tracked waits, the reported cycles and the outputs. Every lock belongs to the
+detector which created it. The package level functions use a default
+detector, so independent detectors are only needed e.g. for parallel tests.
+*/
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Detector is an independent deadlock detector. Locks created with
+// Detector.NewLock and Detector.NewRWLock are only checked by their detector.
+type Detector struct {
+	// options of the detector
+	opts options
+	// set to true after the detector was initialized
+	initialized bool
+	// lock to prevent concurrent initialization
+	initializeLock sync.Mutex
+	// closed by Close to stop the routines of the detector
+	done chan struct{}
+
+	// map to map the internal routine id to index in routines
+	mapIndex map[int64]int
+	// lock for the creation of a new routine, lookups of the routines only
+	// read the list
+	createRoutineLock sync.RWMutex
+	// lock to prevent concurrent creation of a lock on its first use
+	lazyCreateLock sync.Mutex
+	// list of routines
+	routines []*routine
+	// number of routines in routines
+	numberRoutines int
+
+	// cycles which have already been reported by their key
+	reportedCycles map[string]*foundCycle
+	// lock to prevent concurrent access to reportedCycles
+	reportedCyclesLock sync.Mutex
+	// edges of the lock graph which are part of a detected cycle
+	cycleEdges map[[2]uintptr]struct{}
+	// lock to prevent concurrent access to cycleEdges
+	cycleEdgesLock sync.Mutex
+
+	// lock for lockWaits
+	lockWaitLock sync.Mutex
+	// routines which currently wait for a lock by the id of the go routine
+	lockWaits map[int64]*lockWait
+	// routines which currently hold a lock, sharded by the lock
+	lockHolds [lockHoldShards]lockHoldShard
+
+	// lock for the avoidance data, read for every acquisition and only
+	// written if gates are added, passed or left
+	avoidanceLock sync.RWMutex
+	// number of created gates, used for the global order of the gates
+	avoidanceGateCount uint64
+	// known signatures
+	avoidanceSignatures map[string]*avoidanceGate
+	// gates by the outer acquisitions of the signatures, which are identified
+	// by the class of the lock and the position of the acquisition
+	avoidanceGates map[string][]*avoidanceGate
+	// passed gates by the memory position of the acquired lock
+	avoidanceHolds map[uintptr][]avoidanceHold
+	// number of acquisitions which were not serialized by a busy gate, by
+	// the outer acquisition
+	avoidanceBypasses map[string]int
+
+	// the history or nil if the history is disabled
+	history *deadlockHistory
+	// the recorder of the trace or nil if the recording is disabled
+	tracer *traceRecorder
+	// the profiler of the locks or nil if the profiling is disabled
+	profiler *lockProfiler
+	// list of all active outputs
+	reportOutputs []reportOutput
+
+	// function which replaces the policy for deadlocks, nil if the policy is
+	// used
+	interceptor func(*DeadlockError)
+	// lock to prevent concurrent access to interceptor
+	interceptorLock sync.Mutex
+	// number of unlocks to ignore by the routine and the lock released with
+	// ReleaseLocks
+	released map[releasedKey]int
+	// lock to prevent concurrent access to released
+	releasedLock sync.Mutex
+	// number of unlocks in released, accessed atomically
+	numberReleased int32
+	// the running confirmation, contains a *confirmation which is nil if no
+	// confirmation is running
+	confirmation atomic.Value
+	// the schedule of the running fuzz body, contains a *fuzzSchedule which
+	// is nil if no fuzz body is running
+	schedule atomic.Value
+}
+
+// the detector used by the package level functions
+var defaultDetector = NewDetector()
+
+// Default returns the detector used by the package level functions and the
+// locks created with NewLock and NewRWLock
+//  Returns:
+//   (*Detector): the default detector
+func Default() *Detector {
+	return defaultDetector
+}
+
+// NewDetector creates a new detector with the default options. The options
+// can be changed until the first lock of the detector is created.
+//  Returns:
+//   (*Detector): the detector
+func NewDetector() *Detector {
+	d := &Detector{
+		opts:                defaultOptions(),
+		done:                make(chan struct{}),
+		mapIndex:            make(map[int64]int),
+		reportedCycles:      make(map[string]*foundCycle),
+		cycleEdges:          make(map[[2]uintptr]struct{}),
+		lockWaits:           make(map[int64]*lockWait),
+		avoidanceSignatures: make(map[string]*avoidanceGate),
+		avoidanceGates:      make(map[string][]*avoidanceGate),
+		avoidanceHolds:      make(map[uintptr][]avoidanceHold),
+		avoidanceBypasses:   make(map[string]int),
+		released:            make(map[releasedKey]int),
+	}
+	for i := range d.lockHolds {
+		d.lockHolds[i].holds = make(map[uintptr][]lockHold)
+	}
+	d.confirmation.Store((*confirmation)(nil))
+	d.schedule.Store((*fuzzSchedule)(nil))
+	return d
+}
+
+// Close stops the routines of the detector, writes the collected reports to
+// the outputs and closes the trace file. The locks of the detector can still
+// be used, but the waits are not checked by the watchdog anymore.
+//  Returns:
+//   nil
+func (d *Detector) Close() {
+	d.initializeLock.Lock()
+	defer d.initializeLock.Unlock()
+
+	select {
+	case <-d.done:
+		return
+	default:
+		close(d.done)
+	}
+
+	d.flushReports()
+	d.closeReports()
+	d.closeTrace()
+}
@@ -0,0 +1,344 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+lockGraph.go
+This file implements the export of the lock-order graph. The graph is the
+union of the lock trees of all routines. The nodes are the locks and there is
+an edge from lock a to lock b, if b was acquired while a was held.
+Edges which are part of a detected cycle are highlighted.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// node of the lock graph
+type graphNode struct {
+	// the lock represented by the node
+	mu mutexInt
+	// name of the node, consisting of the position where the lock was created
+	name string
+}
+
+// edge of the lock graph
+type graphEdge struct {
+	// lock which was held
+	from uintptr
+	// lock which was acquired
+	to uintptr
+	// positions and modes of the acquisitions of to
+	labels map[string]struct{}
+	// true if the edge is part of a detected cycle
+	inCycle bool
+}
+
+// lock graph
+type lockGraph struct {
+	nodes map[uintptr]*graphNode
+	edges map[[2]uintptr]*graphEdge
+}
+
+// WriteLockGraph writes the lock-order graph, which is the union of the lock
+// trees of all routines, to w.
+// Nodes are the locks, named by the position where they were created. An edge
+// from lock a to lock b means, that b was acquired while a was held.
+// Edges are labelled with the positions of the acquisitions and whether the
+// acquisition was a read (R) or write (W) lock. Edges which are part of
+// a detected cycle are highlighted.
+//  Args:
+//   w (io.Writer): writer the graph is written to
+//   format (GraphFormat): format of the graph
+//  Returns:
+//   (error): error if the format is unknown or writing failed
+func (d *Detector) WriteLockGraph(w io.Writer, format GraphFormat) error {
+	graph := d.buildLockGraph()
+
+	switch format {
+	case GraphDOT:
+		return graph.writeDOT(w)
+	case GraphJSON:
+		return graph.writeJSON(w)
+	default:
+		return errors.New("unknown graph format")
+	}
+}
+
+// WriteLockGraph calls Detector.WriteLockGraph of the default detector
+//  Args:
+//   w (io.Writer): writer the graph is written to
+//   format (GraphFormat): format of the graph
+//  Returns:
+//   (error): error if the format is unknown or writing failed
+func WriteLockGraph(w io.Writer, format GraphFormat) error {
+	return defaultDetector.WriteLockGraph(w, format)
+}
+
+// markCycle marks the edges of a detected cycle, so that they are highlighted
+// in the lock graph
+//  Args:
+//   stack (*depStack): stack which represents the cycle
+//  Returns:
+//   nil
+func (d *Detector) markCycle(stack *depStack) {
+	d.cycleEdgesLock.Lock()
+	defer d.cycleEdgesLock.Unlock()
+
+	// the lock of each dependency is in the holding set of the next dependency
+	// the lock of the last dependency is in the holding set of the first
+	for cl := stack.stack.next; cl != nil; cl = cl.next {
+		next := cl.next
+		if next == nil {
+			next = stack.stack.next
+		}
+		key := [2]uintptr{cl.depEntry.mu.getMemoryPosition(),
+			next.depEntry.mu.getMemoryPosition()}
+		d.cycleEdges[key] = struct{}{}
+	}
+}
+
+// buildLockGraph creates the lock graph from the dependencies of all routines
+//  Returns:
+//   (*lockGraph): the lock graph
+func (d *Detector) buildLockGraph() *lockGraph {
+	graph := &lockGraph{
+		nodes: make(map[uintptr]*graphNode),
+		edges: make(map[[2]uintptr]*graphEdge),
+	}
+
+	for _, r := range d.routineList() {
+		// lock the routine, so that its lock tree does not change
+		r.lock.Lock()
+		for j := 0; j < r.depCount; j++ {
+			dep := r.dependencies[j]
+			to := graph.addNode(dep.mu)
+
+			// the mode of the acquisition, the lock can be held in another
+			// mode or not at all at the moment
+			mode := "W"
+			if dep.rLock {
+				mode = "R"
+			}
+
+			for k := 0; k < dep.holdingCount; k++ {
+				from := graph.addNode(dep.holdingSet[k])
+				edge := graph.addEdge(from, to)
+				label := fmt.Sprintf("%s:%d (%s)", filepath.Base(dep.muInfo.file),
+					dep.muInfo.line, mode)
+				edge.labels[label] = struct{}{}
+			}
+		}
+		r.lock.Unlock()
+	}
+
+	d.cycleEdgesLock.Lock()
+	for key := range d.cycleEdges {
+		if edge, ok := graph.edges[key]; ok {
+			edge.inCycle = true
+		}
+	}
+	d.cycleEdgesLock.Unlock()
+
+	return graph
+}
+
+// addNode adds a lock to the graph if it does not already exist
+//  Args:
+//   m (mutexInt): lock to add
+//  Returns:
+//   (uintptr): key of the node
+func (g *lockGraph) addNode(m mutexInt) uintptr {
+	key := m.getMemoryPosition()
+	if _, ok := g.nodes[key]; ok {
+		return key
+	}
+
+	name := lockID(m)
+	for _, c := range *m.getContext() {
+		if c.create {
+			name = fmt.Sprintf("%s:%d", filepath.Base(c.file), c.line)
+			break
+		}
+	}
+
+	g.nodes[key] = &graphNode{mu: m, name: name}
+	return key
+}
+
+// addEdge adds an edge to the graph if it does not already exist
+//  Args:
+//   from (uintptr): key of the node of the held lock
+//   to (uintptr): key of the node of the acquired lock
+//  Returns:
+//   (*graphEdge): the edge
+func (g *lockGraph) addEdge(from uintptr, to uintptr) *graphEdge {
+	key := [2]uintptr{from, to}
+	if edge, ok := g.edges[key]; ok {
+		return edge
+	}
+
+	edge := &graphEdge{
+		from:   from,
+		to:     to,
+		labels: make(map[string]struct{}),
+	}
+	g.edges[key] = edge
+	return edge
+}
+
+// sortedNodes returns the keys of all nodes in a fixed order
+//  Returns:
+//   ([]uintptr): keys of the nodes
+func (g *lockGraph) sortedNodes() []uintptr {
+	keys := make([]uintptr, 0, len(g.nodes))
+	for key := range g.nodes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// sortedEdges returns all edges in a fixed order
+//  Returns:
+//   ([]*graphEdge): the edges
+func (g *lockGraph) sortedEdges() []*graphEdge {
+	edges := make([]*graphEdge, 0, len(g.edges))
+	for _, edge := range g.edges {
+		edges = append(edges, edge)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].from != edges[j].from {
+			return edges[i].from < edges[j].from
+		}
+		return edges[i].to < edges[j].to
+	})
+	return edges
+}
+
+// sortedLabels returns the labels of an edge in a fixed order
+//  Returns:
+//   ([]string): the labels
+func (e *graphEdge) sortedLabels() []string {
+	labels := make([]string, 0, len(e.labels))
+	for label := range e.labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// writeDOT writes the graph in the DOT format
+//  Args:
+//   w (io.Writer): writer the graph is written to
+//  Returns:
+//   (error): error if writing failed
+func (g *lockGraph) writeDOT(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("digraph locks {\n")
+	b.WriteString("\tnode [shape=box];\n")
+
+	for _, key := range g.sortedNodes() {
+		node := g.nodes[key]
+		fmt.Fprintf(&b, "\t\"%#x\" [label=%s];\n", key, dotQuote(node.name))
+	}
+
+	for _, edge := range g.sortedEdges() {
+		fmt.Fprintf(&b, "\t\"%#x\" -> \"%#x\" [label=%s", edge.from, edge.to,
+			dotQuote(strings.Join(edge.sortedLabels(), "\n")))
+		if edge.inCycle {
+			b.WriteString(", color=red, penwidth=2")
+		}
+		b.WriteString("];\n")
+	}
+
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeJSON writes the graph as JSON object. The ids of the nodes are the
+// ids of the locks in the reports.
+//  Args:
+//   w (io.Writer): writer the graph is written to
+//  Returns:
+//   (error): error if writing failed
+func (g *lockGraph) writeJSON(w io.Writer) error {
+	type jsonNode struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	type jsonEdge struct {
+		From    string   `json:"from"`
+		To      string   `json:"to"`
+		Labels  []string `json:"labels"`
+		InCycle bool     `json:"inCycle"`
+	}
+	graph := struct {
+		Nodes []jsonNode `json:"nodes"`
+		Edges []jsonEdge `json:"edges"`
+	}{
+		Nodes: make([]jsonNode, 0, len(g.nodes)),
+		Edges: make([]jsonEdge, 0, len(g.edges)),
+	}
+
+	for _, key := range g.sortedNodes() {
+		graph.Nodes = append(graph.Nodes, jsonNode{
+			ID:   fmt.Sprintf("%#x", key),
+			Name: g.nodes[key].name,
+		})
+	}
+	for _, edge := range g.sortedEdges() {
+		graph.Edges = append(graph.Edges, jsonEdge{
+			From:    fmt.Sprintf("%#x", edge.from),
+			To:      fmt.Sprintf("%#x", edge.to),
+			Labels:  edge.sortedLabels(),
+			InCycle: edge.inCycle,
+		})
+	}
+
+	return json.NewEncoder(w).Encode(graph)
+}
+
+// dotQuote quotes a string for the use in a DOT file
+//  Args:
+//   s (string): string to quote
+//  Returns:
+//   (string): quoted string
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, "\"", "\\\"")
+	s = strings.ReplaceAll(s, "\n", "\\n")
+	return "\"" + s + "\""
+}
@@ -0,0 +1,214 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+lockGraph_test.go
+Tests for the export of the lock graph.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// update the golden files of the lock graph instead of comparing them
+var updateGraph = flag.Bool("update", false, "update the golden files")
+
+// matches the ids of the locks, which are their memory positions
+var lockIDPattern = regexp.MustCompile(`0x[0-9a-f]+`)
+
+// normalizeDOT replaces the ids of the nodes in a DOT graph by their names
+// and sorts the nodes and edges, which are ordered by the memory positions
+// of the locks
+//  Args:
+//   dot (string): the graph
+//  Returns:
+//   (string): the normalized graph
+func normalizeDOT(dot string) string {
+	names := make(map[string]string)
+	nodePattern := regexp.MustCompile(`(?m)^\t"(0x[0-9a-f]+)" \[label="([^"]*)"\];$`)
+	for _, m := range nodePattern.FindAllStringSubmatch(dot, -1) {
+		names[m[1]] = m[2]
+	}
+
+	lines := strings.Split(strings.TrimSpace(dot), "\n")
+	body := lines[2 : len(lines)-1]
+	for i, l := range body {
+		body[i] = lockIDPattern.ReplaceAllStringFunc(l, func(id string) string {
+			return names[id]
+		})
+	}
+	sort.Strings(body)
+
+	return strings.Join(append(append(lines[:2:2], body...), lines[len(lines)-1]), "\n") + "\n"
+}
+
+// normalizeJSON replaces the ids of the nodes in a JSON graph by their names
+// and sorts the nodes and edges, which are ordered by the memory positions
+// of the locks
+//  Args:
+//   t (*testing.T): the test
+//   data ([]byte): the graph
+//  Returns:
+//   (string): the normalized graph
+func normalizeJSON(t *testing.T, data []byte) string {
+	var graph struct {
+		Nodes []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"nodes"`
+		Edges []struct {
+			From    string   `json:"from"`
+			To      string   `json:"to"`
+			Labels  []string `json:"labels"`
+			InCycle bool     `json:"inCycle"`
+		} `json:"edges"`
+	}
+	if err := json.Unmarshal(data, &graph); err != nil {
+		t.Fatal(err)
+	}
+
+	names := make(map[string]string)
+	for i, n := range graph.Nodes {
+		names[n.ID] = n.Name
+		graph.Nodes[i].ID = n.Name
+	}
+	for i, e := range graph.Edges {
+		graph.Edges[i].From = names[e.From]
+		graph.Edges[i].To = names[e.To]
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		return graph.Nodes[i].ID < graph.Nodes[j].ID
+	})
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].From != graph.Edges[j].From {
+			return graph.Edges[i].From < graph.Edges[j].From
+		}
+		return graph.Edges[i].To < graph.Edges[j].To
+	})
+
+	out, err := json.MarshalIndent(graph, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out) + "\n"
+}
+
+// compareGolden compares the output with the golden file in testdata or
+// updates the golden file with -update
+//  Args:
+//   t (*testing.T): the test
+//   name (string): name of the golden file
+//   got (string): the output
+//  Returns:
+//   nil
+func compareGolden(t *testing.T, name string, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *updateGraph {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("the output differs from %s:\n%s", path, got)
+	}
+}
+
+// The lock graph contains the modes of the acquisitions, also if a lock is
+// held in another mode when the graph is written, and marks the cycle.
+func TestWriteLockGraph(t *testing.T) {
+	d := NewDetector()
+
+	x := d.NewLock()
+	y := d.NewLock()
+	z := d.NewRWLock()
+
+	run(func() {
+		x.Lock()
+		y.Lock()
+		y.Unlock()
+		x.Unlock()
+	})
+	run(func() {
+		y.Lock()
+		x.Lock()
+		x.Unlock()
+		y.Unlock()
+	})
+
+	// z is acquired as r-lock under x and is held as write lock while the
+	// graph is written
+	holding := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		x.Lock()
+		z.RLock()
+		z.RUnlock()
+		x.Unlock()
+		z.Lock()
+		close(holding)
+		<-release
+		z.Unlock()
+	}()
+	<-holding
+	defer func() {
+		close(release)
+		<-done
+	}()
+
+	if reports := d.DetectNow(); len(reports) != 1 {
+		t.Fatalf("expected one potential deadlock, got %d", len(reports))
+	}
+
+	var dot bytes.Buffer
+	if err := d.WriteLockGraph(&dot, GraphDOT); err != nil {
+		t.Fatal(err)
+	}
+	compareGolden(t, "lockgraph.dot", normalizeDOT(dot.String()))
+
+	var out bytes.Buffer
+	if err := d.WriteLockGraph(&out, GraphJSON); err != nil {
+		t.Fatal(err)
+	}
+	compareGolden(t, "lockgraph.json", normalizeJSON(t, out.Bytes()))
+}
@@ -0,0 +1,426 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+lockWait.go
+This file implements the tracking of the routines which currently wait for
+or hold a lock. The tracked waits and holds form the wait-for graph, which
+is used by the periodical detection to find actual deadlocks.
+It also implements the watchdog, which reports routines that wait for a lock
+longer than the lock wait timeout. In contrast to the periodical detection,
+this also finds routines which are blocked because the holder of the lock
+is blocked somewhere else, e.g. in I/O.
+*/
+
+import (
+	"sync"
+	"time"
+
+	"github.com/petermattis/goid"
+)
+
+// number of shards of the tracked holders
+const lockHoldShards = 64
+
+// routine which waits for a lock
+type lockWait struct {
+	// lock the routine waits for
+	m mutexInt
+	// true if the routine waits for a r-lock
+	rLock bool
+	// id of the go routine
+	goID int64
+	// position (and call stack) of the acquisition
+	info callerInfo
+	// gate of the avoidance the routine waits for before it acquires m, nil
+	// if the routine waits for m
+	gate *avoidanceGate
+	// time at which the routine started waiting
+	since time.Time
+	// true if the wait has already been reported by the watchdog
+	reported bool
+	// true if the wait has already been reported as part of a deadlock
+	deadlockReported bool
+}
+
+// routine which holds a lock
+type lockHold struct {
+	// lock which is held
+	m mutexInt
+	// true if the lock is held as r-lock
+	rLock bool
+	// id of the go routine
+	goID int64
+	// position (and call stack) of the acquisition
+	info callerInfo
+	// time at which the lock was acquired
+	since time.Time
+}
+
+// shard of the tracked holders. The holders are sharded by the lock, so that
+// the acquisitions of different locks do not block each other.
+type lockHoldShard struct {
+	// lock to prevent concurrent access to holds
+	lock sync.Mutex
+	// routines which currently hold a lock by the memory position of the lock
+	holds map[uintptr][]lockHold
+}
+
+// trackLockWaits returns whether the waiting and holding routines are tracked
+//  Returns:
+//   (bool): true if the routines are tracked, false otherwise
+func (d *Detector) trackLockWaits() bool {
+	return d.opts.periodicDetection || d.opts.lockWaitTimeout > 0
+}
+
+// newTrackingInfo creates the callerInfo for a tracked acquisition from the
+// callerInfo of the acquisition. The call stack is needed to report the
+// holders of a lock, so it is always collected if the watchdog is enabled.
+//  Args:
+//   info (callerInfo): position of the acquisition
+//   skip (int): number of stack frames between the caller of
+//    newTrackingInfo and the code which acquired the lock
+//  Returns:
+//   callerInfo: the created callerInfo
+func (d *Detector) newTrackingInfo(info callerInfo, skip int) callerInfo {
+	if d.opts.lockWaitTimeout > 0 && info.callStacks == "" {
+		info.callStacks = d.getCallStack(skip + 1)
+	}
+	return info
+}
+
+// waitForLock acquires m, which could not be acquired immediately. The
+// calling routine is registered as waiting for m until m is acquired. The
+// wait-for graph is checked for a cycle when the routine starts to wait and
+// again after the threshold of the periodical detection. Both checks run in
+// the waiting routine, so that a panic raised because of the policy for
+// deadlocks can be recovered by the routine.
+//  Args:
+//   m (mutexInt): lock to acquire
+//   rLock (bool): true if m is acquired as r-lock
+//   goID (int64): id of the go routine
+//   info (callerInfo): position of the acquisition
+//  Returns:
+//   nil
+func (d *Detector) waitForLock(m mutexInt, rLock bool, goID int64, info callerInfo) {
+	w := d.startLockWait(m, rLock, goID, info, nil)
+	if w == nil {
+		lockMutex(m, rLock)
+		return
+	}
+	defer d.endLockWait(w)
+
+	// the wait-for graph only has to be checked again if the lock is held
+	if !d.opts.periodicDetection || !d.isLockHeld(m) {
+		lockMutex(m, rLock)
+		return
+	}
+
+	// m is acquired by a helper routine, so that the waiting routine can
+	// check the wait-for graph after the threshold
+	acquired := make(chan struct{})
+	go func() {
+		lockMutex(m, rLock)
+		close(acquired)
+	}()
+
+	timer := time.NewTimer(d.opts.periodicDetectionThreshold)
+	defer timer.Stop()
+
+	select {
+	case <-acquired:
+		return
+	case <-timer.C:
+	}
+
+	// if the routine leaves with a panic because of the policy, m is
+	// released as soon as the helper routine acquired it
+	done := false
+	defer func() {
+		if !done {
+			go func() {
+				<-acquired
+				unlockMutex(m, rLock)
+			}()
+		}
+	}()
+
+	d.checkWaitCycle(w)
+	<-acquired
+	done = true
+}
+
+// startLockWait registers the calling routine as waiting for m or for a
+// gate of the avoidance before m.
+// If the wait closes a cycle in the wait-for graph, the deadlock is reported
+// and handled according to the policy before the routine is registered.
+//  Args:
+//   m (mutexInt): lock the routine is going to wait for
+//   rLock (bool): true if the routine waits for a r-lock
+//   goID (int64): id of the go routine
+//   info (callerInfo): position of the acquisition
+//   gate (*avoidanceGate): gate the routine waits for, nil if it waits for m
+//  Returns:
+//   (*lockWait): the wait or nil if the routines are not tracked
+func (d *Detector) startLockWait(m mutexInt, rLock bool, goID int64,
+	info callerInfo, gate *avoidanceGate) *lockWait {
+	if !d.trackLockWaits() {
+		return nil
+	}
+
+	w := &lockWait{
+		m:     m,
+		rLock: rLock,
+		goID:  goID,
+		info:  info,
+		gate:  gate,
+		since: time.Now(),
+	}
+
+	d.lockWaitLock.Lock()
+
+	// check if the wait closes a cycle in the wait-for graph. Every cycle
+	// is closed by the routine which starts to wait last, so it is enough to
+	// search for cycles which contain the new wait
+	if d.opts.periodicDetection {
+		if cycle := d.findWaitCycle(w); cycle != nil {
+			for _, c := range cycle {
+				c.deadlockReported = true
+			}
+			r := newReportActualDeadlock(cycle)
+			d.lockWaitLock.Unlock()
+
+			// a panic raised because of the policy leaves the routine
+			// without a registered wait
+			d.foundLocalDeadlock(r)
+
+			d.lockWaitLock.Lock()
+		}
+	}
+
+	d.lockWaits[w.goID] = w
+	d.lockWaitLock.Unlock()
+
+	return w
+}
+
+// endLockWait is called after the lock of a wait was acquired or the waiting
+// routine left with a panic. It removes the wait.
+//  Args:
+//   w (*lockWait): the wait
+//  Returns:
+//   nil
+func (d *Detector) endLockWait(w *lockWait) {
+	d.lockWaitLock.Lock()
+	if d.lockWaits[w.goID] == w {
+		delete(d.lockWaits, w.goID)
+	}
+	d.lockWaitLock.Unlock()
+}
+
+// getWaitHolders returns the routines which block a wait. These are the
+// holders of the lock or, if the routine waits for a gate of the avoidance,
+// the routine inside the gate.
+//  Args:
+//   w (*lockWait): the wait
+//  Returns:
+//   ([]lockHold): the holders
+func (d *Detector) getWaitHolders(w *lockWait) []lockHold {
+	if w.gate == nil {
+		return d.getLockHolds(w.m)
+	}
+
+	owner, since := w.gate.getOwner()
+	if owner == 0 {
+		return nil
+	}
+	return []lockHold{{m: w.m, goID: owner, since: since}}
+}
+
+// lockHoldShard returns the shard of the tracked holders of m
+//  Args:
+//   m (mutexInt): the lock
+//  Returns:
+//   (*lockHoldShard): the shard
+func (d *Detector) lockHoldShard(m mutexInt) *lockHoldShard {
+	// the memory positions of the locks are aligned
+	return &d.lockHolds[(m.getMemoryPosition()>>4)%lockHoldShards]
+}
+
+// getLockHolds returns the tracked holders of m
+//  Args:
+//   m (mutexInt): the lock
+//  Returns:
+//   ([]lockHold): the holders in the order of their acquisition
+func (d *Detector) getLockHolds(m mutexInt) []lockHold {
+	shard := d.lockHoldShard(m)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	holds := shard.holds[m.getMemoryPosition()]
+	return append(make([]lockHold, 0, len(holds)), holds...)
+}
+
+// getLockHoldsOf returns the locks held by a go routine
+//  Args:
+//   goID (int64): id of the go routine
+//  Returns:
+//   ([]lockHold): the holds of the routine in no particular order
+func (d *Detector) getLockHoldsOf(goID int64) []lockHold {
+	held := make([]lockHold, 0)
+	for i := range d.lockHolds {
+		shard := &d.lockHolds[i]
+		shard.lock.Lock()
+		for _, holds := range shard.holds {
+			for _, h := range holds {
+				if h.goID == goID {
+					held = append(held, h)
+				}
+			}
+		}
+		shard.lock.Unlock()
+	}
+	return held
+}
+
+// isLockHeld returns whether m has a tracked holder
+//  Args:
+//   m (mutexInt): the lock
+//  Returns:
+//   (bool): true if m is held, false otherwise
+func (d *Detector) isLockHeld(m mutexInt) bool {
+	shard := d.lockHoldShard(m)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	return len(shard.holds[m.getMemoryPosition()]) != 0
+}
+
+// addLockHold registers a routine as holder of m
+//  Args:
+//   m (mutexInt): the acquired lock
+//   rLock (bool): true if m was acquired as r-lock
+//   goID (int64): id of the go routine
+//   info (callerInfo): position of the acquisition
+//  Returns:
+//   nil
+func (d *Detector) addLockHold(m mutexInt, rLock bool, goID int64, info callerInfo) {
+	shard := d.lockHoldShard(m)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	key := m.getMemoryPosition()
+	shard.holds[key] = append(shard.holds[key], lockHold{
+		m:     m,
+		rLock: rLock,
+		goID:  goID,
+		info:  info,
+		since: time.Now(),
+	})
+}
+
+// removeLockHold removes the calling routine as holder of m. Because a lock
+// can be released by another routine than the one which acquired it, the
+// oldest holder is removed if the calling routine does not hold m.
+//  Args:
+//   m (mutexInt): the released lock
+//  Returns:
+//   nil
+func (d *Detector) removeLockHold(m mutexInt) {
+	if !d.trackLockWaits() {
+		return
+	}
+
+	shard := d.lockHoldShard(m)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	key := m.getMemoryPosition()
+	holds := shard.holds[key]
+	if len(holds) == 0 {
+		return
+	}
+
+	id := goid.Get()
+	index := 0
+	for i, h := range holds {
+		if h.goID == id {
+			index = i
+			break
+		}
+	}
+
+	holds = append(holds[:index], holds[index+1:]...)
+	if len(holds) == 0 {
+		delete(shard.holds, key)
+	} else {
+		shard.holds[key] = holds
+	}
+}
+
+// runLockWaitWatchdog periodically checks for routines which wait longer
+// than the lock wait timeout for a lock. It never returns.
+//  Returns:
+//   nil
+func (d *Detector) runLockWaitWatchdog() {
+	// check twice per timeout, so that a wait is reported at most half of
+	// the timeout too late
+	timer := time.NewTicker(d.opts.lockWaitTimeout / 2)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			for _, r := range d.collectLockWaitTimeouts() {
+				d.reportLockWaitTimeout(r)
+			}
+		case <-d.done:
+			// the detector was closed
+			return
+		}
+	}
+}
+
+// collectLockWaitTimeouts creates the reports for all routines which wait
+// longer than the lock wait timeout and were not reported yet
+//  Returns:
+//   ([]*Report): the reports
+func (d *Detector) collectLockWaitTimeouts() []*Report {
+	d.lockWaitLock.Lock()
+	defer d.lockWaitLock.Unlock()
+
+	now := time.Now()
+	reports := make([]*Report, 0)
+	for _, w := range d.lockWaits {
+		if w.reported || now.Sub(w.since) < d.opts.lockWaitTimeout {
+			continue
+		}
+		w.reported = true
+		reports = append(reports, newReportLockWaitTimeout(w, now))
+	}
+	return reports
+}
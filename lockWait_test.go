@@ -0,0 +1,127 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+lockWait_test.go
+Tests for the watchdog of the lock wait timeout.
+*/
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petermattis/goid"
+)
+
+// reportWriter is a writer for the JSON output, which passes every report
+// to a channel
+type reportWriter struct {
+	reports chan Report
+}
+
+// Write decodes a report and passes it to the channel
+//  Args:
+//   p ([]byte): the JSON line of the report
+//  Returns:
+//   (int): length of p
+//   (error): error if the report could not be decoded
+func (w *reportWriter) Write(p []byte) (int, error) {
+	var r Report
+	if err := json.Unmarshal(p, &r); err != nil {
+		return 0, err
+	}
+	w.reports <- r
+	return len(p), nil
+}
+
+// A routine which waits longer than the lock wait timeout is reported with
+// the routine holding the lock.
+func TestLockWaitTimeout(t *testing.T) {
+	timeout := 50 * time.Millisecond
+	d := NewDetector()
+	d.SetLockWaitTimeout(timeout)
+	out := &reportWriter{reports: make(chan Report, 10)}
+	d.SetJSONOutput(out)
+
+	x := d.NewLock()
+
+	var holder, waiter int64
+	holding := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		holder = goid.Get()
+		x.Lock()
+		close(holding)
+		<-release
+		x.Unlock()
+	}()
+	<-holding
+	go func() {
+		defer close(done)
+		waiter = goid.Get()
+		x.Lock()
+		x.Unlock()
+	}()
+
+	var r Report
+	select {
+	case r = <-out.reports:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the lock wait timeout was not reported")
+	}
+	close(release)
+	<-done
+
+	if r.Kind != KindLockWaitTimeout {
+		t.Fatalf("expected a lock wait timeout, got %s", r.Kind)
+	}
+	if len(r.Edges) != 1 {
+		t.Fatalf("expected 1 edge, got %d", len(r.Edges))
+	}
+	e := r.Edges[0]
+	if e.Goroutine != waiter || e.WaitingMs < timeout.Milliseconds() {
+		t.Errorf("expected goroutine %d waiting at least %v, got goroutine %d waiting %dms",
+			waiter, timeout, e.Goroutine, e.WaitingMs)
+	}
+	if len(e.Holders) != 1 {
+		t.Fatalf("expected 1 holder, got %d", len(e.Holders))
+	}
+	h := e.Holders[0]
+	if h.Goroutine != holder || h.HeldMs < timeout.Milliseconds() {
+		t.Errorf("expected goroutine %d holding at least %v, got goroutine %d holding %dms",
+			holder, timeout, h.Goroutine, h.HeldMs)
+	}
+	if !strings.HasSuffix(h.Acquired.File, "lockWait_test.go") || h.Acquired.Stack == "" {
+		t.Errorf("expected the acquisition of the holder with its stack, got %+v", h.Acquired)
+	}
+	if !strings.Contains(h.CurrentStack, "TestLockWaitTimeout") {
+		t.Errorf("expected the current stack of the holder, got %q", h.CurrentStack)
+	}
+}
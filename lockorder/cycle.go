@@ -0,0 +1,282 @@
+package lockorder
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: lockorder
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+cycle.go
+This file implements the search for cycles in the lock graph and the creation
+of the reports. Like in the detector, a cycle is only reported if the locks
+can actually be acquired in the order of the cycle, meaning there is no gate
+lock and no two consecutive acquisitions of the same lock are both r-locks.
+*/
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	deadlock "github.com/ErikKassubek/Deadlock-Go"
+)
+
+// reports finds all elementary cycles in the lock graph and creates a report
+// for every combination of edges which forms a valid cycle
+//  Returns:
+//   ([]*deadlock.Report): the reports in the order in which they were found
+func (a *analysis) reports() []*deadlock.Report {
+	keys := a.sortedKeys()
+	index := make(map[string]int, len(keys))
+	for i, key := range keys {
+		index[key] = i
+	}
+
+	successors := make(map[string][]string)
+	for k := range a.edges {
+		successors[k[0]] = append(successors[k[0]], k[1])
+	}
+	for _, s := range successors {
+		sort.Strings(s)
+	}
+
+	reports := make([]*deadlock.Report, 0)
+	signatures := make(map[string]bool)
+
+	// every cycle is only found from the lock with the smallest index
+	for i, start := range keys {
+		path := []string{start}
+		var search func(lock string)
+		search = func(lock string) {
+			for _, next := range successors[lock] {
+				if next == start {
+					a.cycleReports(path, &reports, signatures)
+					continue
+				}
+				if index[next] <= i || contains(path, next) {
+					continue
+				}
+				path = append(path, next)
+				search(next)
+				path = path[:len(path)-1]
+			}
+		}
+		search(start)
+	}
+
+	return reports
+}
+
+// cycleReports creates the reports for a cycle of locks. For every pair of
+// consecutive locks, the cycle can use every edge between them, so a report
+// is created for every valid combination of edges.
+//  Args:
+//   cycle ([]string): keys of the locks in the cycle
+//   reports (*[]*deadlock.Report): the reports, the new reports are added
+//   signatures (map[string]bool): signatures of the reports, to add every
+//    report only once
+//  Returns:
+//   nil
+func (a *analysis) cycleReports(cycle []string, reports *[]*deadlock.Report,
+	signatures map[string]bool) {
+	chosen := make([]edge, 0, len(cycle))
+
+	var choose func(i int)
+	choose = func(i int) {
+		if i == len(cycle) {
+			if !isCycle(chosen) {
+				return
+			}
+			r := a.newReport(cycle, chosen)
+			if !signatures[r.Signature] {
+				signatures[r.Signature] = true
+				*reports = append(*reports, r)
+			}
+			return
+		}
+
+		for _, e := range a.edges[[2]string{cycle[i], cycle[(i+1)%len(cycle)]}] {
+			if isChain(chosen, e) {
+				chosen = append(chosen, e)
+				choose(i + 1)
+				chosen = chosen[:len(chosen)-1]
+			}
+		}
+	}
+	choose(0)
+}
+
+// isChain checks if an edge can be added to a chain of edges. As in the
+// detector, the previous lock must not be acquired as r-lock in both edges
+// and the holding sets must not contain the same lock, except both are
+// r-locks (gate lock).
+//  Args:
+//   chain ([]edge): chosen edges
+//   e (edge): edge which should be added
+//  Returns:
+//   (bool): true if e can be added to chain
+func isChain(chain []edge, e edge) bool {
+	if len(chain) != 0 {
+		prev := chain[len(chain)-1].acquired
+		if held, ok := holding(e, prev.lock); !ok || (held.rLock && prev.rLock) {
+			return false
+		}
+	}
+
+	for _, c := range chain {
+		for _, h := range e.holding {
+			if held, ok := holding(c, h.lock); ok && !(held.rLock && h.rLock) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isCycle checks if the last edge of a chain closes the cycle, meaning the
+// lock acquired by the last edge is also valid as held lock of the first edge
+//  Args:
+//   chain ([]edge): chosen edges, one for every lock in the cycle
+//  Returns:
+//   (bool): true if the edges form a valid cycle
+func isCycle(chain []edge) bool {
+	last := chain[len(chain)-1].acquired
+	held, ok := holding(chain[0], last.lock)
+	return ok && !(held.rLock && last.rLock)
+}
+
+// holding returns the acquisition of a held lock of an edge
+//  Args:
+//   e (edge): the edge
+//   lock (string): key of the lock
+//  Returns:
+//   (acquisition): the acquisition of the lock
+//   (bool): true if the lock is held in e
+func holding(e edge, lock string) (acquisition, bool) {
+	for _, h := range e.holding {
+		if h.lock == lock {
+			return h, true
+		}
+	}
+	return acquisition{}, false
+}
+
+// newReport creates the report of a cycle
+//  Args:
+//   cycle ([]string): keys of the locks in the cycle
+//   chain ([]edge): edges of the cycle, edge i acquires the lock i+1 while
+//    holding lock i
+//  Returns:
+//   (*deadlock.Report): the report
+func (a *analysis) newReport(cycle []string, chain []edge) *deadlock.Report {
+	r := &deadlock.Report{
+		SchemaVersion: deadlock.ReportSchemaVersion,
+		Kind:          deadlock.KindPotentialDeadlock,
+		Locks:         make([]deadlock.ReportLock, 0, len(cycle)),
+		Edges:         make([]deadlock.ReportEdge, 0, len(chain)),
+		Count:         1,
+		Static:        true,
+	}
+
+	for _, e := range chain {
+		edge := deadlock.ReportEdge{
+			Routine:  -1,
+			Lock:     a.addLock(r, e.acquired.lock),
+			RLock:    e.acquired.rLock,
+			Acquired: newCallSite(e.acquired),
+			Holding:  make([]deadlock.ReportHeldLock, 0, len(e.holding)),
+		}
+		for _, h := range e.holding {
+			edge.Holding = append(edge.Holding, deadlock.ReportHeldLock{
+				Lock:     a.addLock(r, h.lock),
+				RLock:    h.rLock,
+				Acquired: newCallSite(h),
+			})
+		}
+		r.Edges = append(r.Edges, edge)
+	}
+
+	r.Signature = r.ComputeSignature()
+	return r
+}
+
+// addLock adds a lock to a report if it is not already part of it. The id of
+// the lock is its key. If the lock is always created at the same position,
+// this position is used as position of the creation, like in the detector.
+// Otherwise the declaration of the field or variable is used.
+//  Args:
+//   r (*deadlock.Report): the report
+//   key (string): key of the lock
+//  Returns:
+//   (string): id of the lock
+func (a *analysis) addLock(r *deadlock.Report, key string) string {
+	for _, l := range r.Locks {
+		if l.ID == key {
+			return key
+		}
+	}
+
+	info := a.locks[key]
+	created := info.declared
+	if len(info.created) == 1 {
+		created = info.created[0]
+	}
+	r.Locks = append(r.Locks, deadlock.ReportLock{
+		ID:      key,
+		Type:    info.typ,
+		Created: deadlock.CallSite{File: created.Filename, Line: created.Line},
+	})
+	return key
+}
+
+// newCallSite converts an acquisition into a call site. If the lock was
+// acquired in a called function, the call chain is added as call stack.
+//  Args:
+//   acq (acquisition): the acquisition
+//  Returns:
+//   (deadlock.CallSite): the call site
+func newCallSite(acq acquisition) deadlock.CallSite {
+	pos := acq.pos()
+	c := deadlock.CallSite{File: pos.Filename, Line: pos.Line}
+	if len(acq.stack) > 1 {
+		var b strings.Builder
+		for _, f := range acq.stack {
+			fmt.Fprintf(&b, "%s(...)\n\t%s:%d\n", f.function, f.pos.Filename, f.pos.Line)
+		}
+		c.Stack = b.String()
+	}
+	return c
+}
+
+// contains checks if a list of keys contains a key
+//  Args:
+//   list ([]string): the list
+//   key (string): the key
+//  Returns:
+//   (bool): true if list contains key
+func contains(list []string, key string) bool {
+	for _, k := range list {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
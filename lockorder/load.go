@@ -0,0 +1,201 @@
+package lockorder
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: lockorder
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+load.go
+This file implements the loading of the analyzed packages. The packages are
+listed with go list, which also compiles their dependencies. The analyzed
+packages are parsed and type checked from source, their imports are read from
+the export data of the compiler.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// a package as listed by go list
+type listedPackage struct {
+	ImportPath      string
+	Dir             string
+	GoFiles         []string
+	CgoFiles        []string
+	CompiledGoFiles []string
+	Export          string
+	ImportMap       map[string]string
+	DepOnly         bool
+	Error           *struct {
+		Err string
+	}
+}
+
+// a package which is analyzed
+type pkg struct {
+	// import path of the package
+	path string
+	// parsed files of the package
+	files []*ast.File
+	// type information of the files
+	info *types.Info
+}
+
+// importer for the imports of a single package, which resolves the import
+// paths with the import map of the package, e.g. for vendored packages
+type pkgImporter struct {
+	// importer for the export data
+	imp types.Importer
+	// import map of the package
+	importMap map[string]string
+}
+
+// Import imports a package from its export data
+//  Args:
+//   path (string): import path as given in the source
+//  Returns:
+//   (*types.Package): the imported package
+//   (error): error if the package could not be imported
+func (i *pkgImporter) Import(path string) (*types.Package, error) {
+	if mapped, ok := i.importMap[path]; ok {
+		path = mapped
+	}
+	return i.imp.Import(path)
+}
+
+// load lists, parses and type checks the packages matching the patterns
+//  Args:
+//   fset (*token.FileSet): file set for the parsed files
+//   patterns ([]string): patterns of the packages
+//   options (Options): options of the analysis
+//  Returns:
+//   ([]*pkg): the analyzed packages, without Deadlock-Go itself
+//   (error): error if a package could not be loaded
+func load(fset *token.FileSet, patterns []string, options Options) ([]*pkg, error) {
+	args := []string{"list", "-e", "-export", "-deps", "-compiled",
+		"-json=ImportPath,Dir,GoFiles,CgoFiles,CompiledGoFiles,Export,ImportMap,DepOnly,Error"}
+	if options.Tags != "" {
+		args = append(args, "-tags", options.Tags)
+	}
+	args = append(args, "--")
+	args = append(args, patterns...)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("go", args...)
+	cmd.Dir = options.Dir
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	exports := make(map[string]string)
+	listed := make([]*listedPackage, 0)
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		p := &listedPackage{}
+		if err := dec.Decode(p); err != nil {
+			return nil, fmt.Errorf("go list: %v", err)
+		}
+		if p.Error != nil {
+			return nil, fmt.Errorf("%s: %s", p.ImportPath, p.Error.Err)
+		}
+		exports[p.ImportPath] = p.Export
+		if !p.DepOnly && !isDeadlockPath(p.ImportPath) {
+			listed = append(listed, p)
+		}
+	}
+
+	imp := importer.ForCompiler(fset, "gc", func(path string) (io.ReadCloser, error) {
+		export, ok := exports[path]
+		if !ok || export == "" {
+			return nil, fmt.Errorf("no export data for %s", path)
+		}
+		return os.Open(export)
+	})
+
+	pkgs := make([]*pkg, 0, len(listed))
+	for _, p := range listed {
+		// files with cgo can only be type checked after they were processed
+		// by cgo, the positions then refer to the generated files
+		names := p.GoFiles
+		dir := p.Dir
+		if len(p.CgoFiles) > 0 {
+			names = p.CompiledGoFiles
+			dir = ""
+		}
+
+		files := make([]*ast.File, 0, len(names))
+		for _, name := range names {
+			if dir != "" {
+				name = filepath.Join(dir, name)
+			}
+			file, err := parser.ParseFile(fset, name, nil, 0)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, file)
+		}
+
+		info := &types.Info{
+			Types:      make(map[ast.Expr]types.TypeAndValue),
+			Defs:       make(map[*ast.Ident]types.Object),
+			Uses:       make(map[*ast.Ident]types.Object),
+			Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		}
+		conf := types.Config{
+			Importer: &pkgImporter{imp: imp, importMap: p.ImportMap},
+		}
+		if _, err := conf.Check(p.ImportPath, fset, files, info); err != nil {
+			return nil, err
+		}
+
+		pkgs = append(pkgs, &pkg{
+			path:  p.ImportPath,
+			files: files,
+			info:  info,
+		})
+	}
+
+	return pkgs, nil
+}
+
+// isDeadlockPath checks if a package is Deadlock-Go or one of its subpackages
+//  Args:
+//   path (string): import path of the package
+//  Returns:
+//   (bool): true if the package belongs to Deadlock-Go
+func isDeadlockPath(path string) bool {
+	return path == deadlockPath || strings.HasPrefix(path, deadlockPath+"/")
+}
@@ -0,0 +1,559 @@
+package lockorder
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: lockorder
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+lockorder.go
+Package lockorder implements a static analysis of the lock order, which
+complements the detector. The detector only finds potential deadlocks on
+paths which were executed, the static analysis also finds them on paths which
+are rarely executed, e.g. in error handling.
+The analysis builds the lock graph of the analyzed packages from their source.
+Locks are identified by the field or package level variable which contains
+them, e.g. all locks in the field mu of the struct Server are one lock. Calls
+of other functions are followed, if the called function is known statically.
+The found cycles are returned in the same format as the reports of the
+detector.
+*/
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+
+	deadlock "github.com/ErikKassubek/Deadlock-Go"
+)
+
+// import path of Deadlock-Go
+const deadlockPath = "github.com/ErikKassubek/Deadlock-Go"
+
+// Options are the options of the static analysis
+type Options struct {
+	// Directory in which the packages are listed, the current directory if
+	// it is empty
+	Dir string
+	// Build tags of the analyzed packages, as comma separated list
+	Tags string
+}
+
+// a lock as identified by the analysis
+type lockInfo struct {
+	// "Mutex" or "RWMutex"
+	typ string
+	// position of the declaration of the field or variable
+	declared token.Position
+	// positions where the lock is created with NewLock or NewRWLock
+	created []token.Position
+}
+
+// state of the analysis
+type analysis struct {
+	// file set of all analyzed files
+	fset *token.FileSet
+	// analyzed functions by the position of their declaration
+	funcs map[token.Pos]*function
+	// function literals
+	lits map[*ast.FuncLit]*function
+	// all functions in the order of their declaration
+	order []*function
+	// identified locks by their key
+	locks map[string]*lockInfo
+	// edges of the lock graph by the held and the acquired lock
+	edges map[[2]string][]edge
+	// keys of the recorded edges, to record every edge only once
+	edgeKeys map[string]bool
+}
+
+// Analyze runs the static analysis of the lock order on the packages
+// matching patterns and returns the found cycles as reports of potential
+// deadlocks. The packages and their dependencies must compile.
+//  Args:
+//   patterns ([]string): patterns of the packages as for go list, e.g. ./...
+//   options (Options): options of the analysis
+//  Returns:
+//   ([]*deadlock.Report): the reports of the found cycles
+//   (error): error if the packages could not be loaded
+func Analyze(patterns []string, options Options) ([]*deadlock.Report, error) {
+	a := &analysis{
+		fset:     token.NewFileSet(),
+		funcs:    make(map[token.Pos]*function),
+		lits:     make(map[*ast.FuncLit]*function),
+		order:    make([]*function, 0),
+		locks:    make(map[string]*lockInfo),
+		edges:    make(map[[2]string][]edge),
+		edgeKeys: make(map[string]bool),
+	}
+
+	pkgs, err := load(a.fset, patterns, options)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range pkgs {
+		a.collectFunctions(p)
+	}
+
+	// the summaries depend on the summaries of the called functions, so the
+	// functions are walked until no summary changes
+	for changed := true; changed; {
+		changed = false
+		for _, fn := range a.order {
+			if a.walk(fn, false) {
+				changed = true
+			}
+		}
+	}
+
+	for _, fn := range a.order {
+		a.walk(fn, true)
+	}
+
+	for _, p := range pkgs {
+		a.collectCreations(p)
+	}
+
+	return a.reports(), nil
+}
+
+// collectFunctions adds all functions and function literals of a package to
+// the analysis
+//  Args:
+//   p (*pkg): the package
+//  Returns:
+//   nil
+func (a *analysis) collectFunctions(p *pkg) {
+	for _, file := range p.files {
+		for _, decl := range file.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok || fd.Body == nil {
+				continue
+			}
+			obj, ok := p.info.Defs[fd.Name].(*types.Func)
+			if !ok {
+				continue
+			}
+			fn := a.addFunction(funcName(obj), fd.Body, p)
+			a.funcs[obj.Pos()] = fn
+			a.collectLits(fn, fd.Body, true)
+		}
+	}
+}
+
+// collectLits adds the function literals in a function to the analysis.
+// Like in the call stacks of go, the literals are named after the
+// surrounding function, e.g. "main.main.func1" and "main.main.func1.1".
+//  Args:
+//   parent (*function): the surrounding function
+//   body (*ast.BlockStmt): body of the surrounding function
+//   top (bool): true if the surrounding function is no literal
+//  Returns:
+//   nil
+func (a *analysis) collectLits(parent *function, body *ast.BlockStmt, top bool) {
+	count := 0
+	ast.Inspect(body, func(n ast.Node) bool {
+		lit, ok := n.(*ast.FuncLit)
+		if !ok {
+			return true
+		}
+		count++
+		name := fmt.Sprintf("%s.%d", parent.name, count)
+		if top {
+			name = fmt.Sprintf("%s.func%d", parent.name, count)
+		}
+		fn := a.addFunction(name, lit.Body, parent.pkg)
+		a.lits[lit] = fn
+		a.collectLits(fn, lit.Body, false)
+		return false
+	})
+}
+
+// addFunction adds a function to the analysis
+//  Args:
+//   name (string): name of the function
+//   body (*ast.BlockStmt): body of the function
+//   p (*pkg): package of the function
+//  Returns:
+//   (*function): the added function
+func (a *analysis) addFunction(name string, body *ast.BlockStmt, p *pkg) *function {
+	fn := &function{
+		name:     name,
+		body:     body,
+		pkg:      p,
+		acquires: make([]acquisition, 0),
+		releases: make([]string, 0),
+		keeps:    make([]acquisition, 0),
+	}
+	a.order = append(a.order, fn)
+	return fn
+}
+
+// callee returns the function called by a call, if it is known statically
+//  Args:
+//   p (*pkg): package of the call
+//   call (*ast.CallExpr): the call
+//  Returns:
+//   (*function): the called function, nil if it is not known or not analyzed
+func (a *analysis) callee(p *pkg, call *ast.CallExpr) *function {
+	fun := unparen(call.Fun)
+	// instantiation of a generic function
+	switch f := fun.(type) {
+	case *ast.IndexExpr:
+		fun = unparen(f.X)
+	case *ast.IndexListExpr:
+		fun = unparen(f.X)
+	}
+
+	var obj types.Object
+	switch f := fun.(type) {
+	case *ast.FuncLit:
+		return a.lits[f]
+	case *ast.Ident:
+		obj = p.info.Uses[f]
+	case *ast.SelectorExpr:
+		if sel := p.info.Selections[f]; sel != nil {
+			// calls of interface methods are not known statically
+			if types.IsInterface(sel.Recv()) {
+				return nil
+			}
+			obj = sel.Obj()
+		} else {
+			obj = p.info.Uses[f.Sel]
+		}
+	}
+
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return nil
+	}
+	// the instances of generic functions have the position of the generic
+	// function
+	return a.funcs[fn.Pos()]
+}
+
+// lockKey returns the key of a lock and adds the lock to the analysis
+//  Args:
+//   p (*pkg): package of the expression
+//   x (ast.Expr): expression which contains the lock
+//   path ([]int): indices of the fields which lead from x to the lock, e.g.
+//    for embedded locks
+//  Returns:
+//   (string): the key, empty if the lock can not be identified
+func (a *analysis) lockKey(p *pkg, x ast.Expr, path []int) string {
+	key, v := a.key(p, x, path)
+	if key == "" || v == nil {
+		return ""
+	}
+
+	if _, ok := a.locks[key]; !ok {
+		a.locks[key] = &lockInfo{
+			typ:      lockType(v.Type()),
+			declared: a.position(v.Pos()),
+			created:  make([]token.Position, 0),
+		}
+	}
+	return key
+}
+
+// key returns the key of a field or package level variable. Fields are
+// identified by the named struct type which contains them, e.g.
+// "example.com/server.Server.mu", package level variables by their
+// package, e.g. "example.com/server.mu".
+//  Args:
+//   p (*pkg): package of the expression
+//   x (ast.Expr): expression of the variable or of the struct which
+//    contains the field
+//   path ([]int): indices of the fields which lead from x to the variable
+//  Returns:
+//   (string): the key, empty if the variable can not be identified, e.g.
+//    for local variables
+//   (*types.Var): the field or variable
+func (a *analysis) key(p *pkg, x ast.Expr, path []int) (string, *types.Var) {
+	x = unparen(x)
+	if len(path) == 0 {
+		switch e := x.(type) {
+		case *ast.SelectorExpr:
+			if sel := p.info.Selections[e]; sel != nil {
+				if sel.Kind() != types.FieldVal {
+					return "", nil
+				}
+				return a.key(p, e.X, sel.Index())
+			}
+			return varKey(p.info.Uses[e.Sel])
+		case *ast.Ident:
+			return varKey(p.info.Uses[e])
+		case *ast.StarExpr:
+			return a.key(p, e.X, nil)
+		case *ast.UnaryExpr:
+			if e.Op == token.AND {
+				return a.key(p, e.X, nil)
+			}
+		}
+		return "", nil
+	}
+
+	key := ""
+	var field *types.Var
+	t := p.info.TypeOf(x)
+	for _, i := range path {
+		st, ok := deref(t).Underlying().(*types.Struct)
+		if !ok {
+			return "", nil
+		}
+		field = st.Field(i)
+
+		if named, ok := deref(t).(*types.Named); ok && named.Obj().Pkg() != nil {
+			key = named.Obj().Pkg().Path() + "." + named.Obj().Name() + "." + field.Name()
+		} else if key != "" {
+			key += "." + field.Name()
+		} else {
+			// field of an anonymous struct in a variable
+			base, _ := a.key(p, x, nil)
+			if base == "" {
+				return "", nil
+			}
+			key = base + "." + field.Name()
+		}
+		t = field.Type()
+	}
+
+	return key, field
+}
+
+// varKey returns the key of a package level variable
+//  Args:
+//   obj (types.Object): the variable
+//  Returns:
+//   (string): the key, empty if obj is not a package level variable
+//   (*types.Var): the variable
+func varKey(obj types.Object) (string, *types.Var) {
+	v, ok := obj.(*types.Var)
+	if !ok || v.Pkg() == nil || v.Parent() != v.Pkg().Scope() {
+		return "", nil
+	}
+	return v.Pkg().Path() + "." + v.Name(), v
+}
+
+// collectCreations finds the positions where the locks in fields and
+// package level variables are created with NewLock or NewRWLock
+//  Args:
+//   p (*pkg): the package
+//  Returns:
+//   nil
+func (a *analysis) collectCreations(p *pkg) {
+	for _, file := range p.files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.AssignStmt:
+				if len(n.Lhs) != len(n.Rhs) {
+					return true
+				}
+				for i, rhs := range n.Rhs {
+					if pos, ok := a.newLock(p, rhs); ok {
+						key, _ := a.key(p, n.Lhs[i], nil)
+						a.addCreation(key, pos)
+					}
+				}
+
+			case *ast.ValueSpec:
+				for i, value := range n.Values {
+					if pos, ok := a.newLock(p, value); ok && i < len(n.Names) {
+						key, _ := varKey(p.info.Defs[n.Names[i]])
+						a.addCreation(key, pos)
+					}
+				}
+
+			case *ast.CompositeLit:
+				named, ok := deref(p.info.TypeOf(n)).(*types.Named)
+				if !ok || named.Obj().Pkg() == nil {
+					return true
+				}
+				st, ok := named.Underlying().(*types.Struct)
+				if !ok {
+					return true
+				}
+				prefix := named.Obj().Pkg().Path() + "." + named.Obj().Name() + "."
+				for i, elt := range n.Elts {
+					name := ""
+					if kv, ok := elt.(*ast.KeyValueExpr); ok {
+						if id, ok := kv.Key.(*ast.Ident); ok {
+							name = id.Name
+						}
+						elt = kv.Value
+					} else if i < st.NumFields() {
+						name = st.Field(i).Name()
+					}
+					if pos, ok := a.newLock(p, elt); ok && name != "" {
+						a.addCreation(prefix+name, pos)
+					}
+				}
+			}
+			return true
+		})
+	}
+}
+
+// newLock checks if an expression is a call of NewLock or NewRWLock
+//  Args:
+//   p (*pkg): package of the expression
+//   e (ast.Expr): the expression
+//  Returns:
+//   (token.Position): position of the call
+//   (bool): true if e is a call of NewLock or NewRWLock
+func (a *analysis) newLock(p *pkg, e ast.Expr) (token.Position, bool) {
+	call, ok := unparen(e).(*ast.CallExpr)
+	if !ok {
+		return token.Position{}, false
+	}
+
+	var obj types.Object
+	switch f := unparen(call.Fun).(type) {
+	case *ast.Ident:
+		obj = p.info.Uses[f]
+	case *ast.SelectorExpr:
+		obj = p.info.Uses[f.Sel]
+	}
+	fn, ok := obj.(*types.Func)
+	if !ok || fn.Pkg() == nil || fn.Pkg().Path() != deadlockPath ||
+		(fn.Name() != "NewLock" && fn.Name() != "NewRWLock") {
+		return token.Position{}, false
+	}
+	return a.position(call.Lparen), true
+}
+
+// addCreation adds a position where a lock is created
+//  Args:
+//   key (string): key of the lock
+//   pos (token.Position): position of the creation
+//  Returns:
+//   nil
+func (a *analysis) addCreation(key string, pos token.Position) {
+	l, ok := a.locks[key]
+	if !ok {
+		return
+	}
+	for _, c := range l.created {
+		if c == pos {
+			return
+		}
+	}
+	l.created = append(l.created, pos)
+}
+
+// addEdge adds an edge to the lock graph, if the same edge was not recorded
+// before
+//  Args:
+//   e (edge): the edge
+//  Returns:
+//   nil
+func (a *analysis) addEdge(e edge) {
+	key := ""
+	for _, h := range e.holding {
+		key += fmt.Sprintf("%s@%v,", h.lock, h.stack)
+	}
+	key += fmt.Sprintf("->%s@%v", e.acquired.lock, e.acquired.stack)
+	if a.edgeKeys[key] {
+		return
+	}
+	a.edgeKeys[key] = true
+
+	for _, h := range e.holding {
+		k := [2]string{h.lock, e.acquired.lock}
+		a.edges[k] = append(a.edges[k], e)
+	}
+}
+
+// position returns the position in the file set of the analysis
+//  Args:
+//   pos (token.Pos): the position
+//  Returns:
+//   (token.Position): the position with file and line
+func (a *analysis) position(pos token.Pos) token.Position {
+	return a.fset.Position(pos)
+}
+
+// funcName returns the name of a function as in the call stacks of go,
+// e.g. "example.com/server.(*Server).Handle"
+//  Args:
+//   fn (*types.Func): the function
+//  Returns:
+//   (string): the name
+func funcName(fn *types.Func) string {
+	name := fn.Name()
+	if recv := fn.Type().(*types.Signature).Recv(); recv != nil {
+		t := recv.Type()
+		if ptr, ok := t.(*types.Pointer); ok {
+			if named, ok := ptr.Elem().(*types.Named); ok {
+				name = "(*" + named.Obj().Name() + ")." + name
+			}
+		} else if named, ok := t.(*types.Named); ok {
+			name = named.Obj().Name() + "." + name
+		}
+	}
+	if fn.Pkg() == nil {
+		return name
+	}
+	return fn.Pkg().Path() + "." + name
+}
+
+// lockType returns the type of a lock of Deadlock-Go
+//  Args:
+//   t (types.Type): the type
+//  Returns:
+//   (string): "Mutex" or "RWMutex", empty if t is no lock of Deadlock-Go
+func lockType(t types.Type) string {
+	named, ok := deref(t).(*types.Named)
+	if !ok || named.Obj().Pkg() == nil || named.Obj().Pkg().Path() != deadlockPath {
+		return ""
+	}
+	if name := named.Obj().Name(); name == "Mutex" || name == "RWMutex" {
+		return name
+	}
+	return ""
+}
+
+// deref removes a pointer from a type
+//  Args:
+//   t (types.Type): the type
+//  Returns:
+//   (types.Type): the type the pointer points to, t if it is no pointer
+func deref(t types.Type) types.Type {
+	if ptr, ok := t.(*types.Pointer); ok {
+		return ptr.Elem()
+	}
+	return t
+}
+
+// sortedKeys returns the keys of the locks in the lock graph in sorted order
+//  Returns:
+//   ([]string): the sorted keys
+func (a *analysis) sortedKeys() []string {
+	keys := make([]string, 0, len(a.locks))
+	for key := range a.locks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
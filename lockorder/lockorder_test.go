@@ -0,0 +1,125 @@
+package lockorder
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: lockorder
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+lockorder_test.go
+Tests of the static analysis on the packages in testdata
+*/
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	deadlock "github.com/ErikKassubek/Deadlock-Go"
+)
+
+// lockIDs returns the sorted ids of the locks of a report
+//  Args:
+//   r (*deadlock.Report): the report
+//  Returns:
+//   (string): the ids separated by commas
+func lockIDs(r *deadlock.Report) string {
+	ids := make([]string, 0, len(r.Locks))
+	for _, l := range r.Locks {
+		ids = append(ids, l.ID)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// The cycles of the samples are found and cycles which can not lead to a
+// deadlock are not reported.
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		// package in testdata
+		pkg string
+		// locks of the expected reports
+		want []string
+	}{
+		{"inversion", []string{
+			"example.com/lockorder/inversion.Bank.accounts,example.com/lockorder/inversion.Bank.audit",
+		}},
+		{"errorpath", []string{
+			"example.com/lockorder/errorpath.Cache.mu,example.com/lockorder/errorpath.Cache.stats",
+		}},
+		{"consistent", nil},
+		{"gate", nil},
+		{"rlock", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pkg, func(t *testing.T) {
+			reports, err := Analyze([]string{"./" + test.pkg}, Options{Dir: "testdata"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, 0, len(reports))
+			for _, r := range reports {
+				if r.Kind != deadlock.KindPotentialDeadlock || !r.Static {
+					t.Errorf("unexpected report kind %s, static %t", r.Kind, r.Static)
+				}
+				if r.Signature == "" {
+					t.Error("the report is not signed")
+				}
+				got = append(got, lockIDs(r))
+			}
+			if strings.Join(got, ";") != strings.Join(test.want, ";") {
+				t.Fatalf("expected reports of %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+// The acquisition in a called function contains the call chain.
+func TestAnalyzeCallChain(t *testing.T) {
+	reports, err := Analyze([]string{"./inversion"}, Options{Dir: "testdata"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 report, got %d", len(reports))
+	}
+
+	for _, e := range reports[0].Edges {
+		if !strings.HasSuffix(e.Lock, ".audit") {
+			continue
+		}
+		if !strings.Contains(e.Acquired.Stack, "(*Bank).log") ||
+			!strings.Contains(e.Acquired.Stack, "(*Bank).Transfer") {
+			t.Fatalf("the call chain is missing: %q", e.Acquired.Stack)
+		}
+		return
+	}
+	t.Fatal("the acquisition of audit is missing")
+}
+
+// Packages which do not compile are not analyzed.
+func TestAnalyzeError(t *testing.T) {
+	if _, err := Analyze([]string{"./missing"}, Options{Dir: "testdata"}); err == nil {
+		t.Fatal("expected an error for a missing package")
+	}
+}
@@ -0,0 +1,23 @@
+// Package consistent always acquires the locks in the same order
+package consistent
+
+import deadlock "github.com/ErikKassubek/Deadlock-Go"
+
+var (
+	first  = deadlock.NewLock()
+	second = deadlock.NewLock()
+)
+
+func A() {
+	first.Lock()
+	second.Lock()
+	second.Unlock()
+	first.Unlock()
+}
+
+func B() {
+	first.Lock()
+	defer first.Unlock()
+	second.Lock()
+	defer second.Unlock()
+}
@@ -0,0 +1,35 @@
+// Package errorpath acquires two locks in inverted order only on the path
+// of an error
+package errorpath
+
+import (
+	"errors"
+
+	deadlock "github.com/ErikKassubek/Deadlock-Go"
+)
+
+type Cache struct {
+	mu      *deadlock.RWMutex
+	stats   *deadlock.Mutex
+	entries map[string]string
+}
+
+func (c *Cache) Get(key string) (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	v, ok := c.entries[key]
+	if !ok {
+		c.stats.Lock()
+		c.stats.Unlock()
+		return "", errors.New("not found")
+	}
+	return v, nil
+}
+
+func (c *Cache) Reset() {
+	c.stats.Lock()
+	defer c.stats.Unlock()
+	c.mu.Lock()
+	c.entries = make(map[string]string)
+	c.mu.Unlock()
+}
@@ -0,0 +1,29 @@
+// Package gate acquires two locks in inverted order, but always while
+// holding the same gate lock
+package gate
+
+import deadlock "github.com/ErikKassubek/Deadlock-Go"
+
+var (
+	gate = deadlock.NewLock()
+	x    = deadlock.NewLock()
+	y    = deadlock.NewLock()
+)
+
+func A() {
+	gate.Lock()
+	defer gate.Unlock()
+	x.Lock()
+	y.Lock()
+	y.Unlock()
+	x.Unlock()
+}
+
+func B() {
+	gate.Lock()
+	defer gate.Unlock()
+	y.Lock()
+	x.Lock()
+	x.Unlock()
+	y.Unlock()
+}
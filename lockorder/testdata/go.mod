@@ -0,0 +1,9 @@
+module example.com/lockorder
+
+go 1.18
+
+require github.com/ErikKassubek/Deadlock-Go v0.0.0
+
+require github.com/petermattis/goid v0.0.0-20220512133901-1f93b0c1af58 // indirect
+
+replace github.com/ErikKassubek/Deadlock-Go => ../..
@@ -0,0 +1,32 @@
+// Package inversion acquires two locks in inverted order, once in a called
+// function
+package inversion
+
+import deadlock "github.com/ErikKassubek/Deadlock-Go"
+
+type Bank struct {
+	accounts *deadlock.Mutex
+	audit    *deadlock.Mutex
+}
+
+func NewBank() *Bank {
+	return &Bank{accounts: deadlock.NewLock(), audit: deadlock.NewLock()}
+}
+
+func (b *Bank) Transfer() {
+	b.accounts.Lock()
+	defer b.accounts.Unlock()
+	b.log()
+}
+
+func (b *Bank) log() {
+	b.audit.Lock()
+	b.audit.Unlock()
+}
+
+func (b *Bank) Audit() {
+	b.audit.Lock()
+	b.accounts.Lock()
+	b.accounts.Unlock()
+	b.audit.Unlock()
+}
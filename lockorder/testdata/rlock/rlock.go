@@ -0,0 +1,24 @@
+// Package rlock acquires two r-locks in inverted order, which do not block
+// each other
+package rlock
+
+import deadlock "github.com/ErikKassubek/Deadlock-Go"
+
+var (
+	x = deadlock.NewRWLock()
+	y = deadlock.NewRWLock()
+)
+
+func A() {
+	x.RLock()
+	y.RLock()
+	y.RUnlock()
+	x.RUnlock()
+}
+
+func B() {
+	y.RLock()
+	x.RLock()
+	x.RUnlock()
+	y.RUnlock()
+}
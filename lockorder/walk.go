@@ -0,0 +1,607 @@
+package lockorder
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: lockorder
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+walk.go
+This file implements the analysis of the functions. Every function is walked
+in the order of its statements, while the locks held at every point are
+tracked. Branches are walked separately and the held locks are joined
+afterwards. Calls of other functions are replaced by the summary of the
+called function, which contains the locks acquired and released by it.
+*/
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// a frame of the call chain of an acquisition
+type frame struct {
+	// name of the function
+	function string
+	// position of the call or of the acquisition in the function
+	pos token.Position
+}
+
+// an acquisition of a lock
+type acquisition struct {
+	// key of the lock
+	lock string
+	// true if the lock was acquired with RLock
+	rLock bool
+	// call chain from the acquisition to the analyzed function, the
+	// innermost frame is the position where the lock was acquired
+	stack []frame
+}
+
+// an edge of the lock graph, meaning the acquisition of a lock while other
+// locks were held
+type edge struct {
+	// locks which were held in the order of their acquisition
+	holding []acquisition
+	// acquisition of the lock
+	acquired acquisition
+}
+
+// summary of a function
+type function struct {
+	// name of the function
+	name string
+	// body of the function
+	body *ast.BlockStmt
+	// package of the function
+	pkg *pkg
+	// locks acquired by the function and the functions called by it
+	acquires []acquisition
+	// locks which are released by the function, but were acquired by the caller
+	releases []string
+	// locks which are still held when the function returns
+	keeps []acquisition
+}
+
+// the held locks at a point of a function
+type state []acquisition
+
+// holds checks if a lock is held
+//  Args:
+//   lock (string): key of the lock
+//  Returns:
+//   (int): index of the lock in the state, -1 if it is not held
+func (s state) holds(lock string) int {
+	for i, a := range s {
+		if a.lock == lock {
+			return i
+		}
+	}
+	return -1
+}
+
+// join joins the held locks of two branches. A lock is held after the
+// branches if it is held in one of them.
+//  Args:
+//   other (state): held locks of the other branch
+//  Returns:
+//   (state): the joined locks
+func (s state) join(other state) state {
+	res := append(state{}, s...)
+	for _, a := range other {
+		if res.holds(a.lock) == -1 {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// walker for a single function
+type walker struct {
+	// analysis the function belongs to
+	a *analysis
+	// analyzed function
+	fn *function
+	// true if the edges should be recorded
+	edges bool
+	// locks which are released by deferred calls
+	deferred map[string]bool
+	// locks which are acquired by the function
+	acquired map[string]bool
+	// held locks at the returns of the function
+	returned state
+	// true if the summary of the function has changed
+	changed bool
+}
+
+// walk walks a function, updates its summary and, if edges is set, records
+// the edges of the lock graph
+//  Args:
+//   fn (*function): the function
+//   edges (bool): true if the edges should be recorded
+//  Returns:
+//   (bool): true if the summary of the function has changed
+func (a *analysis) walk(fn *function, edges bool) bool {
+	w := &walker{
+		a:        a,
+		fn:       fn,
+		edges:    edges,
+		deferred: make(map[string]bool),
+		acquired: make(map[string]bool),
+	}
+
+	held, terminated := w.stmts(fn.body.List, state{})
+	if !terminated {
+		w.returned = w.returned.join(held)
+	}
+
+	for _, acq := range w.returned {
+		if !w.deferred[acq.lock] && !containsAcquisition(fn.keeps, acq) {
+			fn.keeps = append(fn.keeps, acq)
+			w.changed = true
+		}
+	}
+	// a deferred release of a lock which is not acquired by the function
+	// releases the lock of the caller
+	for lock := range w.deferred {
+		if !w.acquired[lock] {
+			w.release(state{}, lock)
+		}
+	}
+
+	return w.changed
+}
+
+// stmts walks a list of statements
+//  Args:
+//   list ([]ast.Stmt): the statements
+//   held (state): locks held before the statements
+//  Returns:
+//   (state): locks held after the statements
+//   (bool): true if the statements do not continue after the last statement,
+//    e.g. because of a return
+func (w *walker) stmts(list []ast.Stmt, held state) (state, bool) {
+	for _, stmt := range list {
+		var terminated bool
+		held, terminated = w.stmt(stmt, held)
+		if terminated {
+			return held, true
+		}
+	}
+	return held, false
+}
+
+// stmt walks a single statement
+//  Args:
+//   stmt (ast.Stmt): the statement
+//   held (state): locks held before the statement
+//  Returns:
+//   (state): locks held after the statement
+//   (bool): true if the statement does not continue, e.g. a return
+func (w *walker) stmt(stmt ast.Stmt, held state) (state, bool) {
+	switch s := stmt.(type) {
+	case *ast.BlockStmt:
+		return w.stmts(s.List, held)
+
+	case *ast.LabeledStmt:
+		return w.stmt(s.Stmt, held)
+
+	case *ast.ReturnStmt:
+		for _, r := range s.Results {
+			held = w.exprs(r, held)
+		}
+		w.returned = w.returned.join(held)
+		return held, true
+
+	case *ast.BranchStmt:
+		// the locks held at break, continue and goto are not followed
+		return held, true
+
+	case *ast.ExprStmt:
+		held = w.exprs(s.X, held)
+		if call, ok := s.X.(*ast.CallExpr); ok && w.isPanic(call) {
+			return held, true
+		}
+		return held, false
+
+	case *ast.DeferStmt:
+		w.deferCall(s.Call)
+		return held, false
+
+	case *ast.GoStmt:
+		// the called function runs in another routine, which does not hold
+		// the locks of this routine
+		for _, arg := range s.Call.Args {
+			held = w.exprs(arg, held)
+		}
+		return held, false
+
+	case *ast.IfStmt:
+		if s.Init != nil {
+			held, _ = w.stmt(s.Init, held)
+		}
+		held = w.exprs(s.Cond, held)
+		then, thenTerminated := w.stmt(s.Body, held)
+		els, elseTerminated := held, false
+		if s.Else != nil {
+			els, elseTerminated = w.stmt(s.Else, held)
+		}
+		return w.joinBranches([]state{then, els}, []bool{thenTerminated, elseTerminated})
+
+	case *ast.ForStmt:
+		if s.Init != nil {
+			held, _ = w.stmt(s.Init, held)
+		}
+		if s.Cond != nil {
+			held = w.exprs(s.Cond, held)
+		}
+		body, terminated := w.stmt(s.Body, held)
+		if !terminated && s.Post != nil {
+			body, _ = w.stmt(s.Post, body)
+		}
+		if terminated {
+			return held, false
+		}
+		return held.join(body), false
+
+	case *ast.RangeStmt:
+		held = w.exprs(s.X, held)
+		body, terminated := w.stmt(s.Body, held)
+		if terminated {
+			return held, false
+		}
+		return held.join(body), false
+
+	case *ast.SwitchStmt:
+		if s.Init != nil {
+			held, _ = w.stmt(s.Init, held)
+		}
+		if s.Tag != nil {
+			held = w.exprs(s.Tag, held)
+		}
+		return w.clauses(s.Body, held)
+
+	case *ast.TypeSwitchStmt:
+		if s.Init != nil {
+			held, _ = w.stmt(s.Init, held)
+		}
+		held, _ = w.stmt(s.Assign, held)
+		return w.clauses(s.Body, held)
+
+	case *ast.SelectStmt:
+		return w.clauses(s.Body, held)
+
+	case nil:
+		return held, false
+
+	default:
+		return w.exprs(s, held), false
+	}
+}
+
+// clauses walks the clauses of a switch or select statement
+//  Args:
+//   body (*ast.BlockStmt): body of the statement
+//   held (state): locks held before the clauses
+//  Returns:
+//   (state): locks held after the statement
+//   (bool): true if no clause continues after the statement
+func (w *walker) clauses(body *ast.BlockStmt, held state) (state, bool) {
+	states := make([]state, 0, len(body.List)+1)
+	terminated := make([]bool, 0, len(body.List)+1)
+	hasDefault := false
+	for _, c := range body.List {
+		clause := held
+		var list []ast.Stmt
+		switch c := c.(type) {
+		case *ast.CaseClause:
+			for _, e := range c.List {
+				clause = w.exprs(e, clause)
+			}
+			hasDefault = hasDefault || c.List == nil
+			list = c.Body
+		case *ast.CommClause:
+			clause, _ = w.stmt(c.Comm, clause)
+			hasDefault = hasDefault || c.Comm == nil
+			list = c.Body
+		}
+		clause, t := w.stmts(list, clause)
+		states = append(states, clause)
+		terminated = append(terminated, t)
+	}
+
+	// without default, the statement can continue without any clause
+	if !hasDefault {
+		states = append(states, held)
+		terminated = append(terminated, false)
+	}
+	return w.joinBranches(states, terminated)
+}
+
+// joinBranches joins the held locks of the branches of a statement
+//  Args:
+//   states ([]state): locks held at the end of the branches
+//   terminated ([]bool): true for branches which do not continue
+//  Returns:
+//   (state): locks held after the statement
+//   (bool): true if no branch continues
+func (w *walker) joinBranches(states []state, terminated []bool) (state, bool) {
+	var res state
+	allTerminated := true
+	for i, s := range states {
+		if terminated[i] {
+			continue
+		}
+		res = res.join(s)
+		allTerminated = false
+	}
+	return res, allTerminated
+}
+
+// exprs walks the calls in an expression or simple statement in the order
+// in which they are evaluated. Function literals are not entered, they are
+// walked as own functions.
+//  Args:
+//   n (ast.Node): the expression or statement
+//   held (state): locks held before the expression
+//  Returns:
+//   (state): locks held after the expression
+func (w *walker) exprs(n ast.Node, held state) state {
+	if n == nil {
+		return held
+	}
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.CallExpr:
+			held = w.exprs(n.Fun, held)
+			for _, arg := range n.Args {
+				held = w.exprs(arg, held)
+			}
+			held = w.call(n, held)
+			return false
+		}
+		return true
+	})
+	return held
+}
+
+// call handles a call of a lock method or of another function
+//  Args:
+//   call (*ast.CallExpr): the call
+//   held (state): locks held before the call
+//  Returns:
+//   (state): locks held after the call
+func (w *walker) call(call *ast.CallExpr, held state) state {
+	if lock, method := w.lockMethod(call); lock != "" {
+		switch method {
+		case "Lock", "RLock":
+			acq := acquisition{
+				lock:  lock,
+				rLock: method == "RLock",
+				stack: []frame{{function: w.fn.name, pos: w.a.position(call.Lparen)}},
+			}
+			return w.acquire(held, acq)
+		case "Unlock", "RUnlock":
+			return w.release(held, lock)
+		}
+		// TryLock does not block, the lock is therefore not tracked
+		return held
+	}
+
+	callee := w.a.callee(w.fn.pkg, call)
+	if callee == nil {
+		return held
+	}
+
+	pos := w.a.position(call.Lparen)
+	for _, acq := range callee.acquires {
+		held = w.acquire(held, acq.calledFrom(w.fn.name, pos))
+	}
+	for _, lock := range callee.releases {
+		held = w.release(held, lock)
+	}
+	for _, acq := range callee.keeps {
+		if held.holds(acq.lock) == -1 {
+			held = append(append(state{}, held...), acq.calledFrom(w.fn.name, pos))
+			w.acquired[acq.lock] = true
+		}
+	}
+	return held
+}
+
+// deferCall handles a deferred call. Only the releases of locks are
+// considered, they are applied at the end of the function.
+//  Args:
+//   call (*ast.CallExpr): the deferred call
+//  Returns:
+//   nil
+func (w *walker) deferCall(call *ast.CallExpr) {
+	if lock, method := w.lockMethod(call); lock != "" {
+		if method == "Unlock" || method == "RUnlock" {
+			w.deferred[lock] = true
+		}
+		return
+	}
+
+	// defer func() { ... }() releases all locks which are released in the
+	// function literal
+	if lit, ok := call.Fun.(*ast.FuncLit); ok {
+		ast.Inspect(lit.Body, func(n ast.Node) bool {
+			if c, ok := n.(*ast.CallExpr); ok {
+				w.deferCall(c)
+			}
+			return true
+		})
+		return
+	}
+
+	if callee := w.a.callee(w.fn.pkg, call); callee != nil {
+		for _, lock := range callee.releases {
+			w.deferred[lock] = true
+		}
+	}
+}
+
+// acquire adds the acquisition of a lock to the summary of the function and
+// records the edges from the held locks
+//  Args:
+//   held (state): locks held before the acquisition
+//   acq (acquisition): the acquisition
+//  Returns:
+//   (state): locks held after the acquisition
+func (w *walker) acquire(held state, acq acquisition) state {
+	if !containsAcquisition(w.fn.acquires, acq) {
+		w.fn.acquires = append(w.fn.acquires, acq)
+		w.changed = true
+	}
+	w.acquired[acq.lock] = true
+
+	// a second acquisition of the same lock can be another instance, e.g.
+	// of the same field in another struct, it is therefore not an edge
+	if held.holds(acq.lock) != -1 {
+		return held
+	}
+
+	if w.edges && len(held) != 0 {
+		w.a.addEdge(edge{
+			holding:  append([]acquisition{}, held...),
+			acquired: acq,
+		})
+	}
+
+	return append(append(state{}, held...), acq)
+}
+
+// release removes a lock from the held locks. If the lock is not held, it
+// was acquired by the caller and the release is added to the summary.
+//  Args:
+//   held (state): locks held before the release
+//   lock (string): key of the released lock
+//  Returns:
+//   (state): locks held after the release
+func (w *walker) release(held state, lock string) state {
+	i := held.holds(lock)
+	if i == -1 {
+		for _, l := range w.fn.releases {
+			if l == lock {
+				return held
+			}
+		}
+		w.fn.releases = append(w.fn.releases, lock)
+		w.changed = true
+		return held
+	}
+
+	res := append(state{}, held[:i]...)
+	return append(res, held[i+1:]...)
+}
+
+// isPanic checks if a call is a call of the builtin panic
+//  Args:
+//   call (*ast.CallExpr): the call
+//  Returns:
+//   (bool): true if the call is a call of panic
+func (w *walker) isPanic(call *ast.CallExpr) bool {
+	id, ok := unparen(call.Fun).(*ast.Ident)
+	if !ok {
+		return false
+	}
+	b, ok := w.fn.pkg.info.Uses[id].(*types.Builtin)
+	return ok && b.Name() == "panic"
+}
+
+// lockMethod checks if a call is a call of a method of a lock of Deadlock-Go
+//  Args:
+//   call (*ast.CallExpr): the call
+//  Returns:
+//   (string): key of the lock, empty if the call is no lock method or the
+//    lock can not be identified
+//   (string): name of the method
+func (w *walker) lockMethod(call *ast.CallExpr) (string, string) {
+	sel, ok := unparen(call.Fun).(*ast.SelectorExpr)
+	if !ok {
+		return "", ""
+	}
+	selection := w.fn.pkg.info.Selections[sel]
+	if selection == nil || selection.Kind() != types.MethodVal {
+		return "", ""
+	}
+	if lockType(selection.Obj().(*types.Func).Type().(*types.Signature).Recv().Type()) == "" {
+		return "", ""
+	}
+
+	// for locks embedded in structs, the path leads to the embedded lock
+	index := selection.Index()
+	return w.a.lockKey(w.fn.pkg, sel.X, index[:len(index)-1]), sel.Sel.Name
+}
+
+// calledFrom returns the acquisition as seen from a caller
+//  Args:
+//   function (string): name of the calling function
+//   pos (token.Position): position of the call
+//  Returns:
+//   (acquisition): the acquisition with the call added to its call chain
+func (a acquisition) calledFrom(function string, pos token.Position) acquisition {
+	stack := append([]frame{}, a.stack...)
+	a.stack = append(stack, frame{function: function, pos: pos})
+	return a
+}
+
+// pos returns the position where the lock was acquired
+//  Returns:
+//   (token.Position): the position
+func (a acquisition) pos() token.Position {
+	return a.stack[0].pos
+}
+
+// containsAcquisition checks if a list contains an acquisition of the same
+// lock in the same mode
+//  Args:
+//   list ([]acquisition): the list
+//   acq (acquisition): the acquisition
+//  Returns:
+//   (bool): true if list contains an acquisition of the lock in the same mode
+func containsAcquisition(list []acquisition, acq acquisition) bool {
+	for _, a := range list {
+		if a.lock == acq.lock && a.rLock == acq.rLock {
+			return true
+		}
+	}
+	return false
+}
+
+// unparen removes the parentheses around an expression
+//  Args:
+//   e (ast.Expr): the expression
+//  Returns:
+//   (ast.Expr): the expression without parentheses
+func unparen(e ast.Expr) ast.Expr {
+	for {
+		p, ok := e.(*ast.ParenExpr)
+		if !ok {
+			return e
+		}
+		e = p.X
+	}
+}
@@ -1,3 +1,5 @@
+//go:build !deadlock_off
+
 package deadlock
 
 /*
@@ -32,6 +34,7 @@ the lock and unlock operations for these locks.
 import (
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -42,43 +45,76 @@ type Mutex struct {
 	mu *sync.Mutex
 	// info about the creation and lock/unlock of this lock
 	context []callerInfo
-	// set to true after lock was initialized
-	in bool
+	// set to 1 after the lock was created, accessed atomically
+	created uint32
 	// numberLocked stores how often the mutex is currently locked
 	numberLocked int
-	// index of the routine, which holds the lock
-	isLockedRoutineIndex map[int]int
-	// lock to prevent multiple concurrent writes to isLockedRoutineIndex
-	isLockedRoutineIndexLock *sync.Mutex
+	// number of acquisitions of the lock by the id of the go routine, which
+	// holds the lock
+	isLockedRoutine map[int64]int
+	// lock to prevent concurrent access to numberLocked and isLockedRoutine
+	isLockedRoutineLock *sync.Mutex
 	// position of the mutex in memory
 	memoryPosition uintptr
+	// detector which created the lock
+	detector *Detector
 }
 
 // create and return a new lock, which can be used as a drop-in replacement for
-// sync.Mutex
+// sync.Mutex. The lock belongs to the default detector
 //  Returns:
 //   (*Mutex): the created lock
 func NewLock() *Mutex {
+	return defaultDetector.newLock()
+}
+
+// create and return a new lock of detector d, which can be used as a drop-in
+// replacement for sync.Mutex
+//  Returns:
+//   (*Mutex): the created lock
+func (d *Detector) NewLock() *Mutex {
+	return d.newLock()
+}
+
+// create a new lock of detector d. It must be called directly by the exported
+// functions, because it stores the position of their caller
+//  Returns:
+//   (*Mutex): the created lock
+func (d *Detector) newLock() *Mutex {
+	m := &Mutex{}
+
+	// the lock is created at the caller of the exported function
+	m.setup(d, 2)
+
+	return m
+}
+
+// initialize the lock as lock of detector d
+//  Args:
+//   d (*Detector): the detector of the lock
+//   skip (int): number of frames between the caller of setup and the position
+//    where the lock is created
+//  Returns:
+//   nil
+func (m *Mutex) setup(d *Detector, skip int) {
 	// initialize detector if necessary
-	if !initialized {
-		initialize()
-	}
+	d.initialize()
 
-	m := Mutex{
-		mu:                       &sync.Mutex{},
-		in:                       true,
-		isLockedRoutineIndex:     map[int]int{},
-		isLockedRoutineIndexLock: &sync.Mutex{},
-	}
+	m.mu = &sync.Mutex{}
+	m.detector = d
+	m.isLockedRoutine = map[int64]int{}
+	m.isLockedRoutineLock = &sync.Mutex{}
 
-	// save the position of the NewLock call
-	_, file, line, _ := runtime.Caller(1)
+	// save the position where the lock was created
+	_, file, line, _ := runtime.Caller(skip + 1)
 	m.context = append(m.context, newInfo(file, line, true, ""))
 
 	// save the memory position of the mutex
-	m.memoryPosition = uintptr(unsafe.Pointer(&m))
+	m.memoryPosition = uintptr(unsafe.Pointer(m))
+
+	d.traceEvent(TraceCreate, m, skip+1)
 
-	return &m
+	atomic.StoreUint32(&m.created, 1)
 }
 
 // ============ GETTER ============
@@ -90,18 +126,18 @@ func (m *Mutex) getNumberLocked() *int {
 	return &m.numberLocked
 }
 
-// getter for isLockedRoutineIndex
+// getter for isLockedRoutine
 //  Returns:
-//   (*int): isLockedRoutineIndex
-func (m *Mutex) getIsLockedRoutineIndex() *map[int]int {
-	return &m.isLockedRoutineIndex
+//   (*map[int64]int): isLockedRoutine
+func (m *Mutex) getIsLockedRoutine() *map[int64]int {
+	return &m.isLockedRoutine
 }
 
-// getter for isLockedRoutineIndexLock
+// getter for isLockedRoutineLock
 //  Returns:
-//   (*sync.RWMutex): isLockedRisLockedRoutineIndexLockoutineIndex
-func (m *Mutex) getIsLockedRoutineIndexLock() *sync.Mutex {
-	return m.isLockedRoutineIndexLock
+//   (*sync.Mutex): isLockedRoutineLock
+func (m *Mutex) getIsLockedRoutineLock() *sync.Mutex {
+	return m.isLockedRoutineLock
 }
 
 // getter for context
@@ -118,11 +154,18 @@ func (m *Mutex) getMemoryPosition() uintptr {
 	return m.memoryPosition
 }
 
-// getter for in
+// getter for created
 //  Returns:
-//   (bool): true if the lock was initialized, false otherwise
-func (m *Mutex) getIn() *bool {
-	return &m.in
+//   (*uint32): 1 if the lock was created, 0 otherwise
+func (m *Mutex) getCreated() *uint32 {
+	return &m.created
+}
+
+// getter for detector
+//  Returns:
+//   (*Detector): detector which created the lock
+func (m *Mutex) getDetector() *Detector {
+	return m.detector
 }
 
 // getter for mu
@@ -135,13 +178,13 @@ func (m *Mutex) getLock() (bool, *sync.Mutex, *sync.RWMutex) {
 }
 
 // empty getter, needed for MutexInt
-func (m *Mutex) getRLock(routineIndex int) bool {
+func (m *Mutex) getRLock(goID int64) bool {
 	return false
 }
 
 // empty setter, needed for mutexInt
 
-func (m *Mutex) setRLock(routineIndex int, value bool) {}
+func (m *Mutex) setRLock(goID int64, value bool) {}
 
 // ============ FUNCTIONS ============
 
@@ -165,7 +208,13 @@ func (m *Mutex) TryLock() bool {
 //  Returns:
 //   nil
 func (m *Mutex) Unlock() {
-	if opts.activated {
+	// the lock was already released with ReleaseLocks
+	if m.detector != nil && m.detector.ignoreUnlock(m) {
+		return
+	}
+
+	// the detector is nil if the lock was not used before
+	if m.detector == nil || m.detector.opts.activated {
 		// call the unlock method for the mutexInt interface
 		unlockInt(m)
 	}
@@ -1,9 +1,14 @@
+//go:build !deadlock_off
+
 package deadlock
 
 import (
 	"fmt"
 	"runtime"
 	"sync"
+	"sync/atomic"
+
+	"github.com/petermattis/goid"
 )
 
 /*
@@ -39,24 +44,51 @@ It also implements code which is used for both mutex and rw-mutex
 type mutexInt interface {
 	// getter for isLocked
 	getNumberLocked() *int
-	// getter for isLockedRoutineIndex
-	getIsLockedRoutineIndex() *map[int]int
-	// getter for isLockedRoutineIndexLock
-	getIsLockedRoutineIndexLock() *sync.Mutex
+	// getter for isLockedRoutine
+	getIsLockedRoutine() *map[int64]int
+	// getter for isLockedRoutineLock
+	getIsLockedRoutineLock() *sync.Mutex
 	// getter for context
 	getContext() *[]callerInfo
 	// getter for memoryPosition
 	getMemoryPosition() uintptr
-	// getter for in (initialized)
-	getIn() *bool
+	// getter for created
+	getCreated() *uint32
+	// initialize the lock as lock of a detector
+	setup(d *Detector, skip int)
+	// getter for the detector which created the lock
+	getDetector() *Detector
 	// getter for mu
 	// 	if bool is true, *sync.Mutex was returned, *sync.RWMutex is nil
 	// 	if bool is false, *sync.Mutex is nil, *sync.RWMutex ware returned
 	getLock() (bool, *sync.Mutex, *sync.RWMutex)
 	// get whether the lock was created by an rlock
-	getRLock(routineIndex int) bool
+	getRLock(goID int64) bool
 	// setter for rlock
-	setRLock(routineIndex int, value bool)
+	setRLock(goID int64, value bool)
+}
+
+// create a lock which was not created with NewLock or NewRWLock on its first
+// use. The lock belongs to the default detector and is created at the
+// position of its first use.
+//  Args:
+//   m (mutexInt): mutex or rw-mutex to create
+//   skip (int): number of frames between the caller of createLock and the
+//    first use
+//  Returns:
+//   nil
+func createLock(m mutexInt, skip int) {
+	if atomic.LoadUint32(m.getCreated()) == 1 {
+		return
+	}
+
+	d := defaultDetector
+	d.lazyCreateLock.Lock()
+	defer d.lazyCreateLock.Unlock()
+
+	if atomic.LoadUint32(m.getCreated()) == 0 {
+		m.setup(d, skip+1)
+	}
 }
 
 // lock the mutex or rw-mutex and update the detector data
@@ -66,75 +98,99 @@ type mutexInt interface {
 //  Returns:
 //   nil
 func lockInt(m mutexInt, rLock bool) {
+	// create the lock on its first use if it was not created with NewLock,
+	// e.g. a zero value in code rewritten by deadlock-toolexec
+	createLock(m, 2)
+
+	d := m.getDetector()
+
 	// do only the operation if detection is completely deactivated
-	if !opts.activated {
-		d, l, t := m.getLock()
-		if d {
-			// lock if m is mutex
-			l.Lock()
-		} else {
-			// lock if m is rw-mutex
-			if rLock {
-				t.RLock()
-			} else {
-				t.Lock()
-			}
-		}
+	if !d.opts.activated {
+		lockMutex(m, rLock)
 		return
 	}
 
-	// panic if the lock was not initialized
-	if !*m.getIn() {
-		errorMessage := fmt.Sprint("Lock ", &m, " was not created. Use ",
-			"x := NewLock().")
-		panic(errorMessage)
+	// let the fuzz input decide if the routine yields
+	d.schedulePoint()
+
+	detection := d.opts.periodicDetection || d.opts.comprehensiveDetection
+
+	var r *routine
+	if detection {
+		// create new routine, if not initialized
+		r = d.getRoutine()
+		if r == nil {
+			r = d.newRoutine()
+		}
+
+		// check if the locking would lead to double locking. This is done
+		// before the actual locking is deferred, so that a panic raised
+		// because of the policy for deadlocks does not block the routine
+		if d.opts.checkDoubleLocking {
+			r.checkDoubleLocking(m, rLock)
+		}
 	}
 
+	// position of the acquisition, used by the lock tree and the tracking of
+	// the waiting and holding routines
+	tracking := d.trackLockWaits()
+	var info, trackingInfo callerInfo
+	var goID int64
+	if detection || tracking {
+		info = d.newAcquisitionInfo(2)
+	}
+	if tracking {
+		trackingInfo = d.newTrackingInfo(info, 2)
+		goID = goid.Get()
+	}
+
+	// serialize the acquisition if it could complete a known deadlock
+	d.enterAvoidanceGates(m, rLock, trackingInfo)
+
 	// defer the actual locking
 	defer func() {
-		d, l, t := m.getLock()
-		if d {
-			// lock if m is mutex
-			l.Lock()
+		// if the routine leaves with a panic because of the policy while it
+		// waits for m, it leaves the gates it passed for m
+		acquired := false
+		if d.opts.deadlockAvoidance {
+			defer func() {
+				if !acquired {
+					d.leaveAvoidanceGates(m)
+				}
+			}()
+		}
+		d.acquire(m, rLock, goID, trackingInfo, 3)
+		acquired = true
+
+		addLocked(m)
+
+		// register the routine as holder of m
+		if tracking {
+			d.addLockHold(m, rLock, goID, trackingInfo)
+		}
+
+		if rLock {
+			d.traceEvent(TraceRLock, m, 3)
 		} else {
-			// lock if m is rw-mutex
-			if rLock {
-				t.RLock()
-			} else {
-				t.Lock()
-			}
+			d.traceEvent(TraceLock, m, 3)
 		}
 
-		*m.getNumberLocked() += 1
+		// delay the routine if the acquisition is part of a cycle which is
+		// currently being confirmed
+		d.perturbAcquisition(m, info)
 	}()
 
 	// return if detection is disabled
-	if !opts.periodicDetection && !opts.comprehensiveDetection {
+	if !detection {
 		return
 	}
 
-	// create new routine, if not initialized
-	index := getRoutineIndex()
-	if index == -1 {
-		newRoutine()
-	}
-	index = getRoutineIndex()
-
-	r := &routines[index]
-
-	// check if the locking would lead to double locking
-	if opts.checkDoubleLocking && *m.getNumberLocked() != 0 {
-		r.checkDoubleLocking(m, index, rLock)
-	}
-
-	m.getIsLockedRoutineIndexLock().Lock()
-	(*m.getIsLockedRoutineIndex())[index] += 1
-	m.getIsLockedRoutineIndexLock().Unlock()
+	addAcquisition(m, r.goID)
 
 	// update data structures if more than on routine is running
 	numRoutine := runtime.NumGoroutine()
 	if numRoutine > 1 {
-		(*r).updateLock(m, rLock)
+		(*r).updateLock(m, rLock, info)
 	}
 }
 
@@ -146,65 +202,57 @@ func lockInt(m mutexInt, rLock bool) {
 //  Returns:
 //   (bool): true if the acquisition was successful, false otherwise
 func tryLockInt(m mutexInt, rLock bool) bool {
-	// do only the operation if detection is completely deactivated
-	if !opts.activated {
-		d, l, t := m.getLock()
-		var res bool
-		if d {
-			// lock if m is mutex
-			res = l.TryLock()
-		} else {
-			// lock if m is rw-mutex
-			if rLock {
-				res = t.TryRLock()
-			} else {
-				res = t.TryLock()
-			}
-		}
-		return res
-	}
+	// create the lock on its first use if it was not created with NewLock,
+	// e.g. a zero value in code rewritten by deadlock-toolexec
+	createLock(m, 2)
 
-	// panic if the lock was not initialized
-	if !*m.getIn() {
-		errorMessage := fmt.Sprint("Lock ", &m, " was not created. Use ",
-			"x := NewLock()")
-		panic(errorMessage)
+	d := m.getDetector()
+
+	// do only the operation if detection is completely deactivated
+	if !d.opts.activated {
+		return tryLockMutex(m, rLock)
 	}
 
 	// try to lock mu
-	d, l, t := m.getLock()
-	var res bool
-	if d {
-		// lock if m is mutex
-		res = l.TryLock()
-	} else {
-		// lock if m is rw-mutex
-		if rLock {
-			res = t.TryRLock()
-		} else {
-			res = t.TryLock()
-		}
-	}
+	res := tryLockMutex(m, rLock)
 
 	// if locking was successful increase numberLocked
-	var index int
+	var r *routine
 	if res {
 		// initialize routine if necessary
-		index := getRoutineIndex()
-		if index == -1 {
+		r = d.getRoutine()
+		if r == nil {
 			// create new routine, if not initialized
-			newRoutine()
+			r = d.newRoutine()
 		}
-		index = getRoutineIndex()
 
-		*m.getNumberLocked() += 1
-		m.getIsLockedRoutineIndexLock().Lock()
-		(*m.getIsLockedRoutineIndex())[index] += 1
-		m.getIsLockedRoutineIndexLock().Unlock()
+		addLocked(m)
+		if r != nil {
+			addAcquisition(m, r.goID)
+		}
+
+		if rLock {
+			d.traceEvent(TraceTryRLock, m, 2)
+		} else {
+			d.traceEvent(TraceTryLock, m, 2)
+		}
+
+		// add the acquisition to the profile, it did not have to wait
+		d.profileAcquisition(m, false, 0, 2)
+
+		// register the routine as holder of m
+		if d.trackLockWaits() {
+			d.addLockHold(m, rLock, goid.Get(),
+				d.newTrackingInfo(d.newAcquisitionInfo(2), 2))
+		}
+	} else if rLock {
+		d.traceEvent(TraceTryRLockFailed, m, 2)
+	} else {
+		d.traceEvent(TraceTryLockFailed, m, 2)
 	}
 
 	// return if detection is disabled
-	if !opts.periodicDetection && !opts.comprehensiveDetection {
+	if !d.opts.periodicDetection && !d.opts.comprehensiveDetection {
 		return res
 	}
 
@@ -212,8 +260,7 @@ func tryLockInt(m mutexInt, rLock bool) bool {
 	// was successful
 	if runtime.NumGoroutine() > 1 {
 		if res {
-			r := &routines[index]
-			(*r).updateTryLock(m, rLock)
+			(*r).updateTryLock(m, rLock, d.newAcquisitionInfo(2))
 		}
 	}
 
@@ -226,36 +273,191 @@ func tryLockInt(m mutexInt, rLock bool) bool {
 //  Returns:
 //   nil
 func unlockInt(m mutexInt) {
-	// panic if the lock was not initialized
-	if !*m.getIn() {
-		errorMessage := fmt.Sprint("Lock ", &m, " was not created. Use ",
-			"x := NewLock()")
-		panic(errorMessage)
-	}
+	// create the lock on its first use if it was not created with NewLock,
+	// e.g. a zero value in code rewritten by deadlock-toolexec
+	createLock(m, 2)
 
 	// panic if lock was not locked
-	if *m.getNumberLocked() == 0 {
+	if !isLocked(m) {
 		errorMessage := fmt.Sprint("Tried to unLock lock ", &m,
 			" which was not locked.")
 		panic(errorMessage)
 	}
 
+	d := m.getDetector()
+
+	// let the fuzz input decide if the routine yields
+	d.schedulePoint()
+
+	d.traceEvent(TraceUnlock, m, 2)
+
+	// remove the routine as holder of m
+	d.removeLockHold(m)
+
+	// add the time m was held to the profile
+	d.profileRelease(m)
+
+	// leave the gates passed when m was acquired
+	d.leaveAvoidanceGates(m)
+
 	// defer the actual unlocking
 	defer func() {
-		// update numberLocked and isLockedRoutineIndex
-		*m.getNumberLocked() -= 1
-		m.getIsLockedRoutineIndexLock().Lock()
-		(*m.getIsLockedRoutineIndex())[getRoutineIndex()] -= 1
-		m.getIsLockedRoutineIndexLock().Unlock()
+		// update numberLocked and isLockedRoutine
+		removeAcquisition(m, goid.Get())
 	}()
 
 	// return if detection is disabled
-	if !opts.periodicDetection && !opts.comprehensiveDetection {
+	if !d.opts.periodicDetection && !d.opts.comprehensiveDetection {
 		return
 	}
 
-	// update data structures if more than on routine is running
-	index := getRoutineIndex()
-	r := &routines[index]
+	// update data structures if more than on routine is running. The routine
+	// is unknown if it did not hold a lock when the routines were reset
+	r := d.getRoutine()
+	if r == nil {
+		return
+	}
 	(*r).updateUnlock(m)
 }
+
+// register that a go routine acquires m. This is done before the routine
+// starts to wait for m, so that the routine counts as holder of m from then
+// on.
+//  Args:
+//   m (mutexInt): the lock
+//   goID (int64): id of the go routine
+//  Returns:
+//   nil
+func addAcquisition(m mutexInt, goID int64) {
+	m.getIsLockedRoutineLock().Lock()
+	(*m.getIsLockedRoutine())[goID] += 1
+	m.getIsLockedRoutineLock().Unlock()
+}
+
+// increase the number of times m is currently locked, after m was acquired
+//  Args:
+//   m (mutexInt): the lock
+//  Returns:
+//   nil
+func addLocked(m mutexInt) {
+	m.getIsLockedRoutineLock().Lock()
+	*m.getNumberLocked() += 1
+	m.getIsLockedRoutineLock().Unlock()
+}
+
+// register that a go routine releases m. Because a lock can be released by
+// another routine than the one which acquired it, an other holder is
+// removed if the go routine does not hold m.
+//  Args:
+//   m (mutexInt): the lock
+//   goID (int64): id of the go routine
+//  Returns:
+//   nil
+func removeAcquisition(m mutexInt, goID int64) {
+	m.getIsLockedRoutineLock().Lock()
+	defer m.getIsLockedRoutineLock().Unlock()
+
+	if *m.getNumberLocked() > 0 {
+		*m.getNumberLocked() -= 1
+	}
+
+	holders := *m.getIsLockedRoutine()
+	if holders[goID] == 0 {
+		for id := range holders {
+			goID = id
+			break
+		}
+	}
+
+	// remove the entries of routines which do not hold m anymore, so that
+	// the map does not grow with the number of routines
+	if holders[goID] <= 1 {
+		delete(holders, goID)
+	} else {
+		holders[goID] -= 1
+	}
+}
+
+// check if m is currently locked
+//  Args:
+//   m (mutexInt): the lock
+//  Returns:
+//   (bool): true if m is locked, false otherwise
+func isLocked(m mutexInt) bool {
+	m.getIsLockedRoutineLock().Lock()
+	defer m.getIsLockedRoutineLock().Unlock()
+	return *m.getNumberLocked() != 0
+}
+
+// check if m is currently locked and held by a go routine
+//  Args:
+//   m (mutexInt): the lock
+//   goID (int64): id of the go routine
+//  Returns:
+//   (bool): true if m is locked and goID holds m, false otherwise
+func isLockedBy(m mutexInt, goID int64) bool {
+	m.getIsLockedRoutineLock().Lock()
+	defer m.getIsLockedRoutineLock().Unlock()
+	return *m.getNumberLocked() != 0 && (*m.getIsLockedRoutine())[goID] != 0
+}
+
+// acquire the underlying lock of a mutex or rw-mutex
+//  Args:
+//   m (mutexInt): mutex or rw-mutex to lock
+//   rLock (bool): if set to true, the lock is acquired as reader lock
+//  Returns:
+//   nil
+func lockMutex(m mutexInt, rLock bool) {
+	isMutex, l, t := m.getLock()
+	if isMutex {
+		// lock if m is mutex
+		l.Lock()
+	} else {
+		// lock if m is rw-mutex
+		if rLock {
+			t.RLock()
+		} else {
+			t.Lock()
+		}
+	}
+}
+
+// try to acquire the underlying lock of a mutex or rw-mutex
+//  Args:
+//   m (mutexInt): mutex or rw-mutex to lock
+//   rLock (bool): if set to true, the lock is acquired as reader lock
+//  Returns:
+//   (bool): true if the acquisition was successful, false otherwise
+func tryLockMutex(m mutexInt, rLock bool) bool {
+	isMutex, l, t := m.getLock()
+	if isMutex {
+		// lock if m is mutex
+		return l.TryLock()
+	}
+	// lock if m is rw-mutex
+	if rLock {
+		return t.TryRLock()
+	}
+	return t.TryLock()
+}
+
+// release the underlying lock of a mutex or rw-mutex
+//  Args:
+//   m (mutexInt): mutex or rw-mutex to unlock
+//   rLock (bool): if set to true, the lock is released as reader lock
+//  Returns:
+//   nil
+func unlockMutex(m mutexInt, rLock bool) {
+	isMutex, l, t := m.getLock()
+	if isMutex {
+		// unlock if m is mutex
+		l.Unlock()
+	} else {
+		// unlock if m is rw-mutex
+		if rLock {
+			t.RUnlock()
+		} else {
+			t.Unlock()
+		}
+	}
+}
@@ -0,0 +1,163 @@
+//go:build deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+mutexOff.go
+This file implements the locks for the build with the tag deadlock_off. The
+locks are thin wrappers around the locks of the sync package without any
+detection, so that release builds can use the same import as development
+builds without any cost of the detector.
+*/
+
+import (
+	"sync"
+)
+
+// Type to implement a lock without detection
+// It can be used as an drop in replacement
+type Mutex struct {
+	// mutex for the actual locking
+	mu sync.Mutex
+}
+
+// type to implement a rw-lock without detection
+type RWMutex struct {
+	// rw-mutex for the actual locking
+	mu sync.RWMutex
+}
+
+// ====== CONSTRUCTOR ==========================================================
+
+// create and return a new lock, which can be used as a drop-in replacement for
+// sync.Mutex
+//  Returns:
+//   (*Mutex): the created lock
+func NewLock() *Mutex {
+	return &Mutex{}
+}
+
+// create and return a new lock, which can be used as a drop-in replacement for
+// sync.Mutex. The detector is ignored
+//  Returns:
+//   (*Mutex): the created lock
+func (d *Detector) NewLock() *Mutex {
+	return &Mutex{}
+}
+
+// create a new rw-lock
+//  Returns:
+//   (*RWMutex): the created lock
+func NewRWLock() *RWMutex {
+	return &RWMutex{}
+}
+
+// create a new rw-lock, the detector is ignored
+//  Returns:
+//   (*RWMutex): the created lock
+func (d *Detector) NewRWLock() *RWMutex {
+	return &RWMutex{}
+}
+
+// ====== FUNCTIONS ============================================================
+
+// Lock mutex m
+//  Returns:
+//   nil
+func (m *Mutex) Lock() {
+	m.mu.Lock()
+}
+
+// TryLock mutex m
+//  Returns:
+//   (bool): true if locking was successful, false otherwise
+func (m *Mutex) TryLock() bool {
+	return m.mu.TryLock()
+}
+
+// Unlock mutex m
+//  Returns:
+//   nil
+func (m *Mutex) Unlock() {
+	m.mu.Unlock()
+}
+
+// Lock rw-mutex m
+//  Returns:
+//   nil
+func (m *RWMutex) Lock() {
+	m.mu.Lock()
+}
+
+// RLock rw-mutex m
+//  Returns:
+//   nil
+func (m *RWMutex) RLock() {
+	m.mu.RLock()
+}
+
+// TryLock rw-mutex m
+//  Returns:
+//   (bool): true if locking was successful, false otherwise
+func (m *RWMutex) TryLock() bool {
+	return m.mu.TryLock()
+}
+
+// RTryLock rw-mutex m
+//  Returns:
+//   (bool): true if locking was successful, false otherwise
+func (m *RWMutex) RTryLock() bool {
+	return m.mu.TryRLock()
+}
+
+// TryRLock rw-mutex m, same as RTryLock with the name used by sync.RWMutex
+//  Returns:
+//   (bool): true if locking was successful, false otherwise
+func (m *RWMutex) TryRLock() bool {
+	return m.mu.TryRLock()
+}
+
+// Unlock rw-mutex m
+//  Returns:
+//   nil
+func (m *RWMutex) Unlock() {
+	m.mu.Unlock()
+}
+
+// RUnlock rw-mutex m
+//  Returns:
+//   nil
+func (m *RWMutex) RUnlock() {
+	m.mu.RUnlock()
+}
+
+// RLocker returns a sync.Locker, which locks and unlocks m with RLock and
+// RUnlock, like RLocker of sync.RWMutex
+//  Returns:
+//   (sync.Locker): the locker
+func (m *RWMutex) RLocker() sync.Locker {
+	return m.mu.RLocker()
+}
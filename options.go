@@ -1,3 +1,5 @@
+//go:build !deadlock_off
+
 package deadlock
 
 /*
@@ -30,10 +32,13 @@ enabling or disabling of the periodical and/or comprehensive detection as
 well as the periodical detection time and max values for the detection.
 */
 
-import "time"
+import (
+	"io"
+	"time"
+)
 
-// opts controls how the detection behaves
-var opts = struct {
+// options controls how the detection of a detector behaves
+type options struct {
 	// if deactivated is false, there is no detection
 	activated bool
 	// If periodicDetection is set to false, periodic detection is disabled
@@ -41,15 +46,13 @@ var opts = struct {
 	// If comprehensiveDetection is set to false, comprehensive detection at
 	// the end of the program is disabled
 	comprehensiveDetection bool
-	// Set how often the periodic detection is run
-	periodicDetectionTime time.Duration
+	// time a routine has to wait for a lock before the wait-for graph is
+	// checked again
+	periodicDetectionThreshold time.Duration
 	// If collectCallStack is true, the CallStack for lock creation and
 	// acquisition are collected and displayed. Otherwise only file names and
 	// lines are collected
 	collectCallStack bool
-	// If collectSingleLevelLockStack is set to true, stack traces for single
-	// level locks are collected. Otherwise not.
-	collectSingleLevelLockStack bool
 	// If checkDoubleLocking is set to true, the detector checks for double
 	// locking
 	checkDoubleLocking bool
@@ -61,18 +64,61 @@ var opts = struct {
 	maxRoutines int
 	// The maximum byte size for callStacks
 	maxCallStackSize int
-}{
-	activated:                   true,
-	periodicDetection:           true,
-	comprehensiveDetection:      true,
-	periodicDetectionTime:       time.Second * 2,
-	collectCallStack:            false,
-	collectSingleLevelLockStack: true,
-	checkDoubleLocking:          true,
-	maxDependencies:             4096,
-	maxNumberOfDependentLocks:   128,
-	maxRoutines:                 1024,
-	maxCallStackSize:            2048,
+	// If jsonOutput is set, all reports are written to it in JSON format
+	jsonOutput io.Writer
+	// If jsonOutputFile is set, all reports are written to this file in JSON
+	// format
+	jsonOutputFile string
+	// If sarifOutputFile is set, all reports are written to this file in the
+	// SARIF format
+	sarifOutputFile string
+	// If htmlOutputFile is set, all reports are written to this file as
+	// HTML page
+	htmlOutputFile string
+	// time a routine can wait for a lock before it is reported, 0 to disable
+	lockWaitTimeout time.Duration
+	// time after which RunSchedule stops to wait for the body
+	scheduleTimeout time.Duration
+	// If deadlockAvoidance is set to true, known deadlocks are avoided
+	deadlockAvoidance bool
+	// If historyFile is set, the signatures of all found deadlocks are stored
+	// in this file and loaded at the start
+	historyFile string
+	// If suppressKnownDeadlocks is set to true, potential deadlocks which are
+	// already in the history are not reported
+	suppressKnownDeadlocks bool
+	// If traceFile is set, all lock events are written to this file
+	traceFile string
+	// If profiling is set to true, the wait and hold times of all
+	// acquisitions are collected
+	profiling bool
+	// deadlockPolicy sets how the detector reacts to a detected deadlock
+	deadlockPolicy Policy
+	// exit code if the program is terminated because of a detected deadlock
+	exitCode int
+	// function which is called for a detected deadlock with PolicyCallback
+	deadlockCallback func(*DeadlockError)
+}
+
+// defaultOptions returns the default options of a detector
+//  Returns:
+//   (options): the options
+func defaultOptions() options {
+	return options{
+		activated:                  true,
+		periodicDetection:          true,
+		comprehensiveDetection:     true,
+		periodicDetectionThreshold: time.Millisecond * 100,
+		collectCallStack:           false,
+		checkDoubleLocking:         true,
+		maxDependencies:            4096,
+		maxNumberOfDependentLocks:  128,
+		maxRoutines:                1024,
+		maxCallStackSize:           2048,
+		deadlockPolicy:             PolicyExit,
+		exitCode:                   2,
+		scheduleTimeout:            time.Second * 10,
+	}
 }
 
 // Enable or disable all detections
@@ -81,61 +127,118 @@ var opts = struct {
 //   enable (bool): true to enable, false to disable
 //  Returns:
 //   (bool): true, if the set was successful, false otherwise
-func SetActivated(enable bool) bool {
-	if initialized {
+func (d *Detector) SetActivated(enable bool) bool {
+	if d.initialized {
 		return false
 	}
-	opts.activated = enable
-	opts.checkDoubleLocking = true
-	opts.periodicDetection = true
-	opts.comprehensiveDetection = true
+	d.opts.activated = enable
+	d.opts.checkDoubleLocking = true
+	d.opts.periodicDetection = true
+	d.opts.comprehensiveDetection = true
 	return true
 }
 
+// SetActivated sets the option of the default detector,
+// see Detector.SetActivated
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetActivated(enable bool) bool {
+	return defaultDetector.SetActivated(enable)
+}
+
 // Enable or disable periodic detection
 // It is not possible to set options after the detector was initialized
 //  Args:
 //   enable (bool): true to enable, false to disable
 //  Returns:
 //   (bool): true, if the set was successful, false otherwise
-func SetPeriodicDetection(enable bool) bool {
-	if initialized {
+func (d *Detector) SetPeriodicDetection(enable bool) bool {
+	if d.initialized {
 		return false
 	}
-	opts.periodicDetection = enable
-	setActivatedAuto()
+	d.opts.periodicDetection = enable
+	d.setActivatedAuto()
 	return true
 }
 
+// SetPeriodicDetection sets the option of the default detector,
+// see Detector.SetPeriodicDetection
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetPeriodicDetection(enable bool) bool {
+	return defaultDetector.SetPeriodicDetection(enable)
+}
+
 // Enable or disable comprehensive detection
 // It is not possible to set options after the detector was initialized
 //  Args:
 //   enable (bool): true to enable, false to disable
 //  Returns:
 //   (bool): true, if the set was successful, false otherwise
-func SetComprehensiveDetection(enable bool) bool {
-	if initialized {
+func (d *Detector) SetComprehensiveDetection(enable bool) bool {
+	if d.initialized {
 		return false
 	}
-	opts.comprehensiveDetection = enable
-	setActivatedAuto()
+	d.opts.comprehensiveDetection = enable
+	d.setActivatedAuto()
 	return true
 }
 
+// SetComprehensiveDetection sets the option of the default detector,
+// see Detector.SetComprehensiveDetection
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetComprehensiveDetection(enable bool) bool {
+	return defaultDetector.SetComprehensiveDetection(enable)
+}
+
 // Set the temporal distance between the periodic detections
 // It is not possible to set options after the detector was initialized
+//
+// Deprecated: The periodical detection is no longer run in fixed intervals,
+// but every time a routine has to wait for a lock. Use
+// SetPeriodicDetectionThreshold instead. The option has no effect, the
+// interval is ignored.
 //  Args:
 //   seconds (int): temporal distance in seconds
 //  Returns:
 //   (bool): true, if the set was successful, false otherwise
+func (d *Detector) SetPeriodicDetectionTime(seconds int) bool {
+	return !d.initialized
+}
+
+// SetPeriodicDetectionTime sets the option of the default detector,
+// see Detector.SetPeriodicDetectionTime
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
 func SetPeriodicDetectionTime(seconds int) bool {
-	if initialized {
+	return defaultDetector.SetPeriodicDetectionTime(seconds)
+}
+
+// Set the time a routine has to wait for a lock, before the periodical
+// detection checks the wait-for graph again. The graph is always checked when
+// a routine starts to wait.
+// It is not possible to set options after the detector was initialized
+//  Args:
+//   threshold (time.Duration): time a routine has to wait
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func (d *Detector) SetPeriodicDetectionThreshold(threshold time.Duration) bool {
+	if d.initialized || threshold <= 0 {
 		return false
 	}
-	opts.periodicDetectionTime = time.Second * time.Duration(seconds)
+	d.opts.periodicDetectionThreshold = threshold
 	return true
 }
 
+// SetPeriodicDetectionThreshold sets the option of the default detector,
+// see Detector.SetPeriodicDetectionThreshold
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetPeriodicDetectionThreshold(threshold time.Duration) bool {
+	return defaultDetector.SetPeriodicDetectionThreshold(threshold)
+}
+
 // Enable or disable collection of full call stacks
 // If it is disabled only file and line numbers are collected
 // It is not possible to set options after the detector was initialized
@@ -143,27 +246,42 @@ func SetPeriodicDetectionTime(seconds int) bool {
 //   enable (bool): true to enable, false to disable
 //  Returns:
 //   (bool): true, if the set was successful, false otherwise
-func SetCollectCallStack(enable bool) bool {
-	if initialized {
+func (d *Detector) SetCollectCallStack(enable bool) bool {
+	if d.initialized {
 		return false
 	}
-	opts.collectCallStack = enable
+	d.opts.collectCallStack = enable
 	return true
 }
 
+// SetCollectCallStack sets the option of the default detector,
+// see Detector.SetCollectCallStack
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetCollectCallStack(enable bool) bool {
+	return defaultDetector.SetCollectCallStack(enable)
+}
+
 // Enable or disable collection of call information for single level locks
-// If it is disabled no caller information about single level locks will be collected.
 // It is not possible to set options after the detector was initialized
+//
+// Deprecated: The positions of the acquisitions are stored with the
+// dependencies they belong to and are no longer collected for single level
+// locks. The option has no effect.
 //  Args:
 //   enable (bool): true to enable, false to disable
 //  Returns:
 //   (bool): true, if the set was successful, false otherwise
+func (d *Detector) SetCollectSingleLevelLockInformation(enable bool) bool {
+	return !d.initialized
+}
+
+// SetCollectSingleLevelLockInformation sets the option of the default detector,
+// see Detector.SetCollectSingleLevelLockInformation
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
 func SetCollectSingleLevelLockInformation(enable bool) bool {
-	if initialized {
-		return false
-	}
-	opts.collectSingleLevelLockStack = enable
-	return true
+	return defaultDetector.SetCollectSingleLevelLockInformation(enable)
 }
 
 // Enable or disable checks for double locking
@@ -172,79 +290,454 @@ func SetCollectSingleLevelLockInformation(enable bool) bool {
 //   enable (bool): true to enable, false to disable
 //  Returns:
 //   (bool): true, if the set was successful, false otherwise
-func SetDoubleLockingDetection(enable bool) bool {
-	if initialized {
+func (d *Detector) SetDoubleLockingDetection(enable bool) bool {
+	if d.initialized {
 		return false
 	}
-	opts.checkDoubleLocking = enable
-	setActivatedAuto()
+	d.opts.checkDoubleLocking = enable
+	d.setActivatedAuto()
 	return true
 }
 
+// SetDoubleLockingDetection sets the option of the default detector,
+// see Detector.SetDoubleLockingDetection
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetDoubleLockingDetection(enable bool) bool {
+	return defaultDetector.SetDoubleLockingDetection(enable)
+}
+
 // Set the max number of dependencies
 // It is not possible to set options after the detector was initialized
 //  Args:
 //   number (int): max number of dependencies
 //  Returns:
 //   (bool): true, if the set was successful, false otherwise
-func SetMaxDependencies(number int) bool {
-	if initialized {
+func (d *Detector) SetMaxDependencies(number int) bool {
+	if d.initialized {
 		return false
 	}
-	opts.maxDependencies = number
+	d.opts.maxDependencies = number
 	return true
 }
 
+// SetMaxDependencies sets the option of the default detector,
+// see Detector.SetMaxDependencies
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetMaxDependencies(number int) bool {
+	return defaultDetector.SetMaxDependencies(number)
+}
+
 // Set the max number of locks a lock can depend on
 // It is not possible to set options after the detector was initialized
 //  Args:
 //   number (int): max number of locks a lock can depend on
 //  Returns:
 //   (bool): true, if the set was successful, false otherwise
-func SetMaxNumberOfDependentLocks(number int) bool {
-	if initialized {
+func (d *Detector) SetMaxNumberOfDependentLocks(number int) bool {
+	if d.initialized {
 		return false
 	}
-	opts.maxNumberOfDependentLocks = number
+	d.opts.maxNumberOfDependentLocks = number
 	return true
 }
 
+// SetMaxNumberOfDependentLocks sets the option of the default detector,
+// see Detector.SetMaxNumberOfDependentLocks
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetMaxNumberOfDependentLocks(number int) bool {
+	return defaultDetector.SetMaxNumberOfDependentLocks(number)
+}
+
 // Set the max number of routines
 // It is not possible to set options after the detector was initialized
 //  Args:
 //   number (int): max number of routines
 //  Returns:
 //   (bool): true, if the set was successful, false otherwise
-func SetMaxRoutines(number int) bool {
-	if initialized {
+func (d *Detector) SetMaxRoutines(number int) bool {
+	if d.initialized {
 		return false
 	}
-	opts.maxRoutines = number
+	d.opts.maxRoutines = number
 	return true
 }
 
+// SetMaxRoutines sets the option of the default detector,
+// see Detector.SetMaxRoutines
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetMaxRoutines(number int) bool {
+	return defaultDetector.SetMaxRoutines(number)
+}
+
 // Set the max size of collected call stacks
 // It is not possible to set options after the detector was initialized
 //  Args:
 //   number (int): max size of the call stack in bytes
 //  Returns:
 //   (bool): true, if the set was successful, false otherwise
+func (d *Detector) SetMaxCallStackSize(number int) bool {
+	if d.initialized {
+		return false
+	}
+	d.opts.maxCallStackSize = number
+	return true
+}
+
+// SetMaxCallStackSize sets the option of the default detector,
+// see Detector.SetMaxCallStackSize
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
 func SetMaxCallStackSize(number int) bool {
-	if initialized {
+	return defaultDetector.SetMaxCallStackSize(number)
+}
+
+// Set a writer to which all reports are written in JSON format.
+// Every report is written as one JSON object per line.
+// It is not possible to set options after the detector was initialized
+//  Args:
+//   w (io.Writer): writer for the reports, nil to disable the JSON output
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func (d *Detector) SetJSONOutput(w io.Writer) bool {
+	if d.initialized {
+		return false
+	}
+	d.opts.jsonOutput = w
+	return true
+}
+
+// SetJSONOutput sets the option of the default detector,
+// see Detector.SetJSONOutput
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetJSONOutput(w io.Writer) bool {
+	return defaultDetector.SetJSONOutput(w)
+}
+
+// Set a file to which all reports are written in JSON format.
+// Every report is written as one JSON object per line. If path is "-", the
+// reports are written to stderr.
+// The file can also be set with the environment variable DEADLOCK_GO_JSON.
+// It is not possible to set options after the detector was initialized
+//  Args:
+//   path (string): path of the file, "" to disable the JSON output
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func (d *Detector) SetJSONOutputFile(path string) bool {
+	if d.initialized {
 		return false
 	}
-	opts.maxCallStackSize = number
+	d.opts.jsonOutputFile = path
 	return true
 }
 
+// SetJSONOutputFile sets the option of the default detector,
+// see Detector.SetJSONOutputFile
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetJSONOutputFile(path string) bool {
+	return defaultDetector.SetJSONOutputFile(path)
+}
+
+// Set a file to which all reports are written in the SARIF 2.1.0 format.
+// The file is written at the end of the comprehensive detection.
+// If path is "-", the reports are written to stderr.
+// The file can also be set with the environment variable DEADLOCK_GO_SARIF.
+// It is not possible to set options after the detector was initialized
+//  Args:
+//   path (string): path of the file, "" to disable the SARIF output
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func (d *Detector) SetSARIFOutputFile(path string) bool {
+	if d.initialized {
+		return false
+	}
+	d.opts.sarifOutputFile = path
+	return true
+}
+
+// SetSARIFOutputFile sets the option of the default detector,
+// see Detector.SetSARIFOutputFile
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetSARIFOutputFile(path string) bool {
+	return defaultDetector.SetSARIFOutputFile(path)
+}
+
+// Set a file to which all reports are written as a self-contained HTML page.
+// The file is written at the end of the comprehensive detection.
+// The file can also be set with the environment variable DEADLOCK_GO_HTML.
+// It is not possible to set options after the detector was initialized
+//  Args:
+//   path (string): path of the file, "" to disable the HTML output
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func (d *Detector) SetHTMLOutputFile(path string) bool {
+	if d.initialized {
+		return false
+	}
+	d.opts.htmlOutputFile = path
+	return true
+}
+
+// SetHTMLOutputFile sets the option of the default detector,
+// see Detector.SetHTMLOutputFile
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetHTMLOutputFile(path string) bool {
+	return defaultDetector.SetHTMLOutputFile(path)
+}
+
+// Set the time a routine can wait for a lock before it is reported together
+// with the routines holding the lock. A timeout of 0 disables the watchdog.
+// It is not possible to set options after the detector was initialized
+//  Args:
+//   timeout (time.Duration): time a routine can wait for a lock
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func (d *Detector) SetLockWaitTimeout(timeout time.Duration) bool {
+	if d.initialized || timeout < 0 {
+		return false
+	}
+	d.opts.lockWaitTimeout = timeout
+	return true
+}
+
+// SetLockWaitTimeout sets the option of the default detector,
+// see Detector.SetLockWaitTimeout
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetLockWaitTimeout(timeout time.Duration) bool {
+	return defaultDetector.SetLockWaitTimeout(timeout)
+}
+
+// Set the time after which RunSchedule stops to wait for the body and
+// returns a report of the kind KindScheduleTimeout, e.g. if the body is
+// blocked in a deadlock which is not detected, like a deadlock of a channel
+// and a lock.
+// It is not possible to set options after the detector was initialized
+//  Args:
+//   timeout (time.Duration): time RunSchedule waits for the body
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func (d *Detector) SetScheduleTimeout(timeout time.Duration) bool {
+	if d.initialized || timeout <= 0 {
+		return false
+	}
+	d.opts.scheduleTimeout = timeout
+	return true
+}
+
+// SetScheduleTimeout sets the option of the default detector,
+// see Detector.SetScheduleTimeout
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetScheduleTimeout(timeout time.Duration) bool {
+	return defaultDetector.SetScheduleTimeout(timeout)
+}
+
+// Enable or disable the avoidance of known deadlocks. If enabled,
+// acquisitions which could complete the cycle of a known deadlock are
+// serialized, so that the deadlock can not occur. Deadlocks are known if they
+// were found in the current run or loaded with LoadAvoidanceSignatures.
+// It is not possible to set options after the detector was initialized
+//  Args:
+//   enable (bool): true to enable, false to disable
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func (d *Detector) SetDeadlockAvoidance(enable bool) bool {
+	if d.initialized {
+		return false
+	}
+	d.opts.deadlockAvoidance = enable
+	return true
+}
+
+// SetDeadlockAvoidance sets the option of the default detector,
+// see Detector.SetDeadlockAvoidance
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetDeadlockAvoidance(enable bool) bool {
+	return defaultDetector.SetDeadlockAvoidance(enable)
+}
+
+// Set the path of the history file. The signatures of all found potential
+// and actual deadlocks are stored in this file and loaded when the detector
+// is initialized, so that deadlocks found in earlier runs are known.
+// It is not possible to set options after the detector was initialized
+//  Args:
+//   path (string): path of the history file
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func (d *Detector) SetHistoryFile(path string) bool {
+	if d.initialized {
+		return false
+	}
+	d.opts.historyFile = path
+	return true
+}
+
+// SetHistoryFile sets the option of the default detector,
+// see Detector.SetHistoryFile
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetHistoryFile(path string) bool {
+	return defaultDetector.SetHistoryFile(path)
+}
+
+// Enable or disable the suppression of potential deadlocks which are already
+// in the history. Actual deadlocks are always reported.
+// It is not possible to set options after the detector was initialized
+//  Args:
+//   enable (bool): true to enable, false to disable
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func (d *Detector) SetSuppressKnownDeadlocks(enable bool) bool {
+	if d.initialized {
+		return false
+	}
+	d.opts.suppressKnownDeadlocks = enable
+	return true
+}
+
+// SetSuppressKnownDeadlocks sets the option of the default detector,
+// see Detector.SetSuppressKnownDeadlocks
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetSuppressKnownDeadlocks(enable bool) bool {
+	return defaultDetector.SetSuppressKnownDeadlocks(enable)
+}
+
+// Set the path of the trace file. If set, every creation, acquisition and
+// release of a lock is written into this file.
+// It is not possible to set options after the detector was initialized
+//  Args:
+//   path (string): path of the trace file
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func (d *Detector) SetTraceFile(path string) bool {
+	if d.initialized {
+		return false
+	}
+	d.opts.traceFile = path
+	return true
+}
+
+// SetTraceFile sets the option of the default detector,
+// see Detector.SetTraceFile
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetTraceFile(path string) bool {
+	return defaultDetector.SetTraceFile(path)
+}
+
+// Enable or disable the profiling of the contention and the hold time of
+// the locks. If enabled, the time a routine waits for a lock and the time the
+// lock is held are measured for every acquisition.
+// It is not possible to set options after the detector was initialized
+//  Args:
+//   enable (bool): true to enable, false to disable
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func (d *Detector) SetProfiling(enable bool) bool {
+	if d.initialized {
+		return false
+	}
+	d.opts.profiling = enable
+	return true
+}
+
+// SetProfiling sets the option of the default detector,
+// see Detector.SetProfiling
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetProfiling(enable bool) bool {
+	return defaultDetector.SetProfiling(enable)
+}
+
+// Set how the detector reacts to a detected deadlock, meaning double locking
+// or a local deadlock found by the periodical detection.
+// It is not possible to set options after the detector was initialized
+//  Args:
+//   policy (Policy): PolicyExit, PolicyPanic, PolicyCallback or PolicyContinue
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func (d *Detector) SetDeadlockPolicy(policy Policy) bool {
+	if d.initialized {
+		return false
+	}
+	d.opts.deadlockPolicy = policy
+	return true
+}
+
+// SetDeadlockPolicy sets the option of the default detector,
+// see Detector.SetDeadlockPolicy
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetDeadlockPolicy(policy Policy) bool {
+	return defaultDetector.SetDeadlockPolicy(policy)
+}
+
+// Set the exit code which is used if the program is terminated because of
+// a detected deadlock with PolicyExit
+// It is not possible to set options after the detector was initialized
+//  Args:
+//   code (int): exit code
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func (d *Detector) SetExitCode(code int) bool {
+	if d.initialized {
+		return false
+	}
+	d.opts.exitCode = code
+	return true
+}
+
+// SetExitCode sets the option of the default detector,
+// see Detector.SetExitCode
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetExitCode(code int) bool {
+	return defaultDetector.SetExitCode(code)
+}
+
+// Set the function which is called for a detected deadlock with
+// PolicyCallback. The function is called in the routine which tried to
+// acquire the lock for double locking and in the routine of the periodical
+// detection for local deadlocks.
+// It is not possible to set options after the detector was initialized
+//  Args:
+//   callback (func(*DeadlockError)): function to call
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func (d *Detector) SetDeadlockCallback(callback func(*DeadlockError)) bool {
+	if d.initialized {
+		return false
+	}
+	d.opts.deadlockCallback = callback
+	return true
+}
+
+// SetDeadlockCallback sets the option of the default detector,
+// see Detector.SetDeadlockCallback
+//  Returns:
+//   (bool): true, if the set was successful, false otherwise
+func SetDeadlockCallback(callback func(*DeadlockError)) bool {
+	return defaultDetector.SetDeadlockCallback(callback)
+}
+
 // automatically set activated according to the other options
 //  Returns:
 //   nil
-func setActivatedAuto() {
-	if !(opts.periodicDetection || opts.checkDoubleLocking || opts.comprehensiveDetection) {
-		opts.activated = false
+func (d *Detector) setActivatedAuto() {
+	if !(d.opts.periodicDetection || d.opts.checkDoubleLocking || d.opts.comprehensiveDetection) {
+		d.opts.activated = false
 		return
 	}
-	opts.activated = true
+	d.opts.activated = true
 
 }
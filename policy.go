@@ -0,0 +1,115 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+policy.go
+This file implements the reaction of the detector to a detected deadlock,
+meaning a local deadlock found by the periodical detection or double locking.
+Depending on the policy set in the options, the program is terminated, a
+panic is raised, a callback is called or the program just continues.
+*/
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// InterceptDeadlocks replaces the policy for deadlocks until stop is called.
+// Double locking and local deadlocks are still reported, but instead of
+// reacting according to the policy, handler is called. This is mainly used
+// in tests, which should fail instead of being terminated. In contrast to
+// the options, the interceptor can be set after the detector was
+// initialized. Interceptors can be nested, stop restores the previous one.
+//  Args:
+//   handler (func(*DeadlockError)): function to call for detected deadlocks,
+//    called in the routine which detected the deadlock
+//  Returns:
+//   (func()): function to remove the interceptor
+func (d *Detector) InterceptDeadlocks(handler func(*DeadlockError)) (stop func()) {
+	d.interceptorLock.Lock()
+	previous := d.interceptor
+	d.interceptor = handler
+	d.interceptorLock.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.interceptorLock.Lock()
+			d.interceptor = previous
+			d.interceptorLock.Unlock()
+		})
+	}
+}
+
+// InterceptDeadlocks calls Detector.InterceptDeadlocks of the default detector
+//  Args:
+//   handler (func(*DeadlockError)): function to call for detected deadlocks,
+//    called in the routine which detected the deadlock
+//  Returns:
+//   (func()): function to remove the interceptor
+func InterceptDeadlocks(handler func(*DeadlockError)) (stop func()) {
+	return defaultDetector.InterceptDeadlocks(handler)
+}
+
+// handleDeadlock reacts to a detected deadlock according to the policy
+//  Args:
+//   r (*Report): report of the deadlock
+//  Returns:
+//   nil
+func (d *Detector) handleDeadlock(r *Report) {
+	err := &DeadlockError{Report: r}
+
+	// an interceptor, e.g. of a test, replaces the policy
+	d.interceptorLock.Lock()
+	handler := d.interceptor
+	d.interceptorLock.Unlock()
+	if handler != nil {
+		d.flushReports()
+		handler(err)
+		return
+	}
+
+	switch d.opts.deadlockPolicy {
+	case PolicyPanic:
+		d.flushReports()
+		panic(err)
+	case PolicyCallback:
+		d.flushReports()
+		if d.opts.deadlockCallback != nil {
+			d.opts.deadlockCallback(err)
+		}
+	case PolicyContinue:
+		d.flushReports()
+	default:
+		// start the comprehensive detection to search for other possible
+		// deadlocks and terminate the program
+		fmt.Fprintf(os.Stderr, red, "THE PROGRAM WAS TERMINATED BECAUSE IT DETECTED A DEADLOCK\n\n")
+		d.FindPotentialDeadlocks()
+		os.Exit(d.opts.exitCode)
+	}
+}
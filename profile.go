@@ -0,0 +1,381 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+profile.go
+This file implements the profiling of the contention and the hold time of the
+locks. For every acquisition the time the routine had to wait for the lock
+and the time the lock was held afterwards are measured and aggregated by the
+lock and the call stack of the acquisition. In contrast to the mutex profile
+of the runtime, the time is attributed to the locks of the program and not to
+sync.(*Mutex).Lock.
+*/
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/petermattis/goid"
+)
+
+// maximum number of stack frames stored for an acquisition
+const profileMaxStack = 32
+
+// call stack of an acquisition, unused entries are 0
+type profileStack [profileMaxStack]uintptr
+
+// key of an entry in the profile
+type profileKey struct {
+	// memory position of the lock
+	lock uintptr
+	// call stack of the acquisition
+	stack profileStack
+}
+
+// statistics of all acquisitions of a lock with the same call stack
+type profileEntry struct {
+	// the lock
+	m mutexInt
+	// call stack of the acquisition
+	stack profileStack
+	// collected statistics
+	stats AcquisitionStats
+}
+
+// a lock which is currently held by a routine
+type profileHold struct {
+	// id of the go routine which holds the lock
+	goID int64
+	// entry of the acquisition
+	entry *profileEntry
+	// time of the acquisition
+	since time.Time
+}
+
+// profiler collects the statistics of the acquisitions
+type lockProfiler struct {
+	// lock to prevent concurrent access to the profiler
+	lock sync.Mutex
+	// time the profiling was started
+	start time.Time
+	// statistics by the lock and the call stack
+	entries map[profileKey]*profileEntry
+	// held locks by the memory position of the lock
+	holds map[uintptr][]profileHold
+}
+
+// initializeProfile starts the profiling, if it is enabled
+//  Returns:
+//   nil
+func (d *Detector) initializeProfile() {
+	if !d.opts.profiling {
+		return
+	}
+	d.profiler = newLockProfiler()
+}
+
+// newLockProfiler creates a new empty profiler
+//  Returns:
+//   (*lockProfiler): the profiler
+func newLockProfiler() *lockProfiler {
+	return &lockProfiler{
+		start:   time.Now(),
+		entries: make(map[profileKey]*profileEntry),
+		holds:   make(map[uintptr][]profileHold),
+	}
+}
+
+// acquire acquires the underlying lock of m. Only if m cannot be acquired
+// immediately, the routine is registered as waiting for m in the wait-for
+// graph. If the profiling is enabled, the time the routine had to wait for
+// m is measured and the acquisition is added to the profile.
+//  Args:
+//   m (mutexInt): the lock
+//   rLock (bool): if set to true, the lock is acquired as reader lock
+//   goID (int64): id of the go routine, only set if the waits are tracked
+//   info (callerInfo): position of the acquisition, only set if the waits
+//    are tracked
+//   skip (int): number of stack frames between the caller of acquire and
+//    the code which acquired the lock
+//  Returns:
+//   nil
+func (d *Detector) acquire(m mutexInt, rLock bool, goID int64, info callerInfo,
+	skip int) {
+	// the lock is contended, if it cannot be acquired immediately
+	if tryLockMutex(m, rLock) {
+		d.profileAcquisition(m, false, 0, skip+1)
+		return
+	}
+
+	start := time.Now()
+	d.waitForLock(m, rLock, goID, info)
+	d.profileAcquisition(m, true, time.Since(start), skip+1)
+}
+
+// profileAcquisition adds an acquisition of m to the profile, if the
+// profiling is enabled
+//  Args:
+//   m (mutexInt): the acquired lock
+//   contended (bool): true if the routine had to wait for m
+//   wait (time.Duration): time the routine waited for m
+//   skip (int): number of stack frames between the caller of
+//    profileAcquisition and the code which acquired the lock
+//  Returns:
+//   nil
+func (d *Detector) profileAcquisition(m mutexInt, contended bool,
+	wait time.Duration, skip int) {
+	p := d.profiler
+	if p == nil {
+		return
+	}
+
+	key := profileKey{lock: m.getMemoryPosition()}
+	runtime.Callers(skip+2, key.stack[:])
+	now := time.Now()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	entry, ok := p.entries[key]
+	if !ok {
+		entry = &profileEntry{m: m, stack: key.stack}
+		p.entries[key] = entry
+	}
+	entry.stats.Acquisitions++
+	if contended {
+		entry.stats.Contentions++
+		entry.stats.Wait += wait
+		if wait > entry.stats.MaxWait {
+			entry.stats.MaxWait = wait
+		}
+	}
+
+	p.holds[key.lock] = append(p.holds[key.lock], profileHold{
+		goID:  goid.Get(),
+		entry: entry,
+		since: now,
+	})
+}
+
+// profileRelease adds the time m was held to the profile, if the profiling
+// is enabled
+//  Args:
+//   m (mutexInt): the released lock
+//  Returns:
+//   nil
+func (d *Detector) profileRelease(m mutexInt) {
+	p := d.profiler
+	if p == nil {
+		return
+	}
+
+	now := time.Now()
+	id := goid.Get()
+	pos := m.getMemoryPosition()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	holds := p.holds[pos]
+	if len(holds) == 0 {
+		// the lock was acquired before the profile was reset
+		return
+	}
+
+	// a lock can be released by another routine than the one which acquired
+	// it. In this case the oldest hold is used.
+	index := 0
+	for i, h := range holds {
+		if h.goID == id {
+			index = i
+			break
+		}
+	}
+
+	h := holds[index]
+	hold := now.Sub(h.since)
+	h.entry.stats.Hold += hold
+	if hold > h.entry.stats.MaxHold {
+		h.entry.stats.MaxHold = hold
+	}
+
+	if len(holds) == 1 {
+		delete(p.holds, pos)
+	} else {
+		p.holds[pos] = append(holds[:index], holds[index+1:]...)
+	}
+}
+
+// resetProfile removes all collected statistics
+//  Returns:
+//   nil
+func (d *Detector) resetProfile() {
+	if d.profiler == nil {
+		return
+	}
+
+	d.profiler.lock.Lock()
+	d.profiler.start = time.Now()
+	d.profiler.entries = make(map[profileKey]*profileEntry)
+	d.profiler.holds = make(map[uintptr][]profileHold)
+	d.profiler.lock.Unlock()
+}
+
+// profileEntries returns a copy of all entries of the profile
+//  Returns:
+//   ([]profileEntry): the entries
+//   (time.Time): time the profiling was started
+func (d *Detector) profileEntries() ([]profileEntry, time.Time) {
+	if d.profiler == nil {
+		return nil, time.Time{}
+	}
+
+	d.profiler.lock.Lock()
+	defer d.profiler.lock.Unlock()
+
+	entries := make([]profileEntry, 0, len(d.profiler.entries))
+	for _, e := range d.profiler.entries {
+		entries = append(entries, *e)
+	}
+	return entries, d.profiler.start
+}
+
+// Stats returns the contention and the hold time of all locks acquired
+// since the start of the profiling, aggregated by the lock and by the call
+// site of the acquisition. The locks are sorted by the total wait time,
+// starting with the most contended lock. The profiling must be enabled with
+// SetProfiling, otherwise no statistics are collected.
+//  Returns:
+//   ([]LockStats): the statistics of the locks
+func (d *Detector) Stats() []LockStats {
+	entries, _ := d.profileEntries()
+
+	locks := make(map[uintptr]*LockStats)
+	sites := make(map[uintptr]map[CallSite]*SiteStats)
+	for _, e := range entries {
+		pos := e.m.getMemoryPosition()
+		lock, ok := locks[pos]
+		if !ok {
+			lock = &LockStats{Lock: newReportLock(e.m)}
+			locks[pos] = lock
+			sites[pos] = make(map[CallSite]*SiteStats)
+		}
+		lock.add(e.stats)
+
+		site := profileCallSite(e.stack)
+		s, ok := sites[pos][site]
+		if !ok {
+			s = &SiteStats{Site: site}
+			sites[pos][site] = s
+		}
+		s.add(e.stats)
+	}
+
+	res := make([]LockStats, 0, len(locks))
+	for pos, lock := range locks {
+		lock.Sites = make([]SiteStats, 0, len(sites[pos]))
+		for _, s := range sites[pos] {
+			lock.Sites = append(lock.Sites, *s)
+		}
+		sort.Slice(lock.Sites, func(i, j int) bool {
+			a, b := &lock.Sites[i], &lock.Sites[j]
+			if a.Wait != b.Wait {
+				return a.Wait > b.Wait
+			}
+			if a.Acquisitions != b.Acquisitions {
+				return a.Acquisitions > b.Acquisitions
+			}
+			if a.Site.File != b.Site.File {
+				return a.Site.File < b.Site.File
+			}
+			return a.Site.Line < b.Site.Line
+		})
+		res = append(res, *lock)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Wait != res[j].Wait {
+			return res[i].Wait > res[j].Wait
+		}
+		if res[i].Acquisitions != res[j].Acquisitions {
+			return res[i].Acquisitions > res[j].Acquisitions
+		}
+		return res[i].Lock.ID < res[j].Lock.ID
+	})
+	return res
+}
+
+// Stats calls Detector.Stats of the default detector
+//  Returns:
+//   ([]LockStats): the statistics of the locks
+func Stats() []LockStats {
+	return defaultDetector.Stats()
+}
+
+// profileCallSite returns the position of the acquisition in a call stack
+//  Args:
+//   stack (profileStack): the call stack
+//  Returns:
+//   (CallSite): the first frame of the stack
+func profileCallSite(stack profileStack) CallSite {
+	frames := runtime.CallersFrames(profileStackPCs(stack))
+	frame, _ := frames.Next()
+	return CallSite{Function: frame.Function, File: frame.File,
+		Line: frame.Line}
+}
+
+// profileStackPCs returns the used part of a call stack
+//  Args:
+//   stack (profileStack): the call stack
+//  Returns:
+//   ([]uintptr): the program counters of the stack
+func profileStackPCs(stack profileStack) []uintptr {
+	n := 0
+	for n < len(stack) && stack[n] != 0 {
+		n++
+	}
+	return stack[:n]
+}
+
+// add adds the statistics of other to s
+//  Args:
+//   other (AcquisitionStats): the statistics to add
+//  Returns:
+//   nil
+func (s *AcquisitionStats) add(other AcquisitionStats) {
+	s.Acquisitions += other.Acquisitions
+	s.Contentions += other.Contentions
+	s.Wait += other.Wait
+	s.Hold += other.Hold
+	if other.MaxWait > s.MaxWait {
+		s.MaxWait = other.MaxWait
+	}
+	if other.MaxHold > s.MaxHold {
+		s.MaxHold = other.MaxHold
+	}
+}
@@ -0,0 +1,368 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+profileProto.go
+This file implements the export of the profile of the locks in the protocol
+buffer format of pprof, so that it can be analyzed with go tool pprof. Every
+sample is the call stack of the acquisition of a lock. The lock itself is
+added as the innermost frame of the stack, so that pprof attributes the wait
+and hold times to the lock and not to the functions of the sync package.
+*/
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// field numbers of the messages in profile.proto of pprof
+const (
+	// Profile
+	profileSampleType        = 1
+	profileSample            = 2
+	profileLocation          = 4
+	profileFunction          = 5
+	profileStringTable       = 6
+	profileTimeNanos         = 9
+	profileDurationNanos     = 10
+	profilePeriodType        = 11
+	profilePeriod            = 12
+	profileDefaultSampleType = 14
+	// ValueType
+	valueTypeType = 1
+	valueTypeUnit = 2
+	// Sample
+	sampleLocationID = 1
+	sampleValue      = 2
+	sampleLabel      = 3
+	// Label
+	labelKey = 1
+	labelStr = 2
+	// Location
+	locationID      = 1
+	locationAddress = 3
+	locationLine    = 4
+	// Line
+	lineFunctionID = 1
+	lineLine       = 2
+	// Function
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+	functionStartLine  = 5
+)
+
+// buffer to encode a protocol buffer message
+type protoBuffer struct {
+	data []byte
+}
+
+// varint appends an unsigned integer in the varint encoding
+//  Args:
+//   x (uint64): the integer
+//  Returns:
+//   nil
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+// uint64 appends a field with an integer value, fields with value 0 are
+// omitted
+//  Args:
+//   field (int): number of the field
+//   x (uint64): value of the field
+//  Returns:
+//   nil
+func (b *protoBuffer) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(field) << 3)
+	b.varint(x)
+}
+
+// int64 appends a field with a signed integer value, fields with value 0
+// are omitted
+//  Args:
+//   field (int): number of the field
+//   x (int64): value of the field
+//  Returns:
+//   nil
+func (b *protoBuffer) int64(field int, x int64) {
+	b.uint64(field, uint64(x))
+}
+
+// bytes appends a field with length delimited content
+//  Args:
+//   field (int): number of the field
+//   data ([]byte): value of the field
+//  Returns:
+//   nil
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+// packed appends a repeated field of integers in the packed encoding
+//  Args:
+//   field (int): number of the field
+//   x ([]uint64): values of the field
+//  Returns:
+//   nil
+func (b *protoBuffer) packed(field int, x []uint64) {
+	var values protoBuffer
+	for _, v := range x {
+		values.varint(v)
+	}
+	b.bytes(field, values.data)
+}
+
+// builder for a profile in the format of pprof
+type profileBuilder struct {
+	// the encoded profile
+	buffer protoBuffer
+	// index of the strings in the string table
+	strings map[string]int64
+	// string table in the order of the indices
+	stringTable []string
+	// ids of the functions by their name and file
+	functions map[[2]string]uint64
+	// ids of the locations by the program counter and the function
+	locations map[profileLocationKey]uint64
+}
+
+// key of a location in the profile
+type profileLocationKey struct {
+	// program counter, 0 for the frames of the locks
+	pc uintptr
+	// name of the function
+	function string
+	// line in the file
+	line int
+}
+
+// newProfileBuilder creates a builder for an empty profile
+//  Returns:
+//   (*profileBuilder): the builder
+func newProfileBuilder() *profileBuilder {
+	b := &profileBuilder{
+		strings:   make(map[string]int64),
+		functions: make(map[[2]string]uint64),
+		locations: make(map[profileLocationKey]uint64),
+	}
+	// the first string in the table must be the empty string
+	b.string("")
+	return b
+}
+
+// string returns the index of s in the string table and adds s to the
+// table, if it is not in the table yet
+//  Args:
+//   s (string): the string
+//  Returns:
+//   (int64): the index of s
+func (b *profileBuilder) string(s string) int64 {
+	index, ok := b.strings[s]
+	if !ok {
+		index = int64(len(b.stringTable))
+		b.strings[s] = index
+		b.stringTable = append(b.stringTable, s)
+	}
+	return index
+}
+
+// valueType appends a ValueType message
+//  Args:
+//   field (int): number of the field of the message
+//   typ (string): type of the value
+//   unit (string): unit of the value
+//  Returns:
+//   nil
+func (b *profileBuilder) valueType(field int, typ string, unit string) {
+	var m protoBuffer
+	m.int64(valueTypeType, b.string(typ))
+	m.int64(valueTypeUnit, b.string(unit))
+	b.buffer.bytes(field, m.data)
+}
+
+// location returns the id of a location and adds the location and its
+// function to the profile, if they are not in the profile yet
+//  Args:
+//   pc (uintptr): program counter, 0 for the frames of the locks
+//   function (string): name of the function
+//   file (string): file of the function
+//   line (int): line in the file
+//  Returns:
+//   (uint64): the id of the location
+func (b *profileBuilder) location(pc uintptr, function string, file string,
+	line int) uint64 {
+	key := profileLocationKey{pc: pc, function: function, line: line}
+	if id, ok := b.locations[key]; ok {
+		return id
+	}
+
+	fnKey := [2]string{function, file}
+	fnID, ok := b.functions[fnKey]
+	if !ok {
+		fnID = uint64(len(b.functions) + 1)
+		b.functions[fnKey] = fnID
+
+		var m protoBuffer
+		m.uint64(functionID, fnID)
+		m.int64(functionName, b.string(function))
+		m.int64(functionSystemName, b.string(function))
+		m.int64(functionFilename, b.string(file))
+		if pc == 0 {
+			m.int64(functionStartLine, int64(line))
+		}
+		b.buffer.bytes(profileFunction, m.data)
+	}
+
+	id := uint64(len(b.locations) + 1)
+	b.locations[key] = id
+
+	var l protoBuffer
+	l.uint64(lineFunctionID, fnID)
+	l.int64(lineLine, int64(line))
+
+	var m protoBuffer
+	m.uint64(locationID, id)
+	m.uint64(locationAddress, uint64(pc))
+	m.bytes(locationLine, l.data)
+	b.buffer.bytes(profileLocation, m.data)
+	return id
+}
+
+// sample appends the sample of an entry of the lock profile
+//  Args:
+//   e (*profileEntry): the entry
+//  Returns:
+//   nil
+func (b *profileBuilder) sample(e *profileEntry) {
+	lock := newReportLock(e.m)
+
+	// the lock is the innermost frame of the stack
+	name := lock.Type + " " + lock.ID
+	if lock.Created.File != "" {
+		name = fmt.Sprintf("%s %s:%d", lock.Type,
+			filepath.Base(lock.Created.File), lock.Created.Line)
+	}
+	ids := []uint64{b.location(0, name, lock.Created.File, lock.Created.Line)}
+
+	frames := runtime.CallersFrames(profileStackPCs(e.stack))
+	for {
+		frame, more := frames.Next()
+		if frame.PC != 0 {
+			ids = append(ids, b.location(frame.PC, frame.Function, frame.File,
+				frame.Line))
+		}
+		if !more {
+			break
+		}
+	}
+
+	var label protoBuffer
+	label.int64(labelKey, b.string("lock"))
+	label.int64(labelStr, b.string(lock.ID))
+
+	var m protoBuffer
+	m.packed(sampleLocationID, ids)
+	m.packed(sampleValue, []uint64{
+		uint64(e.stats.Contentions),
+		uint64(e.stats.Wait.Nanoseconds()),
+		uint64(e.stats.Acquisitions),
+		uint64(e.stats.Hold.Nanoseconds()),
+	})
+	m.bytes(sampleLabel, label.data)
+	b.buffer.bytes(profileSample, m.data)
+}
+
+// WriteProfile writes the contention and the hold time of all locks
+// acquired since the start of the profiling as gzip compressed profile in
+// the format of pprof. The profile can be analyzed with go tool pprof. It
+// contains the sample types contentions, delay, acquisitions and hold, where
+// delay is the total time the routines waited for the locks and hold is the
+// total time the locks were held. The profiling must be enabled with
+// SetProfiling.
+//  Args:
+//   w (io.Writer): writer for the profile
+//  Returns:
+//   (error): error if the profiling is disabled or the write failed
+func (d *Detector) WriteProfile(w io.Writer) error {
+	if d.profiler == nil {
+		return errors.New("profiling is disabled")
+	}
+
+	entries, start := d.profileEntries()
+	now := time.Now()
+
+	b := newProfileBuilder()
+	b.valueType(profileSampleType, "contentions", "count")
+	b.valueType(profileSampleType, "delay", "nanoseconds")
+	b.valueType(profileSampleType, "acquisitions", "count")
+	b.valueType(profileSampleType, "hold", "nanoseconds")
+	for i := range entries {
+		b.sample(&entries[i])
+	}
+	b.buffer.int64(profileTimeNanos, start.UnixNano())
+	b.buffer.int64(profileDurationNanos, now.Sub(start).Nanoseconds())
+	b.valueType(profilePeriodType, "contentions", "count")
+	b.buffer.int64(profilePeriod, 1)
+	b.buffer.int64(profileDefaultSampleType, b.string("delay"))
+
+	// the string table is written last, because it is filled while the
+	// other messages are encoded
+	for _, s := range b.stringTable {
+		b.buffer.bytes(profileStringTable, []byte(s))
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(b.buffer.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// WriteProfile calls Detector.WriteProfile of the default detector
+//  Args:
+//   w (io.Writer): writer for the profile
+//  Returns:
+//   (error): error if the profiling is disabled or the write failed
+func WriteProfile(w io.Writer) error {
+	return defaultDetector.WriteProfile(w)
+}
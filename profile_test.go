@@ -0,0 +1,238 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+profile_test.go
+Tests for the profiling of the contention and the hold time of the locks.
+*/
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+// profiledContention holds a lock for the given time while another routine
+// waits for it and acquires a second lock once without contention
+//  Args:
+//   t (*testing.T): the test
+//   d (*Detector): the detector with enabled profiling
+//   hold (time.Duration): time the contended lock is held
+//  Returns:
+//   (*Mutex): the contended lock
+//   (*Mutex): the lock without contention
+func profiledContention(t *testing.T, d *Detector, hold time.Duration) (*Mutex, *Mutex) {
+	contended := d.NewLock()
+	free := d.NewLock()
+
+	free.Lock()
+	free.Unlock()
+
+	contended.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		contended.Lock()
+		contended.Unlock()
+	}()
+	waitUntil(t, func() bool {
+		d.lockWaitLock.Lock()
+		defer d.lockWaitLock.Unlock()
+		return len(d.lockWaits) == 1
+	})
+	time.Sleep(hold)
+	contended.Unlock()
+	<-done
+
+	return contended, free
+}
+
+// The wait and the hold time are attributed to the contended lock and its
+// call sites, which are sorted by the wait time.
+func TestProfileStats(t *testing.T) {
+	hold := 50 * time.Millisecond
+	d := NewDetector()
+	d.SetProfiling(true)
+	contended, free := profiledContention(t, d, hold)
+
+	stats := d.Stats()
+	if len(stats) != 2 {
+		t.Fatalf("expected statistics of 2 locks, got %d", len(stats))
+	}
+
+	s := stats[0]
+	if s.Lock.ID != lockID(contended) {
+		t.Fatalf("expected the contended lock first, got %s", s.Lock.ID)
+	}
+	if s.Acquisitions != 2 || s.Contentions != 1 {
+		t.Errorf("expected 2 acquisitions and 1 contention, got %d and %d",
+			s.Acquisitions, s.Contentions)
+	}
+	if s.Wait < hold || s.MaxWait != s.Wait {
+		t.Errorf("expected a single wait of at least %v, got %v with maximum %v",
+			hold, s.Wait, s.MaxWait)
+	}
+	if s.Hold < hold || s.MaxHold < hold {
+		t.Errorf("expected the lock to be held at least %v, got %v with maximum %v",
+			hold, s.Hold, s.MaxHold)
+	}
+
+	// the contended site is first, the site which held the lock second
+	if len(s.Sites) != 2 {
+		t.Fatalf("expected 2 sites, got %d", len(s.Sites))
+	}
+	if s.Sites[0].Wait != s.Wait || s.Sites[1].Wait != 0 {
+		t.Errorf("expected the wait at the first site, got %v and %v",
+			s.Sites[0].Wait, s.Sites[1].Wait)
+	}
+	if s.Sites[1].MaxHold < hold {
+		t.Errorf("expected the second site to hold the lock at least %v, got %v",
+			hold, s.Sites[1].MaxHold)
+	}
+
+	f := stats[1]
+	if f.Lock.ID != lockID(free) || f.Acquisitions != 1 || f.Contentions != 0 || f.Wait != 0 {
+		t.Errorf("expected a single acquisition without contention, got %+v", f)
+	}
+}
+
+// protoFields decodes the fields of a protocol buffer message. Only the wire
+// types varint and length-delimited are supported.
+//  Args:
+//   t (*testing.T): the test
+//   data ([]byte): the message
+//  Returns:
+//   (map[int][][]byte): the length-delimited fields by their number
+//   (map[int][]uint64): the varint fields by their number
+func protoFields(t *testing.T, data []byte) (map[int][][]byte, map[int][]uint64) {
+	bytesFields := make(map[int][][]byte)
+	varintFields := make(map[int][]uint64)
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			t.Fatal("invalid tag")
+		}
+		data = data[n:]
+		field := int(tag >> 3)
+		switch tag & 7 {
+		case 0:
+			x, n := binary.Uvarint(data)
+			if n <= 0 {
+				t.Fatal("invalid varint")
+			}
+			varintFields[field] = append(varintFields[field], x)
+			data = data[n:]
+		case 2:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				t.Fatal("invalid length")
+			}
+			bytesFields[field] = append(bytesFields[field], data[n:n+int(length)])
+			data = data[n+int(length):]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+	}
+	return bytesFields, varintFields
+}
+
+// The pprof profile contains a sample for every lock and call stack with the
+// contentions, the delay, the acquisitions and the hold time.
+func TestWriteProfile(t *testing.T) {
+	if err := NewDetector().WriteProfile(io.Discard); err == nil {
+		t.Error("expected an error if the profiling is disabled")
+	}
+
+	d := NewDetector()
+	d.SetProfiling(true)
+	profiledContention(t, d, 50*time.Millisecond)
+
+	var buf bytes.Buffer
+	if err := d.WriteProfile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile, _ := protoFields(t, data)
+	table := make([]string, 0)
+	for _, s := range profile[profileStringTable] {
+		table = append(table, string(s))
+	}
+	for _, typ := range []string{"contentions", "delay", "acquisitions", "hold"} {
+		found := false
+		for _, s := range table {
+			found = found || s == typ
+		}
+		if !found {
+			t.Errorf("the sample type %s is missing", typ)
+		}
+	}
+
+	// the sums of the samples are the totals of the statistics
+	var total AcquisitionStats
+	for _, s := range d.Stats() {
+		total.Contentions += s.Contentions
+		total.Wait += s.Wait
+		total.Acquisitions += s.Acquisitions
+		total.Hold += s.Hold
+	}
+	var sums [4]uint64
+	samples := profile[profileSample]
+	if len(samples) != 3 {
+		t.Fatalf("expected 3 samples, got %d", len(samples))
+	}
+	for _, sample := range samples {
+		fields, _ := protoFields(t, sample)
+		values := fields[sampleValue]
+		if len(values) != 1 {
+			t.Fatalf("expected packed values, got %d fields", len(values))
+		}
+		for i := range sums {
+			x, n := binary.Uvarint(values[0])
+			if n <= 0 {
+				t.Fatal("invalid value")
+			}
+			sums[i] += x
+			values[0] = values[0][n:]
+		}
+	}
+	expected := [4]uint64{uint64(total.Contentions), uint64(total.Wait),
+		uint64(total.Acquisitions), uint64(total.Hold)}
+	if sums != expected {
+		t.Errorf("expected the sums %v, got %v", expected, sums)
+	}
+}
@@ -0,0 +1,122 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+release.go
+This file implements the release of the locks held by a routine, which
+detected a deadlock. If the routine releases its locks, the other routines
+waiting for them can continue, e.g. so that a test fails instead of hanging.
+The unlocks of the released locks done later by the routine itself are
+ignored.
+*/
+
+import (
+	"sync/atomic"
+
+	"github.com/petermattis/goid"
+)
+
+// key of a lock released by ReleaseLocks
+type releasedKey struct {
+	// id of the go routine which held the lock
+	goID int64
+	// memory position of the lock
+	memoryPosition uintptr
+}
+
+// ReleaseLocks releases all locks held by the calling routine, so that the
+// routines waiting for them are not blocked. Later unlocks of these locks by
+// the routine are ignored. It is meant to be used in an interceptor set with
+// InterceptDeadlocks, if the routine which detected a deadlock should
+// continue or end. Only the locks known to the detection are released, so
+// the periodic or comprehensive detection must be enabled.
+//  Returns:
+//   nil
+func (d *Detector) ReleaseLocks() {
+	r := d.getRoutine()
+	if r == nil {
+		return
+	}
+
+	r.lock.Lock()
+	held := make([]mutexInt, r.holdingCount)
+	copy(held, r.holdingSet[:r.holdingCount])
+	r.lock.Unlock()
+
+	// release the locks in the reverse order of their acquisition
+	for i := len(held) - 1; i >= 0; i-- {
+		m := held[i]
+		rLock := m.getRLock(r.goID)
+
+		key := releasedKey{goID: r.goID, memoryPosition: m.getMemoryPosition()}
+		d.releasedLock.Lock()
+		d.released[key]++
+		d.releasedLock.Unlock()
+		atomic.AddInt32(&d.numberReleased, 1)
+
+		unlockInt(m)
+		unlockMutex(m, rLock)
+	}
+}
+
+// ReleaseLocks calls Detector.ReleaseLocks of the default detector
+//  Returns:
+//   nil
+func ReleaseLocks() {
+	defaultDetector.ReleaseLocks()
+}
+
+// check if an unlock of m by the calling routine has to be ignored, because
+// m was already released by ReleaseLocks. If so, the release is consumed.
+//  Args:
+//   m (mutexInt): the lock to unlock
+//  Returns:
+//   (bool): true if the unlock has to be ignored, false otherwise
+func (d *Detector) ignoreUnlock(m mutexInt) bool {
+	// no lock was released, do not slow down the unlock
+	if atomic.LoadInt32(&d.numberReleased) == 0 {
+		return false
+	}
+
+	key := releasedKey{goID: goid.Get(), memoryPosition: m.getMemoryPosition()}
+
+	d.releasedLock.Lock()
+	defer d.releasedLock.Unlock()
+
+	if d.released[key] == 0 {
+		return false
+	}
+
+	if d.released[key] == 1 {
+		delete(d.released, key)
+	} else {
+		d.released[key]--
+	}
+	atomic.AddInt32(&d.numberReleased, -1)
+
+	return true
+}
@@ -0,0 +1,221 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+replay.go
+This file implements the replay of a recorded trace. The events of the trace
+are used to rebuild the lock trees of the routines, on which the
+comprehensive detection can be run after the program has finished, e.g. for
+a program which was killed or with different settings.
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// state of a replay
+type replay struct {
+	// detector into which the trace is replayed
+	detector *Detector
+	// settings of the replay
+	options ReplayOptions
+	// replayed locks by the identity of the lock in the trace
+	locks map[string]mutexInt
+	// replayed locks by their creation position, used for ByClass
+	classes map[string]mutexInt
+}
+
+// ReplayTrace reads a trace recorded with SetTraceFile, rebuilds the lock
+// trees of the routines and runs the comprehensive detection on them.
+// The found deadlocks are reported like in the recorded program, including
+// all enabled outputs. ReplayTrace must not be used in a program which
+// uses the locks of the detector itself.
+//  Args:
+//   r (io.Reader): reader with the trace
+//   options (ReplayOptions): settings for the replay
+//  Returns:
+//   (error): error if the trace could not be read
+func (d *Detector) ReplayTrace(r io.Reader, options ReplayOptions) error {
+	d.initialize()
+
+	rp := &replay{
+		detector: d,
+		options:  options,
+		locks:    make(map[string]mutexInt),
+		classes:  make(map[string]mutexInt),
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var event TraceEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			// the last line can be incomplete if the process was killed
+			// while writing the trace
+			fmt.Fprintf(os.Stderr, "Deadlock-Go: skipping invalid event in line %d: %v\n",
+				lineNumber, err)
+			continue
+		}
+		if err := rp.apply(event); err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	d.FindPotentialDeadlocks()
+	return nil
+}
+
+// ReplayTrace calls Detector.ReplayTrace of the default detector
+//  Args:
+//   r (io.Reader): reader with the trace
+//   options (ReplayOptions): settings for the replay
+//  Returns:
+//   (error): error if the trace could not be read
+func ReplayTrace(r io.Reader, options ReplayOptions) error {
+	return defaultDetector.ReplayTrace(r, options)
+}
+
+// apply replays a single event
+//  Args:
+//   event (TraceEvent): the event
+//  Returns:
+//   (error): error if the event is invalid
+func (rp *replay) apply(event TraceEvent) error {
+	info := newInfo(event.File, event.Line, event.Op == TraceCreate, "")
+
+	if event.Op == TraceCreate {
+		return rp.create(event, info)
+	}
+
+	m, ok := rp.locks[event.Lock]
+	if !ok {
+		// the lock was filtered out or created before the trace was started
+		return nil
+	}
+
+	// get the routine of the event
+	d := rp.detector
+	r := d.routineOf(event.Goroutine)
+	if r == nil {
+		r = d.addRoutine(event.Goroutine, callerInfo{})
+	}
+
+	switch event.Op {
+	case TraceLock, TraceRLock:
+		rLock := event.Op == TraceRLock
+		addAcquisition(m, event.Goroutine)
+		addLocked(m)
+		r.updateLock(m, rLock, info)
+	case TraceTryLock, TraceTryRLock:
+		rLock := event.Op == TraceTryRLock
+		addAcquisition(m, event.Goroutine)
+		addLocked(m)
+		r.updateTryLock(m, rLock, info)
+	case TraceTryLockFailed, TraceTryRLockFailed:
+		// the lock was not acquired, so the lock tree does not change
+	case TraceUnlock:
+		removeAcquisition(m, event.Goroutine)
+		r.updateUnlock(m)
+	default:
+		return fmt.Errorf("unknown operation %q", event.Op)
+	}
+	return nil
+}
+
+// create creates the lock of a create event
+//  Args:
+//   event (TraceEvent): the event
+//   info (callerInfo): position of the creation
+//  Returns:
+//   (error): error if the event is invalid
+func (rp *replay) create(event TraceEvent, info callerInfo) error {
+	if rp.options.Filter != nil && !rp.options.Filter.MatchString(event.File) {
+		return nil
+	}
+
+	class := fmt.Sprintf("%s:%d", event.File, event.Line)
+	if rp.options.ByClass {
+		if m, ok := rp.classes[class]; ok {
+			rp.locks[event.Lock] = m
+			return nil
+		}
+	}
+
+	position, err := strconv.ParseUint(strings.TrimPrefix(event.Lock, "0x"), 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid lock %q", event.Lock)
+	}
+
+	var m mutexInt
+	switch event.Type {
+	case "Mutex":
+		m = &Mutex{
+			mu:                  &sync.Mutex{},
+			context:             []callerInfo{info},
+			created:             1,
+			isLockedRoutine:     map[int64]int{},
+			isLockedRoutineLock: &sync.Mutex{},
+			memoryPosition:      uintptr(position),
+			detector:            rp.detector,
+		}
+	case "RWMutex":
+		m = &RWMutex{
+			mu:                  &sync.RWMutex{},
+			context:             []callerInfo{info},
+			created:             1,
+			isLockedRoutine:     map[int64]int{},
+			isLockedRoutineLock: &sync.Mutex{},
+			memoryPosition:      uintptr(position),
+			detector:            rp.detector,
+			isRLock:             map[int64]bool{},
+			isRLockLock:         &sync.Mutex{},
+		}
+	default:
+		return fmt.Errorf("unknown lock type %q", event.Type)
+	}
+
+	rp.locks[event.Lock] = m
+	rp.classes[class] = m
+	return nil
+}
@@ -1,9 +1,12 @@
+//go:build !deadlock_off
+
 package deadlock
 
 import (
 	"fmt"
 	"os"
-	"runtime"
+	"strings"
+	"time"
 )
 
 /*
@@ -45,86 +48,224 @@ const (
 // report if double locking is detected
 //  Args:
 //   m (mutexInt): mutex on which double locking was detected
+//   r (*routine): routine which tried to lock m again
+//   rLock (bool): true, if the second acquisition is a r-lock
 //  Returns:
-//   nil
-func reportDeadlockDoubleLocking(m mutexInt) {
+//   (*Report): the report of the double locking
+func (d *Detector) reportDeadlockDoubleLocking(m mutexInt, r *routine, rLock bool) *Report {
 	fmt.Fprintf(os.Stderr, red, "DEADLOCK (DOUBLE LOCKING)\n\n")
 
 	// print information about the involved lock
 	fmt.Fprintf(os.Stderr, purple, "Initialization of lock involved in deadlock:\n\n")
 	context := *m.getContext()
 	fmt.Fprintln(os.Stderr, context[0].file, context[0].line)
-	fmt.Fprintln(os.Stderr, "")
-	fmt.Fprintf(os.Stderr, purple, "Calls of lock involved in deadlock:\n\n")
-	for i, call := range context {
-		if i == 0 {
-			continue
-		}
-		fmt.Fprintln(os.Stderr, call.file, call.line)
-	}
-	_, file, line, _ := runtime.Caller(4)
-	fmt.Fprintln(os.Stderr, file, line)
-	fmt.Fprintf(os.Stderr, "\n\n")
+
+	// get the position of the acquisition which leads to the double locking
+	rep := newReportDoubleLocking(m, r, rLock, d.newAcquisitionInfo(4))
+	d.recordHistory(rep)
+	d.printHistory(rep)
+
+	// print the routine, the locks it holds and the lock it tries to acquire
+	fmt.Fprintf(os.Stderr, purple, "\nRoutine involved in deadlock:\n\n")
+	printEdges(rep)
+	fmt.Fprintf(os.Stderr, "\n")
+
+	d.writeReport(rep)
+	return rep
 }
 
 // report a found deadlock
 //  Args:
-//   stack (*depStack) stack which represents the found cycle
+//   r (*Report): report of the cycle
 //  Returns:
 //   nil
-func reportDeadlock(stack *depStack) {
-	fmt.Fprintf(os.Stderr, red, "POTENTIAL DEADLOCK\n\n")
+func (d *Detector) reportDeadlock(r *Report) {
+	// potential deadlocks found in earlier runs can be suppressed
+	if d.recordHistory(r) {
+		return
+	}
+
+	if r.Confirmed {
+		fmt.Fprintf(os.Stderr, red, "POTENTIAL DEADLOCK (CONFIRMED)\n\n")
+	} else {
+		fmt.Fprintf(os.Stderr, red, "POTENTIAL DEADLOCK\n\n")
+	}
+	d.printHistory(r)
 
 	// print information about the locks in the circle
 	fmt.Fprintf(os.Stderr, purple, "Initialization of locks involved in potential deadlock:\n\n")
-	for cl := stack.stack.next; cl != nil; cl = cl.next {
-		for _, c := range *cl.depEntry.mu.getContext() {
-			if c.create {
-				fmt.Fprintln(os.Stderr, c.file, c.line)
-			}
+	for _, edge := range r.Edges {
+		created := r.lock(edge.Lock).Created
+		fmt.Fprintln(os.Stderr, created.File, created.Line)
+	}
+
+	// print which routine acquired which lock while holding which locks
+	fmt.Fprintf(os.Stderr, purple, "\nRoutines involved in potential deadlock:\n\n")
+	printEdges(r)
+
+	// print how often the cycle was found
+	fmt.Fprintf(os.Stderr, purple, "Number of combinations of routines with this cycle: ")
+	fmt.Fprintln(os.Stderr, r.Count)
+	fmt.Fprintf(os.Stderr, "\n\n")
+
+	// print the interleaving in which the deadlock was observed
+	if r.Confirmation != nil {
+		fmt.Fprintf(os.Stderr, purple, "Observed interleaving of the confirmed deadlock:\n\n")
+		printEdges(r.Confirmation)
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+
+	d.writeReport(r)
+}
+
+// print for every edge of a report the routine, the locks held by the
+// routine and the lock it acquired
+//  Args:
+//   r (*Report): the report
+//  Returns:
+//   nil
+func printEdges(r *Report) {
+	for _, edge := range r.Edges {
+		header := fmt.Sprintf("Routine %d (goroutine %d", edge.Routine, edge.Goroutine)
+		if edge.CreatedBy != nil {
+			header += fmt.Sprintf(", created by %s at %s:%d", edge.CreatedBy.Function,
+				edge.CreatedBy.File, edge.CreatedBy.Line)
+		}
+		header += ")"
+		fmt.Fprintf(os.Stderr, blue, header)
+		fmt.Fprintln(os.Stderr, "")
+
+		for _, h := range edge.Holding {
+			created := r.lock(h.Lock).Created
+			fmt.Fprintf(os.Stderr, "\tholding %s created at %s:%d, acquired at %s:%d\n",
+				lockMode(h.RLock), created.File, created.Line, h.Acquired.File,
+				h.Acquired.Line)
+			printStack(h.Acquired.Stack)
 		}
+
+		// routines in an actual deadlock are blocked on the lock
+		action := "acquiring"
+		if r.Kind == KindDeadlock {
+			action = "blocked on"
+		} else if r.Kind == KindLockWaitTimeout {
+			action = fmt.Sprintf("waiting for %v on",
+				time.Duration(edge.WaitingMs)*time.Millisecond)
+		}
+
+		created := r.lock(edge.Lock).Created
+		fmt.Fprintf(os.Stderr, "\t%s %s created at %s:%d, at %s:%d",
+			action, lockMode(edge.RLock), created.File, created.Line,
+			edge.Acquired.File, edge.Acquired.Line)
+		for _, holder := range edge.HeldBy {
+			fmt.Fprintf(os.Stderr, ", held by goroutine %d", holder)
+		}
+		fmt.Fprintln(os.Stderr, "")
+		printStack(edge.Acquired.Stack)
+		fmt.Fprintln(os.Stderr, "")
 	}
+}
 
-	// print information if call stacks were collected
-	if opts.collectCallStack {
-		fmt.Fprintf(os.Stderr, purple, "\nCallStacks of Locks involved in potential deadlock:\n\n")
-		for cl := stack.stack.next; cl != nil; cl = cl.next {
-			cont := *cl.depEntry.mu.getContext()
-			fmt.Fprintf(os.Stderr, blue, "CallStacks for lock created at: ")
-			fmt.Fprintf(os.Stderr, blue, cont[0].file)
-			fmt.Fprintf(os.Stderr, blue, ":")
-			fmt.Fprintf(os.Stderr, blue, fmt.Sprint(cont[0].line))
-			fmt.Fprintf(os.Stderr, "\n\n")
-			for i, c := range cont {
-				if i != 0 {
-					fmt.Fprint(os.Stderr, c.callStacks)
-				}
-			}
+// print how often a deadlock was found in earlier runs, if it is known
+//  Args:
+//   r (*Report): the report
+//  Returns:
+//   nil
+func (d *Detector) printHistory(r *Report) {
+	if r.SeenBefore == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, purple, fmt.Sprintf("Seen before, %d times (signature %s)\n\n",
+		r.SeenBefore, r.Signature))
+}
+
+// print an indented call stack, if it was collected
+//  Args:
+//   stack (string): the call stack
+//  Returns:
+//   nil
+func printStack(stack string) {
+	if stack == "" {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimRight(stack, "\n"), "\n") {
+		fmt.Fprintln(os.Stderr, "\t\t"+line)
+	}
+}
+
+// lockMode returns the description of the mode of an acquisition
+//  Args:
+//   rLock (bool): true if the lock was acquired as r-lock
+//  Returns:
+//   (string): "r-lock" or "lock"
+func lockMode(rLock bool) string {
+	if rLock {
+		return "r-lock"
+	}
+	return "lock"
+}
+
+// report a deadlock found by the periodical detection
+//  Args:
+//   r (*Report): report of the deadlock
+//  Returns:
+//   nil
+func (d *Detector) reportDeadlockPeriodical(r *Report) {
+	d.recordHistory(r)
+
+	fmt.Fprintf(os.Stderr, red, "DEADLOCK (LOCAL DEADLOCK)\n\n")
+	d.printHistory(r)
+
+	// print the routines in the deadlock and the locks they are blocked on
+	fmt.Fprintf(os.Stderr, purple, "Routines involved in deadlock:\n\n")
+	printEdges(r)
+
+	// print the current call stacks of the routines
+	fmt.Fprintf(os.Stderr, purple, "Current call stacks of the routines involved in deadlock:\n\n")
+	for _, edge := range r.Edges {
+		fmt.Fprintln(os.Stderr, edge.CurrentStack)
+	}
+	fmt.Fprintf(os.Stderr, "\n")
+
+	d.writeReport(r)
+}
+
+// report a routine which waited longer than the lock wait timeout for a lock
+//  Args:
+//   r (*Report): report of the wait
+//  Returns:
+//   nil
+func (d *Detector) reportLockWaitTimeout(r *Report) {
+	fmt.Fprintf(os.Stderr, red, "LOCK WAIT TIMEOUT\n\n")
+
+	// print the waiting routine
+	fmt.Fprintf(os.Stderr, purple, "Routine waiting for lock:\n\n")
+	printEdges(r)
+
+	// print the holders of the lock, where they acquired it and what they
+	// are currently doing
+	fmt.Fprintf(os.Stderr, purple, "Routines holding the lock:\n\n")
+	for _, edge := range r.Edges {
+		if len(edge.Holders) == 0 {
+			fmt.Fprintln(os.Stderr, "\tunknown, the lock was acquired without the detector")
 		}
-	} else {
-		// print information if only caller information were selected
-		fmt.Fprintf(os.Stderr, purple, "\nCalls of locks involved in potential deadlock:\n\n")
-		for cl := stack.stack.next; cl != nil; cl = cl.next {
-			for i, c := range *cl.depEntry.mu.getContext() {
-				if i == 0 {
-					fmt.Fprintf(os.Stderr, blue, "Calls for lock created at: ")
-					fmt.Fprintf(os.Stderr, blue, c.file)
-					fmt.Fprintf(os.Stderr, blue, ":")
-					fmt.Fprintf(os.Stderr, blue, fmt.Sprint(c.line))
-					fmt.Fprintf(os.Stderr, "\n")
-				} else {
-					fmt.Fprintln(os.Stderr, c.file, c.line)
-				}
-			}
+		for _, h := range edge.Holders {
+			fmt.Fprintf(os.Stderr, blue, fmt.Sprintf("Goroutine %d", h.Goroutine))
+			fmt.Fprintf(os.Stderr, "\n\tholding %s for %v, acquired at %s:%d\n",
+				lockMode(h.RLock), time.Duration(h.HeldMs)*time.Millisecond,
+				h.Acquired.File, h.Acquired.Line)
+			printStack(h.Acquired.Stack)
+			fmt.Fprintln(os.Stderr, "\tcurrent call stack:")
+			printStack(h.CurrentStack)
 			fmt.Fprintln(os.Stderr, "")
 		}
 	}
-	fmt.Fprintf(os.Stderr, "\n\n")
-}
 
-// print a message, that the program was terminated because of a detected local deadlock
-// Returns:
-//  nil
-func reportDeadlockPeriodical() {
-	fmt.Fprintf(os.Stderr, red, "THE PROGRAM WAS TERMINATED BECAUSE IT DETECTED A LOCAL DEADLOCK\n\n")
+	// print the current call stack of the waiting routine
+	fmt.Fprintf(os.Stderr, purple, "Current call stack of the waiting routine:\n\n")
+	for _, edge := range r.Edges {
+		fmt.Fprintln(os.Stderr, edge.CurrentStack)
+	}
+
+	d.writeReport(r)
+	d.flushReports()
 }
@@ -0,0 +1,345 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+reportData.go
+This file implements the structured representation of the findings of the
+detector. The structures are independent of the internal data of the detector,
+so that they can be written in machine readable formats like JSON.
+*/
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// newReport creates a new empty report
+//  Args:
+//   kind (ReportKind): kind of the finding
+//  Returns:
+//   (*Report): the created report
+func newReport(kind ReportKind) *Report {
+	return &Report{
+		SchemaVersion: ReportSchemaVersion,
+		Kind:          kind,
+		Locks:         make([]ReportLock, 0),
+		Edges:         make([]ReportEdge, 0),
+		Count:         1,
+	}
+}
+
+// newReportPotentialDeadlock creates the report for a cycle found by the
+// comprehensive detection
+//  Args:
+//   stack (*depStack): stack which represents the found cycle
+//  Returns:
+//   (*Report): the created report
+func (d *Detector) newReportPotentialDeadlock(stack *depStack) *Report {
+	r := newReport(KindPotentialDeadlock)
+	for cl := stack.stack.next; cl != nil; cl = cl.next {
+		dep := cl.depEntry
+		r.addEdge(cl.routine, dep.mu, dep.rLock,
+			dep.muInfo, dep.holdingSet[:dep.holdingCount], dep.holdingInfo)
+	}
+	r.Signature = r.signature()
+	return r
+}
+
+// newReportActualDeadlock creates the report for a deadlock found by the
+// periodical detection. The routine of each wait in the cycle waits for a
+// lock, which is held by the routine of the next wait in the cycle.
+// The locks held by the routines are taken from the tracked holders, so
+// lockWaitLock must be held.
+//  Args:
+//   cycle ([]*lockWait): waits which form the cycle
+//  Returns:
+//   (*Report): the created report
+func newReportActualDeadlock(cycle []*lockWait) *Report {
+	r := newReport(KindDeadlock)
+
+	ids := make([]int64, 0, len(cycle))
+	for _, w := range cycle {
+		ids = append(ids, w.goID)
+	}
+	stacks := getRoutineStacks(ids)
+
+	for _, w := range cycle {
+		edge := r.addWaitEdge(w)
+		edge.CurrentStack = stacks[w.goID]
+	}
+	r.Signature = r.signature()
+	return r
+}
+
+// newReportLockWaitTimeout creates the report for a routine which waits
+// longer than the lock wait timeout. The locks held by the waiting routine
+// and the holders of the lock are taken from the tracked holders, so
+// lockWaitLock must be held.
+//  Args:
+//   w (*lockWait): the wait
+//   now (time.Time): time of the check
+//  Returns:
+//   (*Report): the created report
+func newReportLockWaitTimeout(w *lockWait, now time.Time) *Report {
+	d := w.m.getDetector()
+	r := newReport(KindLockWaitTimeout)
+
+	edge := r.addWaitEdge(w)
+	edge.WaitingMs = now.Sub(w.since).Milliseconds()
+	edge.Holders = make([]ReportHolder, 0)
+
+	// holders of the lock or the gate the routine waits for
+	ids := []int64{w.goID}
+	for _, h := range d.getWaitHolders(w) {
+		edge.Holders = append(edge.Holders, ReportHolder{
+			Goroutine: h.goID,
+			RLock:     h.rLock,
+			Acquired:  newCallSite(h.info),
+			HeldMs:    now.Sub(h.since).Milliseconds(),
+		})
+		ids = append(ids, h.goID)
+	}
+
+	// current call stacks of the waiting and the holding routines
+	stacks := getRoutineStacks(ids)
+	edge.CurrentStack = stacks[w.goID]
+	for i := range edge.Holders {
+		edge.Holders[i].CurrentStack = stacks[edge.Holders[i].Goroutine]
+	}
+
+	return r
+}
+
+// newReportScheduleTimeout creates the report for a body of RunSchedule which
+// did not finish within the schedule timeout. The edges are the routines
+// which wait for a lock, if the waits are tracked.
+//  Returns:
+//   (*Report): the created report
+func (d *Detector) newReportScheduleTimeout() *Report {
+	r := newReport(KindScheduleTimeout)
+	if !d.trackLockWaits() {
+		return r
+	}
+
+	d.lockWaitLock.Lock()
+	defer d.lockWaitLock.Unlock()
+
+	waits := make([]*lockWait, 0, len(d.lockWaits))
+	for _, w := range d.lockWaits {
+		waits = append(waits, w)
+	}
+	sort.Slice(waits, func(i, j int) bool {
+		return waits[i].goID < waits[j].goID
+	})
+
+	ids := make([]int64, 0, len(waits))
+	for _, w := range waits {
+		ids = append(ids, w.goID)
+	}
+	stacks := getRoutineStacks(ids)
+	for _, w := range waits {
+		edge := r.addWaitEdge(w)
+		edge.CurrentStack = stacks[w.goID]
+	}
+	return r
+}
+
+// newReportDoubleLocking creates the report for double locking
+//  Args:
+//   m (mutexInt): lock on which double locking was detected
+//   r (*routine): routine which tried to acquire m a second time
+//   rLock (bool): true if the second acquisition was a r-lock
+//   info (callerInfo): position of the second acquisition
+//  Returns:
+//   (*Report): the created report
+func newReportDoubleLocking(m mutexInt, r *routine, rLock bool,
+	info callerInfo) *Report {
+	rep := newReport(KindDoubleLocking)
+	r.lock.Lock()
+	rep.addEdge(r, m, rLock, info, r.holdingSet[:r.holdingCount], r.holdingInfo)
+	r.lock.Unlock()
+	rep.Signature = rep.signature()
+	return rep
+}
+
+// addEdge adds an edge and all locks referenced by it to the report
+//  Args:
+//   rt (*routine): routine which created the edge
+//   m (mutexInt): lock which was acquired
+//   rLock (bool): true if m was acquired as r-lock
+//   acquired (callerInfo): position where m was acquired
+//   holding ([]mutexInt): locks which were held while m was acquired
+//   holdingInfo ([]callerInfo): positions where the locks in holding were acquired
+//  Returns:
+//   nil
+func (r *Report) addEdge(rt *routine, m mutexInt, rLock bool,
+	acquired callerInfo, holding []mutexInt, holdingInfo []callerInfo) {
+	edge := ReportEdge{
+		Routine:   rt.index,
+		Goroutine: rt.goID,
+		Lock:      r.addLock(m),
+		RLock:     rLock,
+		Acquired:  newCallSite(acquired),
+		Holding:   make([]ReportHeldLock, 0, len(holding)),
+	}
+
+	if rt.createdBy.file != "" {
+		createdBy := newCallSite(rt.createdBy)
+		edge.CreatedBy = &createdBy
+	}
+
+	for i, h := range holding {
+		edge.Holding = append(edge.Holding, ReportHeldLock{
+			Lock:     r.addLock(h),
+			RLock:    h.getRLock(rt.goID),
+			Acquired: newCallSite(holdingInfo[i]),
+		})
+	}
+
+	r.Edges = append(r.Edges, edge)
+}
+
+// addWaitEdge adds the edge for a routine waiting for a lock and all locks
+// referenced by it to the report. The locks held by the routine and the
+// holders of the lock are taken from the tracked holders, so lockWaitLock
+// must be held.
+//  Args:
+//   w (*lockWait): the wait
+//  Returns:
+//   (*ReportEdge): the added edge
+func (r *Report) addWaitEdge(w *lockWait) *ReportEdge {
+	edge := ReportEdge{
+		Routine:   -1,
+		Goroutine: w.goID,
+		Lock:      r.addLock(w.m),
+		RLock:     w.rLock,
+		Acquired:  newCallSite(w.info),
+		Holding:   make([]ReportHeldLock, 0),
+	}
+
+	// the routine index and the creation of the routine are only known if
+	// the routine is tracked by the detection
+	d := w.m.getDetector()
+	d.createRoutineLock.RLock()
+	index, ok := d.mapIndex[w.goID]
+	var rt *routine
+	if ok {
+		rt = d.routines[index]
+	}
+	d.createRoutineLock.RUnlock()
+	if ok {
+		edge.Routine = index
+		if created := rt.createdBy; created.file != "" {
+			createdBy := newCallSite(created)
+			edge.CreatedBy = &createdBy
+		}
+	}
+
+	// locks held by the waiting routine in the order of their acquisition
+	held := d.getLockHoldsOf(w.goID)
+	sort.Slice(held, func(i, j int) bool {
+		return held[i].since.Before(held[j].since)
+	})
+	for _, h := range held {
+		edge.Holding = append(edge.Holding, ReportHeldLock{
+			Lock:     r.addLock(h.m),
+			RLock:    h.rLock,
+			Acquired: newCallSite(h.info),
+		})
+	}
+
+	// routines holding the lock
+	for _, h := range d.getWaitHolders(w) {
+		edge.HeldBy = append(edge.HeldBy, h.goID)
+	}
+
+	r.Edges = append(r.Edges, edge)
+	return &r.Edges[len(r.Edges)-1]
+}
+
+// addLock adds a lock to the report if it is not already part of it
+//  Args:
+//   m (mutexInt): lock to add
+//  Returns:
+//   (string): id of the lock
+func (r *Report) addLock(m mutexInt) string {
+	id := lockID(m)
+	for _, l := range r.Locks {
+		if l.ID == id {
+			return id
+		}
+	}
+
+	r.Locks = append(r.Locks, newReportLock(m))
+	return id
+}
+
+// newReportLock creates the description of a lock
+//  Args:
+//   m (mutexInt): the lock
+//  Returns:
+//   (ReportLock): the description with id, type and position of the creation
+func newReportLock(m mutexInt) ReportLock {
+	lock := ReportLock{
+		ID:   lockID(m),
+		Type: "RWMutex",
+	}
+	if isMutex, _, _ := m.getLock(); isMutex {
+		lock.Type = "Mutex"
+	}
+	for _, c := range *m.getContext() {
+		if c.create {
+			lock.Created = CallSite{File: c.file, Line: c.line}
+			break
+		}
+	}
+	return lock
+}
+
+// newCallSite converts a callerInfo into a CallSite
+//  Args:
+//   c (callerInfo): the caller info
+//  Returns:
+//   (CallSite): the call site
+func newCallSite(c callerInfo) CallSite {
+	return CallSite{
+		Function: c.function,
+		File:     c.file,
+		Line:     c.line,
+		Stack:    c.callStacks,
+	}
+}
+
+// lockID returns the identity of a lock as used in the reports
+//  Args:
+//   m (mutexInt): the lock
+//  Returns:
+//   (string): the identity of m
+func lockID(m mutexInt) string {
+	return fmt.Sprintf("%#x", m.getMemoryPosition())
+}
@@ -0,0 +1,519 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+reportHTML.go
+This file implements the output of the reports as a single self-contained
+HTML file. The file contains all findings with collapsible call stacks and
+source snippets, a rendering of the lock graph and filters for packages and
+locks. It does not need any external assets.
+The reports are collected and the file is rewritten every time the output is
+flushed.
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// name of the environment variable to set the HTML output file
+const envHTMLOutput = "DEADLOCK_GO_HTML"
+
+// number of lines shown before and after a call site in a source snippet
+const htmlSnippetContext = 3
+
+// output which collects the reports and writes them as HTML file
+type htmlOutput struct {
+	// detector whose lock graph is shown
+	detector *Detector
+	// path of the output file
+	path string
+	// collected reports
+	reports []*Report
+	// lock to prevent concurrent access to reports
+	lock sync.Mutex
+}
+
+// ============ template data ============
+
+// data of the HTML page
+type htmlPage struct {
+	Findings []htmlFinding
+	Packages []string
+	Locks    []htmlLockOption
+	Graph    htmlGraph
+}
+
+// a single finding
+type htmlFinding struct {
+	Index     int
+	Kind      string
+	Count     int
+	Seen      int
+	Confirmed bool
+	Locks     []ReportLock
+	Edges     []htmlEdge
+	Packages  string
+	LockIDs   string
+}
+
+// an edge of a finding
+type htmlEdge struct {
+	Routine   int
+	Goroutine int64
+	CreatedBy string
+	Lock      string
+	Mode      string
+	Holding   []string
+	Calls     []htmlCall
+}
+
+// a call site with the source snippet
+type htmlCall struct {
+	Description string
+	Site        string
+	Stack       string
+	Snippet     []htmlSnippetLine
+}
+
+// a line of a source snippet
+type htmlSnippetLine struct {
+	Number  int
+	Text    string
+	Current bool
+}
+
+// entry of the lock filter
+type htmlLockOption struct {
+	ID   string
+	Name string
+}
+
+// rendering of the lock graph
+type htmlGraph struct {
+	Width  int
+	Height int
+	Nodes  []htmlGraphNode
+	Edges  []htmlGraphEdge
+}
+
+// node of the rendered lock graph
+type htmlGraphNode struct {
+	X, Y int
+	Name string
+}
+
+// edge of the rendered lock graph
+type htmlGraphEdge struct {
+	X1, Y1, X2, Y2 int
+	Label          string
+	InCycle        bool
+}
+
+// ============ output ============
+
+// newHTMLOutput creates the HTML output according to the options and the
+// environment. A file set with SetHTMLOutputFile has precedence over the
+// environment variable.
+//  Returns:
+//   (*htmlOutput): the output or nil if the HTML output is disabled
+func (d *Detector) newHTMLOutput() *htmlOutput {
+	path := d.outputPath(d.opts.htmlOutputFile, envHTMLOutput)
+	if path == "" {
+		return nil
+	}
+	return &htmlOutput{detector: d, path: path, reports: make([]*Report, 0)}
+}
+
+// write stores a report
+//  Args:
+//   r (*Report): report to write
+//  Returns:
+//   nil
+func (o *htmlOutput) write(r *Report) {
+	o.lock.Lock()
+	o.reports = append(o.reports, r)
+	o.lock.Unlock()
+}
+
+// flush writes all collected reports into the output file
+//  Returns:
+//   nil
+func (o *htmlOutput) flush() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	page := htmlPage{
+		Findings: make([]htmlFinding, 0, len(o.reports)),
+		Graph:    newHTMLGraph(o.detector.buildLockGraph()),
+	}
+
+	snippets := newSnippetCache()
+	packages := make(map[string]struct{})
+	locks := make(map[string]string)
+
+	for i, r := range o.reports {
+		finding := newHTMLFinding(i+1, r, snippets)
+		page.Findings = append(page.Findings, finding)
+		for _, p := range strings.Fields(finding.Packages) {
+			packages[p] = struct{}{}
+		}
+		for _, l := range r.Locks {
+			locks[l.ID] = lockName(l)
+		}
+	}
+
+	for p := range packages {
+		page.Packages = append(page.Packages, p)
+	}
+	sort.Strings(page.Packages)
+	for id, name := range locks {
+		page.Locks = append(page.Locks, htmlLockOption{ID: id, Name: name})
+	}
+	sort.Slice(page.Locks, func(i, j int) bool {
+		return page.Locks[i].Name < page.Locks[j].Name
+	})
+
+	out := openOutputFile(o.path, false)
+	if out == nil {
+		return
+	}
+	if err := htmlTemplate.Execute(out, page); err != nil {
+		fmt.Fprintln(os.Stderr, "Deadlock-Go: could not write HTML output:", err)
+	}
+	if file, ok := out.(*os.File); ok && file != os.Stderr {
+		file.Close()
+	}
+}
+
+// the file is closed after every flush, so there is nothing to close
+func (o *htmlOutput) close() {}
+
+// newHTMLFinding converts a report into the data of a finding
+//  Args:
+//   index (int): number of the finding
+//   r (*Report): the report
+//   snippets (*snippetCache): cache for the source files
+//  Returns:
+//   (htmlFinding): the finding
+func newHTMLFinding(index int, r *Report, snippets *snippetCache) htmlFinding {
+	finding := htmlFinding{
+		Index:     index,
+		Kind:      string(r.Kind),
+		Count:     r.Count,
+		Seen:      r.SeenBefore,
+		Confirmed: r.Confirmed,
+		Locks:     r.Locks,
+		Edges:     make([]htmlEdge, 0, len(r.Edges)),
+	}
+
+	packages := make(map[string]struct{})
+	lockIDs := make([]string, 0, len(r.Locks))
+	for _, l := range r.Locks {
+		lockIDs = append(lockIDs, l.ID)
+		packages[filepath.Dir(l.Created.File)] = struct{}{}
+	}
+
+	for _, e := range r.Edges {
+		edge := htmlEdge{
+			Routine:   e.Routine,
+			Goroutine: e.Goroutine,
+			Lock:      lockName(r.lock(e.Lock)),
+			Mode:      "Lock",
+			Holding:   make([]string, 0, len(e.Holding)),
+			Calls:     make([]htmlCall, 0, len(e.Holding)+1),
+		}
+		if e.RLock {
+			edge.Mode = "RLock"
+		}
+		if e.CreatedBy != nil {
+			edge.CreatedBy = fmt.Sprintf("%s at %s:%d", e.CreatedBy.Function,
+				e.CreatedBy.File, e.CreatedBy.Line)
+		}
+		for _, h := range e.Holding {
+			edge.Holding = append(edge.Holding, lockName(r.lock(h.Lock)))
+		}
+		for _, h := range e.Holding {
+			edge.Calls = append(edge.Calls, newHTMLCall("holding "+lockName(r.lock(h.Lock)),
+				h.Acquired, snippets))
+			packages[filepath.Dir(h.Acquired.File)] = struct{}{}
+		}
+		edge.Calls = append(edge.Calls, newHTMLCall("acquiring "+edge.Lock, e.Acquired,
+			snippets))
+		packages[filepath.Dir(e.Acquired.File)] = struct{}{}
+		for _, h := range e.Holders {
+			edge.Calls = append(edge.Calls, newHTMLCall(fmt.Sprintf("held by goroutine %d for %v",
+				h.Goroutine, time.Duration(h.HeldMs)*time.Millisecond), h.Acquired, snippets))
+		}
+		finding.Edges = append(finding.Edges, edge)
+	}
+
+	pkgs := make([]string, 0, len(packages))
+	for p := range packages {
+		pkgs = append(pkgs, p)
+	}
+	sort.Strings(pkgs)
+	finding.Packages = strings.Join(pkgs, " ")
+	finding.LockIDs = strings.Join(lockIDs, " ")
+
+	return finding
+}
+
+// newHTMLCall creates the data of a call site
+//  Args:
+//   description (string): description of the call
+//   c (CallSite): the call site
+//   snippets (*snippetCache): cache for the source files
+//  Returns:
+//   (htmlCall): the call
+func newHTMLCall(description string, c CallSite, snippets *snippetCache) htmlCall {
+	return htmlCall{
+		Description: description,
+		Site:        fmt.Sprintf("%s:%d", c.File, c.Line),
+		Stack:       c.Stack,
+		Snippet:     snippets.snippet(c.File, c.Line),
+	}
+}
+
+// lockName returns a readable name of a lock
+//  Args:
+//   l (ReportLock): the lock
+//  Returns:
+//   (string): name of the lock
+func lockName(l ReportLock) string {
+	if l.Created.File == "" {
+		return l.ID
+	}
+	return fmt.Sprintf("%s:%d", filepath.Base(l.Created.File), l.Created.Line)
+}
+
+// newHTMLGraph lays out the lock graph. The nodes are placed on a circle.
+//  Args:
+//   g (*lockGraph): the lock graph
+//  Returns:
+//   (htmlGraph): rendering of the graph
+func newHTMLGraph(g *lockGraph) htmlGraph {
+	keys := g.sortedNodes()
+	radius := math.Max(120, float64(len(keys))*40)
+	center := int(radius) + 100
+
+	graph := htmlGraph{
+		Width:  2 * center,
+		Height: 2 * center,
+		Nodes:  make([]htmlGraphNode, 0, len(keys)),
+		Edges:  make([]htmlGraphEdge, 0, len(g.edges)),
+	}
+
+	pos := make(map[uintptr][2]float64)
+	for i, key := range keys {
+		angle := 2 * math.Pi * float64(i) / float64(len(keys))
+		x := float64(center) + radius*math.Cos(angle)
+		y := float64(center) + radius*math.Sin(angle)
+		pos[key] = [2]float64{x, y}
+		graph.Nodes = append(graph.Nodes, htmlGraphNode{
+			X: int(x), Y: int(y), Name: g.nodes[key].name,
+		})
+	}
+
+	for _, e := range g.sortedEdges() {
+		from, to := pos[e.from], pos[e.to]
+
+		// shorten the edge so that the arrow ends at the border of the node
+		dx, dy := to[0]-from[0], to[1]-from[1]
+		length := math.Hypot(dx, dy)
+		if length == 0 {
+			continue
+		}
+		shorten := math.Min(30, length/3)
+		graph.Edges = append(graph.Edges, htmlGraphEdge{
+			X1:      int(from[0] + dx/length*shorten),
+			Y1:      int(from[1] + dy/length*shorten),
+			X2:      int(to[0] - dx/length*shorten),
+			Y2:      int(to[1] - dy/length*shorten),
+			Label:   strings.Join(e.sortedLabels(), "\n"),
+			InCycle: e.inCycle,
+		})
+	}
+
+	return graph
+}
+
+// ============ source snippets ============
+
+// cache for the lines of source files
+type snippetCache struct {
+	files map[string][]string
+}
+
+// newSnippetCache creates a new cache for source files
+//  Returns:
+//   (*snippetCache): the cache
+func newSnippetCache() *snippetCache {
+	return &snippetCache{files: make(map[string][]string)}
+}
+
+// snippet returns the lines around a line in a source file
+//  Args:
+//   file (string): path of the file
+//   line (int): line in the file
+//  Returns:
+//   ([]htmlSnippetLine): the lines, nil if the file could not be read
+func (c *snippetCache) snippet(file string, line int) []htmlSnippetLine {
+	lines, ok := c.files[file]
+	if !ok {
+		lines = readLines(file)
+		c.files[file] = lines
+	}
+	if lines == nil || line < 1 || line > len(lines) {
+		return nil
+	}
+
+	start := line - htmlSnippetContext
+	if start < 1 {
+		start = 1
+	}
+	end := line + htmlSnippetContext
+	if end > len(lines) {
+		end = len(lines)
+	}
+
+	snippet := make([]htmlSnippetLine, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		snippet = append(snippet, htmlSnippetLine{
+			Number:  i,
+			Text:    lines[i-1],
+			Current: i == line,
+		})
+	}
+	return snippet
+}
+
+// readLines reads all lines of a file
+//  Args:
+//   file (string): path of the file
+//  Returns:
+//   ([]string): the lines, nil if the file could not be read
+func readLines(file string) []string {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+// ============ template ============
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Deadlock-Go Report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.5em; }
+.finding { border: 1px solid #ccc; border-radius: 4px; padding: 0.5em 1em; margin-bottom: 1em; }
+.potential-deadlock h2 { color: #a0522d; }
+.double-locking h2, .deadlock h2 { color: #b22222; }
+.lock-wait-timeout h2 { color: #b8860b; }
+h2 { font-size: 1.2em; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ddd; padding: 2px 8px; text-align: left; font-family: monospace; }
+pre { background: #f6f6f6; padding: 0.5em; overflow-x: auto; }
+.current { background: #ffe08a; }
+.lineno { color: #888; user-select: none; }
+.filters { margin-bottom: 1em; }
+.graph text { font-size: 12px; font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>Deadlock-Go Report</h1>
+<p>{{len .Findings}} finding(s)</p>
+
+<h2>Lock Graph</h2>
+<svg class="graph" width="{{.Graph.Width}}" height="{{.Graph.Height}}" xmlns="http://www.w3.org/2000/svg">
+<defs>
+<marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="8" markerHeight="8" orient="auto"><path d="M 0 0 L 10 5 L 0 10 z" fill="#555"/></marker>
+<marker id="arrow-cycle" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="8" markerHeight="8" orient="auto"><path d="M 0 0 L 10 5 L 0 10 z" fill="red"/></marker>
+</defs>
+{{range .Graph.Edges}}<line x1="{{.X1}}" y1="{{.Y1}}" x2="{{.X2}}" y2="{{.Y2}}" {{if .InCycle}}stroke="red" stroke-width="2" marker-end="url(#arrow-cycle)"{{else}}stroke="#555" marker-end="url(#arrow)"{{end}}><title>{{.Label}}</title></line>
+{{end}}{{range .Graph.Nodes}}<circle cx="{{.X}}" cy="{{.Y}}" r="6" fill="#4682b4"/><text x="{{.X}}" y="{{.Y}}" dx="10" dy="-10">{{.Name}}</text>
+{{end}}</svg>
+
+<h2>Findings</h2>
+<div class="filters">
+<label>Package <select id="filter-package" onchange="filter()"><option value="">all</option>{{range .Packages}}<option>{{.}}</option>{{end}}</select></label>
+<label>Lock <select id="filter-lock" onchange="filter()"><option value="">all</option>{{range .Locks}}<option value="{{.ID}}">{{.Name}}</option>{{end}}</select></label>
+</div>
+
+{{range .Findings}}<div class="finding {{.Kind}}" data-packages="{{.Packages}}" data-locks="{{.LockIDs}}">
+<h2>#{{.Index}} {{.Kind}}</h2>
+<p>Found in {{.Count}} combination(s) of routines{{if .Seen}}, seen before {{.Seen}} times{{end}}{{if .Confirmed}}, confirmed by schedule perturbation{{end}}</p>
+<table>
+<tr><th>Lock</th><th>Type</th><th>Created</th></tr>
+{{range .Locks}}<tr><td>{{.ID}}</td><td>{{.Type}}</td><td>{{.Created.File}}:{{.Created.Line}}</td></tr>
+{{end}}</table>
+{{range .Edges}}<h3>Routine {{.Routine}} (goroutine {{.Goroutine}}{{if .CreatedBy}}, created by {{.CreatedBy}}{{end}}): {{.Mode}} {{.Lock}}{{if .Holding}} while holding {{range $i, $h := .Holding}}{{if $i}}, {{end}}{{$h}}{{end}}{{end}}</h3>
+{{range .Calls}}<details>
+<summary>{{.Description}} at {{.Site}}</summary>
+{{if .Snippet}}<pre>{{range .Snippet}}<span class="lineno">{{printf "%5d" .Number}}</span> {{if .Current}}<span class="current">{{.Text}}</span>{{else}}{{.Text}}{{end}}
+{{end}}</pre>{{end}}
+{{if .Stack}}<pre>{{.Stack}}</pre>{{end}}
+</details>
+{{end}}{{end}}</div>
+{{end}}
+
+<script>
+function filter() {
+	var pkg = document.getElementById("filter-package").value;
+	var lock = document.getElementById("filter-lock").value;
+	var findings = document.getElementsByClassName("finding");
+	for (var i = 0; i < findings.length; i++) {
+		var f = findings[i];
+		var show = (pkg === "" || f.dataset.packages.split(" ").indexOf(pkg) >= 0) &&
+			(lock === "" || f.dataset.locks.split(" ").indexOf(lock) >= 0);
+		f.style.display = show ? "" : "none";
+	}
+}
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,95 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+reportHTML_test.go
+Tests for the HTML output.
+*/
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// The HTML file contains the findings without referencing external assets
+// and escapes the content of the reports.
+func TestHTMLOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.html")
+	d := NewDetector()
+	d.SetPeriodicDetection(false)
+	d.SetHTMLOutputFile(path)
+
+	traceInversion(d)
+	d.FindPotentialDeadlocks()
+
+	// a report whose fields contain markup
+	injected := `<script>alert("x")</script>`
+	d.writeReport(&Report{
+		SchemaVersion: ReportSchemaVersion,
+		Kind:          KindDoubleLocking,
+		Locks: []ReportLock{{
+			ID:      `0x1"><i>`,
+			Type:    "Mutex",
+			Created: CallSite{File: `/tmp/<b>.go`, Line: 1},
+		}},
+		Edges: []ReportEdge{{
+			Lock:     `0x1"><i>`,
+			Acquired: CallSite{File: `/tmp/<b>.go`, Line: 2, Stack: injected},
+		}},
+		Count: 1,
+	})
+	d.Close()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(content)
+
+	if !strings.Contains(page, string(KindPotentialDeadlock)) {
+		t.Error("the potential deadlock is missing in the HTML output")
+	}
+	if strings.Contains(page, injected) || strings.Contains(page, `"><i>`) ||
+		strings.Contains(page, "<b>.go") {
+		t.Error("the fields of the report are not escaped")
+	}
+	if !strings.Contains(page, "&lt;script&gt;") {
+		t.Error("the escaped stack is missing in the HTML output")
+	}
+
+	// only references into the document itself are allowed
+	refs := regexp.MustCompile(`(?i)(?:\bsrc|\bhref)\s*=\s*["']?([^"'\s>]*)|url\(([^)]*)\)|<link\b|@import`)
+	for _, match := range refs.FindAllStringSubmatch(page, -1) {
+		target := match[1] + match[2]
+		if !strings.HasPrefix(target, "#") {
+			t.Errorf("the HTML output references an external asset: %s", match[0])
+		}
+	}
+}
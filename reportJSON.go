@@ -0,0 +1,116 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+reportJSON.go
+This file implements the JSON output of the reports. Every report is written
+as one JSON object per line. A file is opened in append mode, so that
+multiple detectors or processes can write into the same file.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// name of the environment variable to set the JSON output file
+const envJSONOutput = "DEADLOCK_GO_JSON"
+
+// output which writes every report as one line of JSON
+type jsonOutput struct {
+	// writer the reports are written to, nil after the output was closed
+	w io.Writer
+	// file opened by the output, nil if the output writes to a writer set
+	// with SetJSONOutput or to stderr
+	file *os.File
+	// lock to prevent concurrent writes to w
+	lock sync.Mutex
+}
+
+// newJSONOutput creates the JSON output according to the options and the
+// environment. A writer set with SetJSONOutput has precedence over a file set
+// with SetJSONOutputFile, which has precedence over the environment variable.
+//  Returns:
+//   (*jsonOutput): the output or nil if the JSON output is disabled
+func (d *Detector) newJSONOutput() *jsonOutput {
+	if d.opts.jsonOutput != nil {
+		return &jsonOutput{w: d.opts.jsonOutput}
+	}
+
+	path := d.outputPath(d.opts.jsonOutputFile, envJSONOutput)
+	if path == "" {
+		return nil
+	}
+	w := openOutputFile(path, true)
+	if w == nil {
+		return nil
+	}
+	o := &jsonOutput{w: w}
+	if file, ok := w.(*os.File); ok && file != os.Stderr {
+		o.file = file
+	}
+	return o
+}
+
+// write writes a report as a single line
+//  Args:
+//   r (*Report): report to write
+//  Returns:
+//   nil
+func (o *jsonOutput) write(r *Report) {
+	line, err := json.Marshal(r)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Deadlock-Go: could not encode report:", err)
+		return
+	}
+
+	o.lock.Lock()
+	if o.w != nil {
+		o.w.Write(append(line, '\n'))
+	}
+	o.lock.Unlock()
+}
+
+// the reports are written immediately, so there is nothing to flush
+func (o *jsonOutput) flush() {}
+
+// close closes the output file. A writer set with SetJSONOutput is not
+// closed. Reports written afterwards are discarded.
+//  Returns:
+//   nil
+func (o *jsonOutput) close() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.file != nil {
+		o.file.Close()
+	}
+	o.w = nil
+}
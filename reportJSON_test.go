@@ -0,0 +1,92 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+reportJSON_test.go
+Tests for the JSON output.
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Detectors which write into the same file append their reports instead of
+// overwriting the reports of each other. The file is closed with the
+// detector.
+func TestJSONOutputFileAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reports.jsonl")
+	if err := os.WriteFile(path, []byte("{\"kind\":\"earlier-run\"}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	first := NewDetector()
+	first.SetJSONOutputFile(path)
+	second := NewDetector()
+	second.SetJSONOutputFile(path)
+
+	traceInversion(first)
+	traceInversion(second)
+	first.FindPotentialDeadlocks()
+	second.FindPotentialDeadlocks()
+	first.Close()
+	second.Close()
+
+	for _, d := range []*Detector{first, second} {
+		if o := d.reportOutputs[0].(*jsonOutput); o.w != nil {
+			t.Fatal("the JSON output was not closed")
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	kinds := make([]ReportKind, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var r Report
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		kinds = append(kinds, r.Kind)
+	}
+	expected := []ReportKind{"earlier-run", KindPotentialDeadlock, KindPotentialDeadlock}
+	if len(kinds) != len(expected) {
+		t.Fatalf("expected the reports %v, got %v", expected, kinds)
+	}
+	for i := range kinds {
+		if kinds[i] != expected[i] {
+			t.Fatalf("expected the reports %v, got %v", expected, kinds)
+		}
+	}
+}
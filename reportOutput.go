@@ -0,0 +1,141 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+reportOutput.go
+This file implements the handling of the machine readable outputs of the
+reports. Every output receives all reports, is flushed after a detection
+has finished and closed with the detector.
+*/
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// interface for all machine readable outputs of the reports
+type reportOutput interface {
+	// write is called for every report
+	write(r *Report)
+	// flush is called after a detection has finished
+	flush()
+	// close is called when the detector is closed
+	close()
+}
+
+// initializeReportOutputs creates the outputs which are enabled by the options
+// or the environment
+//  Returns:
+//   nil
+func (d *Detector) initializeReportOutputs() {
+	if out := d.newJSONOutput(); out != nil {
+		d.reportOutputs = append(d.reportOutputs, out)
+	}
+	if out := d.newSARIFOutput(); out != nil {
+		d.reportOutputs = append(d.reportOutputs, out)
+	}
+	if out := d.newHTMLOutput(); out != nil {
+		d.reportOutputs = append(d.reportOutputs, out)
+	}
+}
+
+// writeReport passes a report to all outputs
+//  Args:
+//   r (*Report): the report
+//  Returns:
+//   nil
+func (d *Detector) writeReport(r *Report) {
+	for _, out := range d.reportOutputs {
+		out.write(r)
+	}
+}
+
+// flushReports flushes all outputs
+//  Returns:
+//   nil
+func (d *Detector) flushReports() {
+	for _, out := range d.reportOutputs {
+		out.flush()
+	}
+	d.flushTrace()
+}
+
+// closeReports closes all outputs
+//  Returns:
+//   nil
+func (d *Detector) closeReports() {
+	for _, out := range d.reportOutputs {
+		out.close()
+	}
+}
+
+// outputPath returns the path of an output file. A path set in the
+// options has precedence over the environment variable. The environment
+// variables only apply to the default detector, so that the detectors
+// created with NewDetector do not overwrite the same file.
+//  Args:
+//   path (string): path set in the options
+//   env (string): name of the environment variable
+//  Returns:
+//   (string): path of the output file, "" if the output is disabled
+func (d *Detector) outputPath(path string, env string) string {
+	if path != "" {
+		return path
+	}
+	if d != defaultDetector {
+		return ""
+	}
+	return os.Getenv(env)
+}
+
+// openOutputFile opens a file for a report output.
+// The path "-" is used for stderr.
+//  Args:
+//   path (string): path of the file
+//   appendOutput (bool): if set to true, the output is appended to the file,
+//    otherwise the file is truncated
+//  Returns:
+//   (io.Writer): the opened file or nil if the file could not be opened
+func openOutputFile(path string, appendOutput bool) io.Writer {
+	if path == "-" {
+		return os.Stderr
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendOutput {
+		// writes of other detectors or processes into the same file are
+		// not overwritten
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Deadlock-Go: could not open output file:", err)
+		return nil
+	}
+	return file
+}
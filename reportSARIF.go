@@ -0,0 +1,393 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+reportSARIF.go
+This file implements the output of the reports in the SARIF 2.1.0 format,
+which can be used by code-scanning tools. Each finding is a result. The
+acquisitions of the locks are added as a code flow, with one thread flow
+per edge of the cycle.
+Because a SARIF file is a single document, the reports are collected and the
+whole file is rewritten every time the output is flushed with new reports.
+On stderr, every flush writes a document with the new reports.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// name of the environment variable to set the SARIF output file
+const envSARIFOutput = "DEADLOCK_GO_SARIF"
+
+// name of the environment variable to set the source root of the SARIF output
+const envSARIFRoot = "DEADLOCK_GO_SARIF_ROOT"
+
+// base id for uris relative to the source root
+const sarifSrcRoot = "%SRCROOT%"
+
+// ============ SARIF document ============
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool               sarifTool                        `json:"tool"`
+	OriginalURIBaseIDs map[string]sarifArtifactLocation `json:"originalUriBaseIds,omitempty"`
+	Results            []sarifResult                    `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+	CodeFlows []sarifCodeFlow `json:"codeFlows,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	Message          *sarifMessage         `json:"message,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+type sarifCodeFlow struct {
+	Message     sarifMessage      `json:"message"`
+	ThreadFlows []sarifThreadFlow `json:"threadFlows"`
+}
+
+type sarifThreadFlow struct {
+	Message   sarifMessage              `json:"message"`
+	Locations []sarifThreadFlowLocation `json:"locations"`
+}
+
+type sarifThreadFlowLocation struct {
+	Location sarifLocation `json:"location"`
+}
+
+// ============ output ============
+
+// output which collects the reports and writes them as a SARIF file
+type sarifOutput struct {
+	// path of the output file
+	path string
+	// source root, used to create relative uris
+	root string
+	// collected results
+	results []sarifResult
+	// number of results which have already been written
+	written int
+	// lock to prevent concurrent access to results and written
+	lock sync.Mutex
+}
+
+// newSARIFOutput creates the SARIF output according to the options and the
+// environment. A file set with SetSARIFOutputFile has precedence over the
+// environment variable.
+//  Returns:
+//   (*sarifOutput): the output or nil if the SARIF output is disabled
+func (d *Detector) newSARIFOutput() *sarifOutput {
+	path := d.outputPath(d.opts.sarifOutputFile, envSARIFOutput)
+	if path == "" {
+		return nil
+	}
+
+	// the root of the module and not the working directory is used, because
+	// the working directory of a test is the directory of its package
+	root := os.Getenv(envSARIFRoot)
+	if root == "" {
+		wd, _ := os.Getwd()
+		root = moduleRoot(wd)
+	}
+
+	return &sarifOutput{
+		path:    path,
+		root:    root,
+		results: make([]sarifResult, 0),
+	}
+}
+
+// moduleRoot returns the root of the module which contains a directory, i.e.
+// the next directory upwards which contains a go.mod file
+//  Args:
+//   dir (string): the directory
+//  Returns:
+//   (string): the root of the module, dir if it is not inside a module
+func moduleRoot(dir string) string {
+	for current := dir; current != ""; {
+		if _, err := os.Stat(filepath.Join(current, "go.mod")); err == nil {
+			return current
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			break
+		}
+		current = parent
+	}
+	return dir
+}
+
+// write converts a report into a SARIF result and stores it
+//  Args:
+//   r (*Report): report to write
+//  Returns:
+//   nil
+func (o *sarifOutput) write(r *Report) {
+	result := o.newResult(r)
+	o.lock.Lock()
+	o.results = append(o.results, result)
+	o.lock.Unlock()
+}
+
+// flush writes all collected results into the output file, if there are
+// new results. On stderr, only the new results are written.
+//  Returns:
+//   nil
+func (o *sarifOutput) flush() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.written == len(o.results) {
+		return
+	}
+	results := o.results
+	if o.path == "-" {
+		results = o.results[o.written:]
+	}
+	o.written = len(o.results)
+
+	run := sarifRun{
+		Tool: sarifTool{
+			Driver: sarifDriver{
+				Name:           "Deadlock-Go",
+				InformationURI: "https://github.com/ErikKassubek/Deadlock-Go",
+				Rules: []sarifRule{
+					{
+						ID:               string(KindPotentialDeadlock),
+						ShortDescription: sarifMessage{Text: "Potential deadlock caused by cyclic locking"},
+					},
+					{
+						ID:               string(KindDoubleLocking),
+						ShortDescription: sarifMessage{Text: "Deadlock caused by double locking"},
+					},
+					{
+						ID:               string(KindDeadlock),
+						ShortDescription: sarifMessage{Text: "Deadlock caused by cyclic locking"},
+					},
+					{
+						ID:               string(KindLockWaitTimeout),
+						ShortDescription: sarifMessage{Text: "Routine waited longer than the lock wait timeout for a lock"},
+					},
+				},
+			},
+		},
+		Results: results,
+	}
+	if o.root != "" {
+		run.OriginalURIBaseIDs = map[string]sarifArtifactLocation{
+			sarifSrcRoot: {URI: fileURI(o.root) + "/"},
+		}
+	}
+
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+
+	data, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Deadlock-Go: could not encode SARIF output:", err)
+		return
+	}
+
+	out := openOutputFile(o.path, false)
+	if out == nil {
+		return
+	}
+	out.Write(append(data, '\n'))
+	if file, ok := out.(*os.File); ok && file != os.Stderr {
+		file.Close()
+	}
+}
+
+// the file is closed after every flush, so there is nothing to close
+func (o *sarifOutput) close() {}
+
+// newResult converts a report into a SARIF result
+//  Args:
+//   r (*Report): the report
+//  Returns:
+//   (sarifResult): the result
+func (o *sarifOutput) newResult(r *Report) sarifResult {
+	result := sarifResult{
+		RuleID:    string(r.Kind),
+		Level:     "warning",
+		Locations: make([]sarifLocation, 0),
+	}
+	if r.Kind == KindDoubleLocking || r.Kind == KindDeadlock {
+		result.Level = "error"
+	}
+
+	// every edge of the report is a thread flow. The locations are the
+	// acquisitions of the held locks followed by the acquisition of the lock
+	// of the edge.
+	flow := sarifCodeFlow{
+		Message:     sarifMessage{Text: string(r.Kind)},
+		ThreadFlows: make([]sarifThreadFlow, 0, len(r.Edges)),
+	}
+	sites := make([]string, 0, len(r.Edges))
+	for _, edge := range r.Edges {
+		threadFlow := sarifThreadFlow{
+			Message: sarifMessage{Text: fmt.Sprintf("goroutine %d acquires lock created at %s",
+				edge.Goroutine, lockName(r.lock(edge.Lock)))},
+			Locations: make([]sarifThreadFlowLocation, 0, len(edge.Holding)+1),
+		}
+		for _, h := range edge.Holding {
+			threadFlow.Locations = append(threadFlow.Locations, sarifThreadFlowLocation{
+				Location: o.newLocation(h.Acquired, "acquisition of lock created at "+
+					lockName(r.lock(h.Lock))),
+			})
+		}
+		threadFlow.Locations = append(threadFlow.Locations, sarifThreadFlowLocation{
+			Location: o.newLocation(edge.Acquired, "acquisition of lock created at "+
+				lockName(r.lock(edge.Lock))),
+		})
+		flow.ThreadFlows = append(flow.ThreadFlows, threadFlow)
+
+		// the acquisition of the lock of the edge is the primary location
+		result.Locations = append(result.Locations, o.newLocation(edge.Acquired, ""))
+		sites = append(sites, fmt.Sprintf("%s:%d", filepath.Base(edge.Acquired.File),
+			edge.Acquired.Line))
+	}
+	result.CodeFlows = []sarifCodeFlow{flow}
+
+	if r.Kind == KindDoubleLocking {
+		result.Message.Text = "double locking at " + strings.Join(sites, ", ")
+	} else if r.Kind == KindDeadlock {
+		result.Message.Text = "deadlock between " + strings.Join(sites, " and ")
+	} else if r.Kind == KindLockWaitTimeout {
+		result.Message.Text = "lock wait timeout at " + strings.Join(sites, ", ")
+	} else {
+		result.Message.Text = "lock order inversion between " +
+			strings.Join(sites, " and ")
+	}
+
+	return result
+}
+
+// newLocation creates a SARIF location from a call site
+//  Args:
+//   c (CallSite): the call site
+//   message (string): message for the location, "" for no message
+//  Returns:
+//   (sarifLocation): the location
+func (o *sarifOutput) newLocation(c CallSite, message string) sarifLocation {
+	loc := sarifLocation{
+		PhysicalLocation: sarifPhysicalLocation{
+			ArtifactLocation: o.artifactLocation(c.File),
+		},
+	}
+	// the region is omitted if the line is unknown, SARIF requires lines
+	// starting at 1
+	if c.Line > 0 {
+		loc.PhysicalLocation.Region = &sarifRegion{StartLine: c.Line}
+	}
+	if message != "" {
+		loc.Message = &sarifMessage{Text: message}
+	}
+	return loc
+}
+
+// artifactLocation creates the location of a file. Files in the source root
+// are given relative to it, all other files as absolute uri.
+//  Args:
+//   file (string): path of the file
+//  Returns:
+//   (sarifArtifactLocation): location of the file
+func (o *sarifOutput) artifactLocation(file string) sarifArtifactLocation {
+	if o.root != "" {
+		if rel, err := filepath.Rel(o.root, file); err == nil &&
+			!strings.HasPrefix(rel, "..") {
+			return sarifArtifactLocation{
+				URI:       filepath.ToSlash(rel),
+				URIBaseID: sarifSrcRoot,
+			}
+		}
+	}
+	return sarifArtifactLocation{URI: fileURI(file)}
+}
+
+// fileURI converts an absolute path into a file uri
+//  Args:
+//   path (string): the path
+//  Returns:
+//   (string): the uri
+func fileURI(path string) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	return u.String()
+}
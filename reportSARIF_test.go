@@ -0,0 +1,194 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+reportSARIF_test.go
+Tests for the SARIF output.
+*/
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newSARIFTestReport creates a report of double locking for the SARIF tests
+//  Args:
+//   line (int): line of the acquisition
+//  Returns:
+//   (*Report): the report
+func newSARIFTestReport(line int) *Report {
+	r := newReport(KindDoubleLocking)
+	r.Locks = append(r.Locks, ReportLock{ID: "l", Created: CallSite{File: "/src/a.go", Line: 1}})
+	r.Edges = append(r.Edges, ReportEdge{
+		Lock:     "l",
+		Acquired: CallSite{File: "/src/a.go", Line: line},
+	})
+	return r
+}
+
+// A location without line has no region, because SARIF requires lines
+// starting at 1.
+func TestSARIFRegion(t *testing.T) {
+	o := &sarifOutput{}
+
+	data, err := json.Marshal(o.newLocation(CallSite{File: "/src/a.go"}, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "region") {
+		t.Fatalf("unexpected region: %s", data)
+	}
+
+	data, err = json.Marshal(o.newLocation(CallSite{File: "/src/a.go", Line: 7}, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"region":{"startLine":7}`) {
+		t.Fatalf("missing region: %s", data)
+	}
+}
+
+// The output on stderr contains every result once, a flush without new
+// results writes nothing.
+func TestSARIFStderr(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() {
+		os.Stderr = stderr
+	}()
+
+	o := &sarifOutput{path: "-"}
+	o.write(newSARIFTestReport(3))
+	o.flush()
+	o.flush()
+	o.write(newSARIFTestReport(4))
+	o.flush()
+
+	os.Stderr = stderr
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := json.NewDecoder(strings.NewReader(string(out)))
+	results := 0
+	documents := 0
+	for dec.More() {
+		var log sarifLog
+		if err := dec.Decode(&log); err != nil {
+			t.Fatal(err)
+		}
+		documents++
+		results += len(log.Runs[0].Results)
+	}
+	if documents != 2 || results != 2 {
+		t.Fatalf("expected 2 documents with 1 result each, got %d documents with %d results",
+			documents, results)
+	}
+}
+
+// The output file contains all results, also after multiple flushes.
+func TestSARIFFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.sarif")
+	o := &sarifOutput{path: path}
+	o.write(newSARIFTestReport(3))
+	o.flush()
+	o.write(newSARIFTestReport(4))
+	o.flush()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(log.Runs[0].Results); n != 2 {
+		t.Fatalf("expected 2 results, got %d", n)
+	}
+}
+
+// The environment variables of the outputs only apply to the default
+// detector.
+func TestOutputPathEnv(t *testing.T) {
+	t.Setenv(envSARIFOutput, filepath.Join(t.TempDir(), "out.sarif"))
+
+	if path := NewDetector().outputPath("", envSARIFOutput); path != "" {
+		t.Fatalf("the environment variable was used by another detector: %s", path)
+	}
+	if path := defaultDetector.outputPath("", envSARIFOutput); path == "" {
+		t.Fatal("the environment variable was not used by the default detector")
+	}
+	if path := NewDetector().outputPath("set.sarif", envSARIFOutput); path != "set.sarif" {
+		t.Fatalf("the path of the options was not used: %s", path)
+	}
+}
+
+// Files in a sibling package of the working directory are given relative to
+// the root of the module, like the files of the package itself.
+func TestSARIFModuleRoot(t *testing.T) {
+	root := t.TempDir()
+	pkg := filepath.Join(root, "a")
+	if err := os.MkdirAll(pkg, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "go.mod"), []byte("module example.com/m\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if found := moduleRoot(pkg); found != root {
+		t.Fatalf("expected the module root %s, got %s", root, found)
+	}
+
+	o := &sarifOutput{root: moduleRoot(pkg)}
+	for file, uri := range map[string]string{
+		filepath.Join(root, "a", "a.go"): "a/a.go",
+		filepath.Join(root, "b", "b.go"): "b/b.go",
+	} {
+		loc := o.artifactLocation(file)
+		if loc.URI != uri || loc.URIBaseID != sarifSrcRoot {
+			t.Errorf("expected %s relative to the source root, got %+v", uri, loc)
+		}
+	}
+
+	// the environment variable overrides the module root
+	t.Setenv(envSARIFOutput, filepath.Join(root, "out.sarif"))
+	t.Setenv(envSARIFRoot, pkg)
+	if o := defaultDetector.newSARIFOutput(); o == nil || o.root != pkg {
+		t.Fatalf("expected the source root %s, got %+v", pkg, o)
+	}
+}
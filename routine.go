@@ -1,3 +1,5 @@
+//go:build !deadlock_off
+
 package deadlock
 
 /*
@@ -31,28 +33,19 @@ to update these trees.
 */
 
 import (
-	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/petermattis/goid"
 )
 
-// map to map the internal routine id to index in routines
-var mapIndex = make(map[int64]int)
-
-// lock for the creation of a new routine
-var createRoutineLock sync.Mutex
-
-// list of routines
-var routines = make([]routine, opts.maxRoutines)
-
-// number of routines in routines
-var numberRoutines = 0
-
 // type to implement structures for lock logging
 type routine struct {
+	// lock to prevent concurrent access to the routine by the routine itself,
+	// the detection and Reset
+	lock sync.Mutex
 	// index of the routine
 	index int
 	// number of currently hold locks
@@ -63,75 +56,164 @@ type routine struct {
 	dependencyMap map[uintptr]*[]*dependency
 	// list of dependencies, implements the lock tree
 	dependencies [](*dependency)
-	// last inserted dependency
-	curDep *dependency
 	// number of dependencies in dependency map
 	depCount int
-	// map to save information about collected single level
-	collectedSingleLevelLocks map[string][]int
+	// positions where the locks in holdingSet were acquired
+	holdingInfo []callerInfo
+	// id of the go routine
+	goID int64
+	// position where the go routine was created
+	createdBy callerInfo
 }
 
 // Initialize a go routine
 // Returns:
-//  nil
-func newRoutine() {
+//  (*routine): the routine, nil if the detection is disabled
+func (d *Detector) newRoutine() *routine {
 	// return if detection is disabled
-	if !opts.periodicDetection && !opts.comprehensiveDetection {
-		return
+	if !d.opts.periodicDetection && !d.opts.comprehensiveDetection {
+		return nil
 	}
 
+	return d.addRoutine(goid.Get(), getCreatedBy())
+}
+
+// Add a routine to the list of routines
+//  Args:
+//   goID (int64): id of the go routine
+//   createdBy (callerInfo): position where the go routine was created
+//  Returns:
+//   (*routine): the routine
+func (d *Detector) addRoutine(goID int64, createdBy callerInfo) *routine {
 	// lock the routine list
-	createRoutineLock.Lock()
+	d.createRoutineLock.Lock()
+	defer d.createRoutineLock.Unlock()
 
 	// create the routine
-	r := routine{
-		index:                     numberRoutines,
-		holdingCount:              0,
-		holdingSet:                make([]mutexInt, opts.maxNumberOfDependentLocks),
-		dependencyMap:             make(map[uintptr]*[]*dependency),
-		dependencies:              make([]*dependency, opts.maxDependencies),
-		curDep:                    nil,
-		depCount:                  0,
-		collectedSingleLevelLocks: make(map[string][]int),
+	r := &routine{
+		index:         d.numberRoutines,
+		holdingCount:  0,
+		holdingSet:    make([]mutexInt, d.opts.maxNumberOfDependentLocks),
+		dependencyMap: make(map[uintptr]*[]*dependency),
+		dependencies:  make([]*dependency, d.opts.maxDependencies),
+		depCount:      0,
+		holdingInfo:   make([]callerInfo, d.opts.maxNumberOfDependentLocks),
+		goID:          goID,
+		createdBy:     createdBy,
 	}
 
 	// the routine list can only contain a fixed amount of routines
 	// panic if it already full
-	if numberRoutines >= opts.maxRoutines {
+	if d.numberRoutines >= d.opts.maxRoutines {
 		panic(`Number of routines is greater than max number of routines. 
 			Increase Opts.MaxRoutines.`)
 	}
 
 	// set the routine
-	routines[numberRoutines] = r
+	d.routines[d.numberRoutines] = r
 
 	// save the link from internal go id to index of routine
-	mapIndex[goid.Get()] = numberRoutines
+	d.mapIndex[goID] = d.numberRoutines
 
 	// increase number of routines in routine
-	numberRoutines++
-
-	// release list lock
-	createRoutineLock.Unlock()
+	d.numberRoutines++
 
 	// allocate the dependency list
 	// for i := 0; i < opts.maxDependencies; i++ {
 	// 	dep := newDependency(nil, nil, 0)
 	// 	r.dependencies[i] = &dep
 	// }
+
+	return r
+}
+
+// Remove the lock trees of all routines and all routines which do not hold
+// a lock, e.g. between the runs of a fuzz test. The routines which hold a
+// lock are kept with their holding sets, so that the locks can still be
+// released and the dependencies created while holding them are still found.
+// The other routines are created again when they acquire their next lock.
+//  Returns:
+//   nil
+func (d *Detector) resetRoutines() {
+	d.createRoutineLock.Lock()
+	defer d.createRoutineLock.Unlock()
+
+	routines := make([]*routine, d.opts.maxRoutines)
+	mapIndex := make(map[int64]int)
+	numberRoutines := 0
+	for i := 0; i < d.numberRoutines; i++ {
+		r := d.routines[i]
+
+		r.lock.Lock()
+		if r.holdingCount > 0 {
+			r.index = numberRoutines
+			r.dependencyMap = make(map[uintptr]*[]*dependency)
+			r.dependencies = make([]*dependency, d.opts.maxDependencies)
+			r.depCount = 0
+
+			routines[numberRoutines] = r
+			mapIndex[r.goID] = numberRoutines
+			numberRoutines++
+		}
+		r.lock.Unlock()
+	}
+
+	d.routines = routines
+	d.mapIndex = mapIndex
+	d.numberRoutines = numberRoutines
+}
+
+// routineList returns the routines which currently exist
+//  Returns:
+//   ([]*routine): the routines in the order of their indices
+func (d *Detector) routineList() []*routine {
+	d.createRoutineLock.RLock()
+	defer d.createRoutineLock.RUnlock()
+
+	list := make([]*routine, d.numberRoutines)
+	copy(list, d.routines[:d.numberRoutines])
+	return list
+}
+
+// lockRoutines returns the routines which currently exist and locks them, so
+// that they do not change until they are unlocked with unlockRoutines. The
+// routines are always locked in the order of their indices.
+//  Returns:
+//   ([]*routine): the locked routines in the order of their indices
+func (d *Detector) lockRoutines() []*routine {
+	routines := d.routineList()
+	for _, r := range routines {
+		r.lock.Lock()
+	}
+	return routines
+}
+
+// unlockRoutines unlocks the routines locked by lockRoutines
+//  Args:
+//   routines ([]*routine): the locked routines
+//  Returns:
+//   nil
+func unlockRoutines(routines []*routine) {
+	for _, r := range routines {
+		r.lock.Unlock()
+	}
 }
 
 // Update the routine structure if a mutex is locked
 // Args:
 //  m (mutexInt): mutex to lock
+//  rLock (bool): true if m is locked as r-lock
+//  info (callerInfo): position (and call stack) from which the locking was
+//   initiated
 // Returns:
 //  nil
-func (r *routine) updateLock(m mutexInt, rLock bool) {
-	hc := r.holdingCount
+func (r *routine) updateLock(m mutexInt, rLock bool, info callerInfo) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
 
-	m.setRLock(r.index, rLock)
+	hc := r.holdingCount
 
-	isNew := false
+	m.setRLock(r.goID, rLock)
 
 	// if lock is not a single level lock -> found nested lock
 	if hc > 0 {
@@ -152,14 +234,14 @@ func (r *routine) updateLock(m mutexInt, rLock bool) {
 		// dependency, created by locking m is not already in the list of
 		// dependencies associated with that key. In this case the dependency
 		// will be added to the lock tree
-		if !(ok && r.dependencyAlreadyExists(m, d)) {
+		if !(ok && r.dependencyAlreadyExists(m, rLock, info, d)) {
 			// panic if the number of number of dependencies in the lock tree exceeds
 			// it maximum
-			if r.depCount >= opts.maxDependencies {
+			if r.depCount >= m.getDetector().opts.maxDependencies {
 				panic(panicMassage)
 			}
 			// add the new dependency to the lock tree
-			dep := newDependency(m, r.holdingSet, hc)
+			dep := newDependency(m, rLock, r.holdingSet, hc, info, r.holdingInfo)
 			r.dependencies[r.depCount] = &dep
 			dep.update(m, &r.holdingSet, hc)
 			r.depCount++
@@ -171,100 +253,46 @@ func (r *routine) updateLock(m mutexInt, rLock bool) {
 				d = &[]*dependency{&dep}
 			}
 			r.dependencyMap[key] = d
-
-			// set the last added dependency pf the tree
-			r.curDep = &dep
-
-			isNew = true
-		}
-
-	} else {
-		// save information on single level locks if enabled in the options
-		// to avoid creating the caller info multiple times
-		if opts.collectSingleLevelLockStack {
-			// get caller information
-			_, file, line, _ := runtime.Caller(3)
-
-			// check if a lock of a single level lock was already called in the same file
-			if lines, ok := r.collectedSingleLevelLocks[file]; ok {
-				isNew = true
-
-				for _, l := range lines {
-					// the call (from this file and line) was already called before
-					if l == line {
-						isNew = false
-						break
-					}
-				}
-
-				if isNew {
-					// add new information
-					r.collectedSingleLevelLocks[file] = append(
-						r.collectedSingleLevelLocks[file], line)
-				}
-			} else {
-				// add new information if no lock was locked from this file before
-				isNew = true
-				r.collectedSingleLevelLocks[file] = []int{line}
-			}
 		}
 	}
 
-	// save caller information or call stacks if the dependency situation was
-	// added for the first time
-	if isNew && (hc > 0 || opts.collectSingleLevelLockStack) {
-		var file string
-		var line int
-		var bufStringCleaned string
-
-		// get the call stack if call stack collection is enabled
-		if opts.collectCallStack {
-			var bufString string
-			buf := make([]byte, opts.maxCallStackSize)
-			n := runtime.Stack(buf[:], false)
-			bufString = string(buf[:n])
-			bufStringSplit := strings.Split(bufString, "\n")
-			bufStringCleaned = bufStringSplit[0] + "\n"
-			for i := 7; i < len(bufStringSplit); i++ {
-				bufStringCleaned += bufStringSplit[i] + "\n"
-			}
-		}
-
-		// get the file and line from which the locking was initiated
-		_, file, line, _ = runtime.Caller(3)
-
-		// add the new caller information
-		context := m.getContext()
-		*context = append(*context, newInfo(file, line, false, bufStringCleaned))
-	}
-
 	// panic if the holding depth exceeds its maximum
-	if hc >= opts.maxNumberOfDependentLocks {
+	if hc >= m.getDetector().opts.maxNumberOfDependentLocks {
 		panic(`Holding Count is grater than maximum number of dependent locks. 
 		Increase Opts.maxNumberOfDependentLocks.`)
 	}
 
 	// add the lock to the holding set of the routine
 	r.holdingSet[hc] = m
+	r.holdingInfo[hc] = info
 	r.holdingCount++
 }
 
-// check if the dependency which results from locking m already exists in list
+// check if the dependency which results from locking m already exists in list.
+// Dependencies with the same locks but different positions or modes of the
+// acquisitions are different dependencies.
 //  Args:
 //   m (mutexInt): mutex which gets locked
+//   rLock (bool): true if m gets locked as r-lock
+//   info (callerInfo): position where m gets locked
 //   depList (*([]*dependency)): list to check in
 //  Returns:
 //   true if dependency already exist
-func (r *routine) dependencyAlreadyExists(m mutexInt, depList *([]*dependency)) bool {
+func (r *routine) dependencyAlreadyExists(m mutexInt, rLock bool, info callerInfo,
+	depList *([]*dependency)) bool {
 	// traverse depList
 	for _, d := range *depList {
 		hc := r.holdingCount
 
-		// check if dependency with same lock and holding count exists
-		if d.mu == m && d.holdingCount == hc {
-			// check if the holdingSets in the dependency and the routine are equal
+		// check if dependency with same lock, mode, position and holding count
+		// exists
+		if d.mu == m && d.rLock == rLock && d.holdingCount == hc &&
+			d.muInfo.equalPosition(info) {
+			// check if the holdingSets and the positions of the acquisitions
+			// in the dependency and the routine are equal
 			i := 0
-			for d.holdingSet[i] == r.holdingSet[i] && i < hc {
+			for i < hc && d.holdingSet[i] == r.holdingSet[i] &&
+				d.holdingInfo[i].equalPosition(r.holdingInfo[i]) {
 				i++
 			}
 			if i == hc {
@@ -280,20 +308,27 @@ func (r *routine) dependencyAlreadyExists(m mutexInt, depList *([]*dependency))
 // this only updates the holding set
 //  Args:
 //   m (mutexInt): mutex which was locked
+//   rLock (bool): true if m was locked as r-lock
+//   info (callerInfo): position (and call stack) from which the locking was
+//    initiated
 //  Returns:
 //   nil
-func (r *routine) updateTryLock(m mutexInt, rLock bool) {
+func (r *routine) updateTryLock(m mutexInt, rLock bool, info callerInfo) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
 	// panic if the number of locks in the holding set exceeds its maximum
 	hc := r.holdingCount
-	if hc >= opts.maxNumberOfDependentLocks {
+	if hc >= m.getDetector().opts.maxNumberOfDependentLocks {
 		panic(`Holding Count is grater than maximum holding depth. Increase 
 			Opts.MaxHoldingDepth.`)
 	}
 
-	m.setRLock(r.index, rLock)
+	m.setRLock(r.goID, rLock)
 
 	// add the lock to the holding set
 	r.holdingSet[hc] = m
+	r.holdingInfo[hc] = info
 	r.holdingCount++
 }
 
@@ -303,58 +338,113 @@ func (r *routine) updateTryLock(m mutexInt, rLock bool) {
 //  Returns:
 //   nil
 func (r *routine) updateUnlock(m mutexInt) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
 	// remove m from the holding set of r
 	for i := r.holdingCount - 1; i >= 0; i-- {
 		if r.holdingSet[i] == m {
 			r.holdingSet = append(r.holdingSet[:i], r.holdingSet[i+1:]...)
 			r.holdingSet = append(r.holdingSet, nil)
+			r.holdingInfo = append(r.holdingInfo[:i], r.holdingInfo[i+1:]...)
+			r.holdingInfo = append(r.holdingInfo, callerInfo{})
 			r.holdingCount--
 			break
 		}
 	}
 }
 
-// Get the index of the routine which calls getRoutineIndex in routines
+// Get the position where the calling go routine was created.
+// The position is parsed from the "created by" entry of the call stack.
 //  Returns:
-//   (int): index of the routine in routines which called getRoutineIndex
-func getRoutineIndex() int {
+//   (callerInfo): position and function which created the routine, empty
+//    if the routine is the main routine
+func getCreatedBy() callerInfo {
+	// the created by entry is at the end of the call stack, so the complete
+	// call stack of the routine is needed
+	buf := make([]byte, 4096)
+	n := runtime.Stack(buf, false)
+	for n == len(buf) && len(buf) < 1<<20 {
+		buf = make([]byte, 2*len(buf))
+		n = runtime.Stack(buf, false)
+	}
+
+	// The entry has the form
+	// 	created by main.main in goroutine 1
+	// 		/path/to/file.go:12 +0x1d
+	lines := strings.Split(string(buf[:n]), "\n")
+	for i, l := range lines {
+		if !strings.HasPrefix(l, "created by ") || i+1 >= len(lines) {
+			continue
+		}
+
+		function := strings.TrimPrefix(l, "created by ")
+		if j := strings.Index(function, " in goroutine "); j != -1 {
+			function = function[:j]
+		}
+
+		position := strings.TrimSpace(lines[i+1])
+		if j := strings.LastIndex(position, " +0x"); j != -1 {
+			position = position[:j]
+		}
+		j := strings.LastIndex(position, ":")
+		if j == -1 {
+			return callerInfo{function: function}
+		}
+		line, _ := strconv.Atoi(position[j+1:])
+
+		info := newInfo(position[:j], line, true, "")
+		info.function = function
+		return info
+	}
+
+	return callerInfo{}
+}
+
+// Get the routine which calls getRoutine
+//  Returns:
+//   (*routine): the routine which called getRoutine, nil if the routine
+//    does not exist
+func (d *Detector) getRoutine() *routine {
 	// get an unique internal routine
 	// uses "github.com/petermattis/goid"
-	id := goid.Get()
+	return d.routineOf(goid.Get())
+}
 
-	// get the index corresponding to this id
-	createRoutineLock.Lock()
-	index, ok := mapIndex[id]
-	createRoutineLock.Unlock()
+// Get the routine of a go routine
+//  Args:
+//   goID (int64): id of the go routine
+//  Returns:
+//   (*routine): the routine, nil if the routine does not exist
+func (d *Detector) routineOf(goID int64) *routine {
+	d.createRoutineLock.RLock()
+	defer d.createRoutineLock.RUnlock()
 
-	// return -1 if the routine does not exist
+	index, ok := d.mapIndex[goID]
 	if !ok {
-		return -1
+		return nil
 	}
-
-	return index
+	return d.routines[index]
 }
 
 // Check if locking mutex m would lead to double locking
 //  Args:
 //   m (mutexInt): mutex to check for
-//   routineIndex (int): index of the routine that tries to lock m
 //   rLock (bool): true, if the tested lock acquisition is a r-lock, false otherwise
 //  Returns:
 //   nil
-func (r *routine) checkDoubleLocking(m mutexInt, routineIndex int, rLock bool) {
+func (r *routine) checkDoubleLocking(m mutexInt, rLock bool) {
 	// it can only be double locking, if the routine already holds the lock
-	if (*m.getIsLockedRoutineIndex())[routineIndex] == 0 {
+	if !isLockedBy(m, r.goID) {
 		return
 	}
 
 	// no double locking of two reader
-	if rLock && m.getRLock(routineIndex) {
+	if rLock && m.getRLock(r.goID) {
 		return
 	}
 
-	// report double locking and terminate the program
-	reportDeadlockDoubleLocking(m)
-	FindPotentialDeadlocks()
-	os.Exit(2)
+	// report double locking and react according to the policy for deadlocks
+	d := m.getDetector()
+	d.handleDeadlock(d.reportDeadlockDoubleLocking(m, r, rLock))
 }
@@ -1,3 +1,5 @@
+//go:build !deadlock_off
+
 package deadlock
 
 /*
@@ -33,6 +35,7 @@ the lock, r-lock and unlock operations for these locks.
 import (
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -42,46 +45,74 @@ type RWMutex struct {
 	mu *sync.RWMutex
 	// info about the creation and lock/unlock of this lock
 	context []callerInfo
-	// set to true after lock was initialized
-	in bool
+	// set to 1 after the lock was created, accessed atomically
+	created uint32
 	// how ofter is the lock locked
 	numberLocked int
-	// indexes of the routines, which holds the lock
-	isLockedRoutineIndex map[int]int
-	// lock to prevent multiple concurrent writes to isLockedRoutineIndex
-	isLockedRoutineIndexLock *sync.Mutex
+	// number of acquisitions of the lock by the id of the go routine, which
+	// holds the lock
+	isLockedRoutine map[int64]int
+	// lock to prevent concurrent access to numberLocked and isLockedRoutine
+	isLockedRoutineLock *sync.Mutex
 	// position of the mutex in memory
 	memoryPosition uintptr
-	// save for the routine index if the lock was locked by rLock
-	isRLock map[int]bool
+	// detector which created the lock
+	detector *Detector
+	// save for the id of the go routine if the lock was locked by rLock
+	isRLock map[int64]bool
 	// lock to prevent concurrent writes to isRLock
 	isRLockLock *sync.Mutex
 }
 
-// create a new rw-lock
+// create a new rw-lock of the default detector
 func NewRWLock() *RWMutex {
+	return defaultDetector.newRWLock()
+}
+
+// create a new rw-lock of detector d
+func (d *Detector) NewRWLock() *RWMutex {
+	return d.newRWLock()
+}
+
+// create a new rw-lock of detector d. It must be called directly by the
+// exported functions, because it stores the position of their caller
+func (d *Detector) newRWLock() *RWMutex {
+	m := &RWMutex{}
+
+	// the lock is created at the caller of the exported function
+	m.setup(d, 2)
+
+	return m
+}
+
+// initialize the rw-lock as lock of detector d
+//  Args:
+//   d (*Detector): the detector of the lock
+//   skip (int): number of frames between the caller of setup and the position
+//    where the lock is created
+//  Returns:
+//   nil
+func (m *RWMutex) setup(d *Detector, skip int) {
 	// initialize detector if necessary
-	if !initialized {
-		initialize()
-	}
+	d.initialize()
 
-	m := RWMutex{
-		mu:                       &sync.RWMutex{},
-		in:                       true,
-		isLockedRoutineIndex:     map[int]int{},
-		isLockedRoutineIndexLock: &sync.Mutex{},
-		isRLock:                  map[int]bool{},
-		isRLockLock:              &sync.Mutex{},
-	}
+	m.mu = &sync.RWMutex{}
+	m.detector = d
+	m.isLockedRoutine = map[int64]int{}
+	m.isLockedRoutineLock = &sync.Mutex{}
+	m.isRLock = map[int64]bool{}
+	m.isRLockLock = &sync.Mutex{}
 
-	// save the position of the NewLock call
-	_, file, line, _ := runtime.Caller(1)
+	// save the position where the lock was created
+	_, file, line, _ := runtime.Caller(skip + 1)
 	m.context = append(m.context, newInfo(file, line, true, ""))
 
 	// save the memory position of the mutex
-	m.memoryPosition = uintptr(unsafe.Pointer(&m))
+	m.memoryPosition = uintptr(unsafe.Pointer(m))
+
+	d.traceEvent(TraceCreate, m, skip+1)
 
-	return &m
+	atomic.StoreUint32(&m.created, 1)
 }
 
 // ====== GETTER ===============================================================
@@ -93,18 +124,18 @@ func (m *RWMutex) getNumberLocked() *int {
 	return &m.numberLocked
 }
 
-// getter for isLockedRoutineIndex
+// getter for isLockedRoutine
 //  Returns:
-//   (*int): isLockedRoutineIndex
-func (m *RWMutex) getIsLockedRoutineIndex() *map[int]int {
-	return &m.isLockedRoutineIndex
+//   (*map[int64]int): isLockedRoutine
+func (m *RWMutex) getIsLockedRoutine() *map[int64]int {
+	return &m.isLockedRoutine
 }
 
-// getter for isLockedRoutineIndexLock
+// getter for isLockedRoutineLock
 //  Returns:
-//   (*int): isLockedRoutineIndex
-func (m *RWMutex) getIsLockedRoutineIndexLock() *sync.Mutex {
-	return m.isLockedRoutineIndexLock
+//   (*sync.Mutex): isLockedRoutineLock
+func (m *RWMutex) getIsLockedRoutineLock() *sync.Mutex {
+	return m.isLockedRoutineLock
 }
 
 // getter for context
@@ -121,11 +152,18 @@ func (m *RWMutex) getMemoryPosition() uintptr {
 	return m.memoryPosition
 }
 
-// getter for in
+// getter for created
+//  Returns:
+//   (*uint32): 1 if the lock was created, 0 otherwise
+func (m *RWMutex) getCreated() *uint32 {
+	return &m.created
+}
+
+// getter for detector
 //  Returns:
-//   (bool): true if the lock was initialized, false otherwise
-func (m *RWMutex) getIn() *bool {
-	return &m.in
+//   (*Detector): detector which created the lock
+func (m *RWMutex) getDetector() *Detector {
+	return m.detector
 }
 
 // getter for mu
@@ -139,27 +177,25 @@ func (m *RWMutex) getLock() (bool, *sync.Mutex, *sync.RWMutex) {
 
 // get whether the lock was created by an rlock
 //  Args:
-//   routineIndex (int): index of the routine
+//   goID (int64): id of the go routine
 //  Returns:
 //   bool. true if it was last locked by rlock, false otherwise
-func (m *RWMutex) getRLock(routineIndex int) bool {
-	ok, res := m.isRLock[routineIndex]
-	if !ok {
-		return false
-	}
-	return res
+func (m *RWMutex) getRLock(goID int64) bool {
+	m.isRLockLock.Lock()
+	defer m.isRLockLock.Unlock()
+	return m.isRLock[goID]
 }
 
 // set whether the lock was created by an rlock
 //  Args:
-//   routineIndex (int): index of the routine
+//   goID (int64): id of the go routine
 //   value (bool): true if it was last locked from a rLock, false otherwise
 //  Returns:
 //   nil
-func (m *RWMutex) setRLock(routineIndex int, value bool) {
-	m.isLockedRoutineIndexLock.Lock()
-	m.isRLock[routineIndex] = value
-	m.isLockedRoutineIndexLock.Unlock()
+func (m *RWMutex) setRLock(goID int64, value bool) {
+	m.isRLockLock.Lock()
+	m.isRLock[goID] = value
+	m.isRLockLock.Unlock()
 }
 
 // ====== FUNCTIONS ============================================================
@@ -189,12 +225,21 @@ func (m *RWMutex) TryLock() bool {
 	return res
 }
 
-// TryLock rw-mutex m
+// RTryLock rw-mutex m
 //  Returns:
 //   (bool): true if locking was successful, false otherwise
 func (m *RWMutex) RTryLock() bool {
 	// call the try-lock method for the mutexInt interface
-	res := tryLockInt(m, false)
+	res := tryLockInt(m, true)
+	return res
+}
+
+// TryRLock rw-mutex m, same as RTryLock with the name used by sync.RWMutex
+//  Returns:
+//   (bool): true if locking was successful, false otherwise
+func (m *RWMutex) TryRLock() bool {
+	// call the try-lock method for the mutexInt interface
+	res := tryLockInt(m, true)
 	return res
 }
 
@@ -202,7 +247,13 @@ func (m *RWMutex) RTryLock() bool {
 //  Returns:
 //   nil
 func (m *RWMutex) Unlock() {
-	if opts.activated {
+	// the lock was already released with ReleaseLocks
+	if m.detector != nil && m.detector.ignoreUnlock(m) {
+		return
+	}
+
+	// the detector is nil if the lock was not used before
+	if m.detector == nil || m.detector.opts.activated {
 		unlockInt(m)
 	}
 	m.mu.Unlock()
@@ -211,7 +262,47 @@ func (m *RWMutex) Unlock() {
 // Unlock rw-mutex m
 //  Returns: nil
 func (m *RWMutex) RUnlock() {
-	if opts.activated {
+	// the lock was already released with ReleaseLocks
+	if m.detector != nil && m.detector.ignoreUnlock(m) {
+		return
+	}
+
+	// the detector is nil if the lock was not used before
+	if m.detector == nil || m.detector.opts.activated {
+		unlockInt(m)
+	}
+	m.mu.RUnlock()
+}
+
+// RLocker returns a sync.Locker, which locks and unlocks m with RLock and
+// RUnlock, like RLocker of sync.RWMutex
+//  Returns:
+//   (sync.Locker): the locker
+func (m *RWMutex) RLocker() sync.Locker {
+	return (*rLocker)(m)
+}
+
+// type for the locker returned by RLocker
+type rLocker RWMutex
+
+// R-Lock the rw-mutex of the locker
+//  Returns:
+//   nil
+func (r *rLocker) Lock() {
+	// call the lock method directly to keep the position of the caller
+	lockInt((*RWMutex)(r), true)
+}
+
+// R-Unlock the rw-mutex of the locker
+//  Returns:
+//   nil
+func (r *rLocker) Unlock() {
+	// same as RUnlock, to keep the position of the caller
+	m := (*RWMutex)(r)
+	if m.detector != nil && m.detector.ignoreUnlock(m) {
+		return
+	}
+	if m.detector == nil || m.detector.opts.activated {
 		unlockInt(m)
 	}
 	m.mu.RUnlock()
@@ -0,0 +1,138 @@
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+signature.go
+This file implements the signatures of the reports. They are also used for
+reports which were not created by the detector, e.g. by the static analysis
+in lockorder. The signatures only depend on the data of the reports, so they
+also exist with the tag deadlock_off.
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ComputeSignature calculates the signature of the report, as it is set in
+// Signature by the detector. It can be used to sign reports which were not
+// created by the detector.
+//  Returns:
+//   (string): the signature, "" if the report is not a deadlock
+func (r *Report) ComputeSignature() string {
+	return r.signature()
+}
+
+// lock returns the lock with the given id
+//  Args:
+//   id (string): id of the lock
+//  Returns:
+//   (ReportLock): the lock or an empty lock if it is not part of the report
+func (r *Report) lock(id string) ReportLock {
+	for _, l := range r.Locks {
+		if l.ID == id {
+			return l
+		}
+	}
+	return ReportLock{ID: id}
+}
+
+// cycleHeld returns the held lock of an edge which is part of the deadlock.
+// For cycles this is the lock acquired by the previous edge, for double
+// locking it is the lock acquired by the edge itself.
+//  Args:
+//   i (int): index of the edge
+//  Returns:
+//   (*ReportHeldLock): the held lock or nil if it is not part of the edge
+func (r *Report) cycleHeld(i int) *ReportHeldLock {
+	lock := r.Edges[i].Lock
+	if r.Kind != KindDoubleLocking {
+		lock = r.Edges[(i+len(r.Edges)-1)%len(r.Edges)].Lock
+	}
+
+	for j := range r.Edges[i].Holding {
+		if r.Edges[i].Holding[j].Lock == lock {
+			return &r.Edges[i].Holding[j]
+		}
+	}
+	return nil
+}
+
+// signature calculates the signature of a deadlock. The signature consists
+// of the sorted edges of the deadlock, where each edge is given by the held
+// and the acquired lock. The locks are given by their class, i.e. the
+// position where they were created, and the positions of the acquisitions.
+// In contrast to the identities of the locks, the signature is therefore
+// stable across runs of the program.
+//  Returns:
+//   (string): the signature, "" if the report is not a deadlock
+func (r *Report) signature() string {
+	edges := r.cycleEdges()
+	if edges == "" {
+		return ""
+	}
+
+	hash := sha256.Sum256([]byte(string(r.Kind) + "|" + edges))
+	return hex.EncodeToString(hash[:8])
+}
+
+// cycleEdges returns the edges of the cycle of a report, independent of the
+// kind of the report. Every edge is given by the classes of the held and the
+// acquired lock and the positions of both acquisitions. A potential deadlock
+// and an actual deadlock of the same cycle therefore have the same edges.
+//  Returns:
+//   (string): sorted edges of the cycle, empty if the report is not a cycle
+func (r *Report) cycleEdges() string {
+	if r.Kind == KindLockWaitTimeout || r.Kind == KindScheduleTimeout ||
+		len(r.Edges) == 0 {
+		return ""
+	}
+
+	edges := make([]string, 0, len(r.Edges))
+	for i, edge := range r.Edges {
+		held := r.cycleHeld(i)
+		if held == nil {
+			return ""
+		}
+		edges = append(edges, fmt.Sprintf("%s@%s->%s@%s",
+			callSiteKey(r.lock(held.Lock).Created), callSiteKey(held.Acquired),
+			callSiteKey(r.lock(edge.Lock).Created), callSiteKey(edge.Acquired)))
+	}
+	sort.Strings(edges)
+
+	return strings.Join(edges, ",")
+}
+
+// callSiteKey returns the position of a call site as string
+//  Args:
+//   c (CallSite): the call site
+//  Returns:
+//   (string): file and line of the call site
+func callSiteKey(c CallSite) string {
+	return fmt.Sprintf("%s:%d", c.File, c.Line)
+}
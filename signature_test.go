@@ -0,0 +1,145 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+signature_test.go
+Tests for the signatures of the reports.
+*/
+
+import (
+	"testing"
+)
+
+// signatureEdge is an edge of a cycle for the tests of the signatures
+type signatureEdge struct {
+	// id of the acquired lock
+	lock string
+	// line of the acquisition of the lock
+	line int
+	// line of the acquisition of the held lock, the lock of the previous edge
+	heldLine int
+}
+
+// newSignatureReport creates a report of a cycle. The lock acquired by every
+// edge is held by the next edge. Every lock is created in the line given by
+// its id.
+//  Args:
+//   kind (ReportKind): kind of the report
+//   edges ([]signatureEdge): the edges of the cycle
+//  Returns:
+//   (*Report): the report
+func newSignatureReport(kind ReportKind, edges []signatureEdge) *Report {
+	r := newReport(kind)
+	for i, e := range edges {
+		prev := edges[(i+len(edges)-1)%len(edges)]
+		r.Locks = append(r.Locks, ReportLock{
+			ID:      e.lock,
+			Created: CallSite{File: "signature.go", Line: len(e.lock)},
+		})
+		r.Edges = append(r.Edges, ReportEdge{
+			Lock:     e.lock,
+			Acquired: CallSite{File: "signature.go", Line: e.line},
+			Holding: []ReportHeldLock{{
+				Lock:     prev.lock,
+				Acquired: CallSite{File: "signature.go", Line: e.heldLine},
+			}},
+		})
+	}
+	return r
+}
+
+// The signature of a cycle does not depend on the rotation of the cycle or
+// on the identities of the locks, but on the classes of the locks, the
+// positions of the acquisitions and the kind of the report.
+func TestSignature(t *testing.T) {
+	a := signatureEdge{lock: "a", line: 10, heldLine: 30}
+	bb := signatureEdge{lock: "bb", line: 20, heldLine: 11}
+	ccc := signatureEdge{lock: "ccc", line: 30, heldLine: 21}
+
+	base := newSignatureReport(KindPotentialDeadlock, []signatureEdge{a, bb, ccc}).ComputeSignature()
+	if base == "" {
+		t.Fatal("the cycle has no signature")
+	}
+
+	moved := bb
+	moved.line = 25
+
+	// another lock of the class of a
+	other := a
+	other.lock = "x"
+
+	tests := []struct {
+		name string
+		// kind of the report
+		kind ReportKind
+		// edges of the cycle
+		edges []signatureEdge
+		// true if the signature is the signature of base
+		same bool
+	}{
+		{"same", KindPotentialDeadlock, []signatureEdge{a, bb, ccc}, true},
+		{"rotation 1", KindPotentialDeadlock, []signatureEdge{bb, ccc, a}, true},
+		{"rotation 2", KindPotentialDeadlock, []signatureEdge{ccc, a, bb}, true},
+		{"other lock of the class", KindPotentialDeadlock, []signatureEdge{other, bb, ccc}, true},
+		{"other position", KindPotentialDeadlock, []signatureEdge{a, moved, ccc}, false},
+		{"actual deadlock", KindDeadlock, []signatureEdge{bb, ccc, a}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newSignatureReport(test.kind, test.edges)
+			if got := r.ComputeSignature(); (got == base) != test.same {
+				t.Errorf("signature %q, base %q, expected equal: %t", got, base, test.same)
+			}
+		})
+	}
+}
+
+// A potential deadlock and an actual deadlock of the same cycle have the same
+// edges, independent of the rotation. A report which is not a cycle has no
+// signature.
+func TestCycleEdges(t *testing.T) {
+	a := signatureEdge{lock: "a", line: 10, heldLine: 20}
+	bb := signatureEdge{lock: "bb", line: 20, heldLine: 11}
+
+	potential := newSignatureReport(KindPotentialDeadlock, []signatureEdge{a, bb})
+	actual := newSignatureReport(KindDeadlock, []signatureEdge{bb, a})
+	if potential.cycleEdges() == "" || potential.cycleEdges() != actual.cycleEdges() {
+		t.Fatalf("edges %q and %q differ", potential.cycleEdges(), actual.cycleEdges())
+	}
+
+	timeout := newSignatureReport(KindLockWaitTimeout, []signatureEdge{a})
+	if s := timeout.ComputeSignature(); s != "" {
+		t.Fatalf("unexpected signature %q of a lock wait timeout", s)
+	}
+
+	broken := newSignatureReport(KindPotentialDeadlock, []signatureEdge{a, bb})
+	broken.Edges[1].Holding = nil
+	if s := broken.ComputeSignature(); s != "" {
+		t.Fatalf("unexpected signature %q of an edge without held lock", s)
+	}
+}
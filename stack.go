@@ -1,3 +1,5 @@
+//go:build !deadlock_off
+
 package deadlock
 
 /*
@@ -39,6 +41,8 @@ type stackElement struct {
 	depEntry *dependency
 	// index value of the linkedIndex, is set to the index of the routine
 	index int
+	// routine which created the dependency, nil for the empty first element
+	routine *routine
 	// pointer to the previous stack element
 	prev *stackElement
 	// pointer to the next stack element
@@ -49,17 +53,32 @@ type stackElement struct {
 //  Args:
 //   dep (*dependency): dependency which is represented by the stack element
 //   i (int): index of the routine which created dep
+//   r (*routine): routine which created dep
 //  Returns:
 //   (stackElement): element for the stack
-func newStackElement(dep *dependency, i int) stackElement {
+func newStackElement(dep *dependency, i int, r *routine) stackElement {
 	return stackElement{
 		depEntry: dep,
 		index:    i,
+		routine:  r,
 		prev:     nil,
 		next:     nil,
 	}
 }
 
+// check if a lock was acquired as r-lock by the routine of the element
+//  Args:
+//   m (mutexInt): the lock
+//  Returns:
+//   (bool): true if the routine last acquired m as r-lock, false otherwise or
+//    for the empty first element
+func (e *stackElement) isRLock(m mutexInt) bool {
+	if e.routine == nil {
+		return false
+	}
+	return m.getRLock(e.routine.goID)
+}
+
 // ============ stack ============
 
 // stack for the dependencies
@@ -74,7 +93,7 @@ type depStack struct {
 //  Returns:
 //   (depStack): the dependency stack
 func newDepStack() depStack {
-	cl := newStackElement(nil, -1)
+	cl := newStackElement(nil, -1, nil)
 
 	// set the first element of the stack to an empty stack element
 	c := depStack{
@@ -91,11 +110,12 @@ func newDepStack() depStack {
 //  Args:
 //   dep (*dependency): dependency to put on the stack
 //   index (int): index of the routine which created the dependency
+//   r (*routine): routine which created the dependency
 //  Returns:
 //   nil
-func (s *depStack) push(dep *dependency, index int) {
+func (s *depStack) push(dep *dependency, index int, r *routine) {
 	// create the new element
-	cl := newStackElement(dep, index)
+	cl := newStackElement(dep, index, r)
 	// add it to the stack
 	s.top.next = &cl
 	// reset the pointers of the previous element and the pointer to the top element
@@ -0,0 +1,131 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+state.go
+This file implements the collection of the current state of the detector,
+e.g. to inspect a process which seems to be stuck without terminating it.
+The state contains the locks held by every routine, the routines which wait
+for a lock and the number of dependencies. It is served by the handler of the
+package deadlockhttp.
+*/
+
+import (
+	"sort"
+	"time"
+)
+
+// State returns the current state of the detector. Every routine is locked
+// while it is read, so the state of each routine is consistent. The routines
+// are not stopped, so the states of different routines can be read at
+// slightly different times.
+//  Returns:
+//   (DetectorState): the state
+func (d *Detector) State() DetectorState {
+	state := DetectorState{
+		Routines:     make([]RoutineState, 0),
+		WaitsTracked: d.trackLockWaits(),
+		Waits:        make([]WaitState, 0),
+	}
+
+	for _, r := range d.routineList() {
+		routine := r.state()
+		state.Dependencies += routine.Dependencies
+		state.Routines = append(state.Routines, routine)
+	}
+
+	if !state.WaitsTracked {
+		return state
+	}
+
+	now := time.Now()
+	d.lockWaitLock.Lock()
+	for _, w := range d.lockWaits {
+		wait := WaitState{
+			Routine:   -1,
+			Goroutine: w.goID,
+			Lock:      newReportLock(w.m),
+			RLock:     w.rLock,
+			Acquired:  newCallSite(w.info),
+			WaitingMs: now.Sub(w.since).Milliseconds(),
+			HeldBy:    make([]int64, 0),
+		}
+		d.createRoutineLock.RLock()
+		if index, ok := d.mapIndex[w.goID]; ok {
+			wait.Routine = index
+		}
+		d.createRoutineLock.RUnlock()
+		for _, holder := range d.getWaitHolders(w) {
+			wait.HeldBy = append(wait.HeldBy, holder.goID)
+		}
+		state.Waits = append(state.Waits, wait)
+	}
+	d.lockWaitLock.Unlock()
+
+	sort.Slice(state.Waits, func(i, j int) bool {
+		return state.Waits[i].Goroutine < state.Waits[j].Goroutine
+	})
+
+	return state
+}
+
+// State calls Detector.State of the default detector
+//  Returns:
+//   (DetectorState): the state
+func State() DetectorState {
+	return defaultDetector.State()
+}
+
+// state returns the current state of the routine
+//  Returns:
+//   (RoutineState): the state
+func (r *routine) state() RoutineState {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	state := RoutineState{
+		Routine:      r.index,
+		Goroutine:    r.goID,
+		Holding:      make([]HeldLockState, 0),
+		Dependencies: r.depCount,
+	}
+	if r.createdBy.file != "" {
+		createdBy := newCallSite(r.createdBy)
+		state.CreatedBy = &createdBy
+	}
+
+	for i := 0; i < r.holdingCount; i++ {
+		m := r.holdingSet[i]
+		state.Holding = append(state.Holding, HeldLockState{
+			Lock:     newReportLock(m),
+			RLock:    m.getRLock(r.goID),
+			Acquired: newCallSite(r.holdingInfo[i]),
+		})
+	}
+
+	return state
+}
@@ -0,0 +1,171 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+trace.go
+This file implements the recording of a trace of all lock events. Every
+creation, acquisition and release of a lock is appended as one JSON object
+per line to the trace file. The trace keeps what the detector learns during
+the run of the program, even if the process is killed, and can be analyzed
+after the program has finished, e.g. with cmd/deadlock-analyze.
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/petermattis/goid"
+)
+
+// name of the environment variable to set the trace file
+const envTraceFile = "DEADLOCK_GO_TRACE"
+
+// interval in which the trace is written to the file
+const traceFlushInterval = 100 * time.Millisecond
+
+// recorder for the trace
+type traceRecorder struct {
+	// buffered trace file
+	w *bufio.Writer
+	// the trace file
+	file *os.File
+	// lock to prevent concurrent writes
+	lock sync.Mutex
+}
+
+// initializeTrace opens the trace file set in the options or the environment
+// and starts to write the trace periodically into the file
+//  Returns:
+//   nil
+func (d *Detector) initializeTrace() {
+	path := d.outputPath(d.opts.traceFile, envTraceFile)
+	if path == "" {
+		return
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Deadlock-Go: could not open trace file:", err)
+		return
+	}
+	d.tracer = &traceRecorder{
+		w:    bufio.NewWriter(file),
+		file: file,
+	}
+
+	// the events are buffered and written periodically, so that at most the
+	// events of the last interval are lost if the process is killed
+	go func() {
+		timer := time.NewTicker(traceFlushInterval)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-timer.C:
+				d.flushTrace()
+			case <-d.done:
+				// the detector was closed
+				return
+			}
+		}
+	}()
+}
+
+// traceEvent appends an event to the trace, if the recording is enabled
+//  Args:
+//   op (TraceOp): operation of the event
+//   m (mutexInt): the lock
+//   skip (int): number of stack frames between the caller of traceEvent and
+//    the code which executed the operation
+//  Returns:
+//   nil
+func (d *Detector) traceEvent(op TraceOp, m mutexInt, skip int) {
+	if d.tracer == nil {
+		return
+	}
+
+	pc, file, line, _ := runtime.Caller(skip + 1)
+	event := TraceEvent{
+		Op:        op,
+		Goroutine: goid.Get(),
+		Lock:      lockID(m),
+		Time:      time.Now().UnixNano(),
+		PC:        uint64(pc),
+		File:      file,
+		Line:      line,
+	}
+	if op == TraceCreate {
+		event.Type = "RWMutex"
+		if isMutex, _, _ := m.getLock(); isMutex {
+			event.Type = "Mutex"
+		}
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	d.tracer.lock.Lock()
+	d.tracer.w.Write(append(data, '\n'))
+	d.tracer.lock.Unlock()
+}
+
+// flushTrace writes all buffered events into the trace file
+//  Returns:
+//   nil
+func (d *Detector) flushTrace() {
+	if d.tracer == nil {
+		return
+	}
+
+	d.tracer.lock.Lock()
+	d.tracer.w.Flush()
+	d.tracer.lock.Unlock()
+}
+
+// closeTrace writes all buffered events into the trace file and closes it.
+// Events after the trace was closed are discarded.
+//  Returns:
+//   nil
+func (d *Detector) closeTrace() {
+	if d.tracer == nil {
+		return
+	}
+
+	d.tracer.lock.Lock()
+	d.tracer.w.Flush()
+	d.tracer.file.Close()
+	d.tracer.w = bufio.NewWriter(io.Discard)
+	d.tracer.lock.Unlock()
+}
@@ -0,0 +1,241 @@
+//go:build !deadlock_off
+
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+trace_test.go
+Tests for the recording and the replay of traces.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// Failed try-locks are recorded in the trace and ignored by the replay.
+func TestTraceTryLockFailed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	d := NewDetector()
+	d.SetTraceFile(path)
+
+	m := d.NewLock()
+	rw := d.NewRWLock()
+	m.Lock()
+	if m.TryLock() {
+		t.Fatal("TryLock acquired a locked mutex")
+	}
+	m.Unlock()
+	rw.Lock()
+	if rw.TryRLock() {
+		t.Fatal("TryRLock acquired a locked rw-mutex")
+	}
+	rw.Unlock()
+	d.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	ops := make(map[TraceOp]int)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event TraceEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		ops[event.Op]++
+	}
+	if ops[TraceTryLockFailed] != 1 || ops[TraceTryRLockFailed] != 1 {
+		t.Fatalf("expected one failed TryLock and TryRLock each, got %v", ops)
+	}
+	if ops[TraceTryLock] != 0 || ops[TraceTryRLock] != 0 {
+		t.Fatalf("failed try-locks were recorded as successful: %v", ops)
+	}
+
+	if _, err := file.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	replay := NewDetector()
+	defer replay.Close()
+	if err := replay.ReplayTrace(file, ReplayOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// recordTrace records the trace of f
+//  Args:
+//   t (*testing.T): the test
+//   f (func(*Detector)): function which uses the locks of the detector
+//  Returns:
+//   ([]byte): the recorded trace
+func recordTrace(t *testing.T, f func(d *Detector)) []byte {
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	d := NewDetector()
+	d.SetPeriodicDetection(false)
+	d.SetTraceFile(path)
+	f(d)
+	d.Close()
+
+	trace, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trace
+}
+
+// replayReports replays a trace into a new detector
+//  Args:
+//   t (*testing.T): the test
+//   trace ([]byte): the trace
+//   options (ReplayOptions): settings for the replay
+//  Returns:
+//   ([]Report): the potential deadlocks found in the trace
+func replayReports(t *testing.T, trace []byte, options ReplayOptions) []Report {
+	var out bytes.Buffer
+	d := NewDetector()
+	d.SetPeriodicDetection(false)
+	d.SetJSONOutput(&out)
+	defer d.Close()
+	if err := d.ReplayTrace(bytes.NewReader(trace), options); err != nil {
+		t.Fatal(err)
+	}
+
+	reports := make([]Report, 0)
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var r Report
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Kind == KindPotentialDeadlock {
+			reports = append(reports, r)
+		}
+	}
+	return reports
+}
+
+// lock inversion of two locks in two routines
+//  Args:
+//   d (*Detector): the detector
+//  Returns:
+//   nil
+func traceInversion(d *Detector) {
+	x := d.NewLock()
+	y := d.NewLock()
+	run(func() {
+		x.Lock()
+		y.Lock()
+		y.Unlock()
+		x.Unlock()
+	})
+	run(func() {
+		y.Lock()
+		x.Lock()
+		x.Unlock()
+		y.Unlock()
+	})
+}
+
+// The replay of a recorded inversion finds the potential deadlock, unless
+// the locks are filtered out.
+func TestReplayInversion(t *testing.T) {
+	trace := recordTrace(t, traceInversion)
+
+	tests := []struct {
+		name    string
+		options ReplayOptions
+		reports int
+	}{
+		{"all", ReplayOptions{}, 1},
+		{"filter match", ReplayOptions{Filter: regexp.MustCompile(`trace_test\.go$`)}, 1},
+		{"filter no match", ReplayOptions{Filter: regexp.MustCompile(`other\.go$`)}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reports := replayReports(t, trace, test.options)
+			if len(reports) != test.reports {
+				t.Fatalf("expected %d potential deadlocks, got %d",
+					test.reports, len(reports))
+			}
+			if test.reports > 0 && len(reports[0].Edges) != 2 {
+				t.Fatalf("expected 2 edges, got %d", len(reports[0].Edges))
+			}
+		})
+	}
+}
+
+// Locks created at the same position are only merged with ByClass, so the
+// inversion of two different pairs of locks is only found with ByClass.
+func TestReplayByClass(t *testing.T) {
+	trace := recordTrace(t, func(d *Detector) {
+		newPair := func() (*Mutex, *Mutex) {
+			x := d.NewLock()
+			y := d.NewLock()
+			return x, y
+		}
+		x1, y1 := newPair()
+		x2, y2 := newPair()
+		run(func() {
+			x1.Lock()
+			y1.Lock()
+			y1.Unlock()
+			x1.Unlock()
+		})
+		run(func() {
+			y2.Lock()
+			x2.Lock()
+			x2.Unlock()
+			y2.Unlock()
+		})
+	})
+
+	if reports := replayReports(t, trace, ReplayOptions{}); len(reports) != 0 {
+		t.Fatalf("expected no potential deadlock without ByClass, got %d", len(reports))
+	}
+	if reports := replayReports(t, trace, ReplayOptions{ByClass: true}); len(reports) != 1 {
+		t.Fatalf("expected 1 potential deadlock with ByClass, got %d", len(reports))
+	}
+}
+
+// The last line of a trace of a killed program can be incomplete. It is
+// skipped and the rest of the trace is analyzed.
+func TestReplayTruncated(t *testing.T) {
+	trace := recordTrace(t, traceInversion)
+	trace = bytes.TrimRight(trace, "\n")
+	trace = trace[:len(trace)-10]
+
+	reports := replayReports(t, trace, ReplayOptions{})
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 potential deadlock, got %d", len(reports))
+	}
+}
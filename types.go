@@ -0,0 +1,388 @@
+package deadlock
+
+/*
+Copyright (c) 2022, Erik Kassubek
+All rights reserved.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+/*
+Author: Erik Kassubek <erik-kassubek@t-online.de>
+Package: deadlock
+Project: Bachelor Project at the Albert-Ludwigs-University Freiburg,
+	Institute of Computer Science: Dynamic Deadlock Detection in Go
+*/
+
+/*
+types.go
+This file contains the exported types which are used by the detector as well
+as by the build with the tag deadlock_off, in which the detector is disabled.
+*/
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReportSchemaVersion is the version of the schema of the structured reports.
+// It is increased every time a field of the schema is changed or removed.
+const ReportSchemaVersion = 2
+
+// ReportKind describes what kind of finding a report represents
+type ReportKind string
+
+const (
+	// KindPotentialDeadlock is used for cyclic locking found by the
+	// comprehensive detection
+	KindPotentialDeadlock ReportKind = "potential-deadlock"
+	// KindDoubleLocking is used if a routine tried to acquire a lock it
+	// already holds
+	KindDoubleLocking ReportKind = "double-locking"
+	// KindDeadlock is used for cyclic locking found by the periodical
+	// detection, meaning the routines are actually in a deadlock
+	KindDeadlock ReportKind = "deadlock"
+	// KindLockWaitTimeout is used if a routine waited longer than the lock
+	// wait timeout for a lock
+	KindLockWaitTimeout ReportKind = "lock-wait-timeout"
+	// KindScheduleTimeout is used if the body of RunSchedule did not finish
+	// within the schedule timeout. The edges are the routines which wait for
+	// a lock at the timeout.
+	KindScheduleTimeout ReportKind = "schedule-timeout"
+)
+
+// Report is the structured representation of a single finding
+type Report struct {
+	// version of the schema, see ReportSchemaVersion
+	SchemaVersion int `json:"schemaVersion"`
+	// kind of the finding
+	Kind ReportKind `json:"kind"`
+	// all locks which are referenced by the edges
+	Locks []ReportLock `json:"locks"`
+	// edges of the lock graph which are involved in the finding
+	Edges []ReportEdge `json:"edges"`
+	// number of distinct combinations of routines in which the finding
+	// was found
+	Count int `json:"count"`
+	// signature of the finding, which is stable across runs of the program
+	Signature string `json:"signature,omitempty"`
+	// number of times the finding was found in earlier runs, only set if
+	// the history is enabled
+	SeenBefore int `json:"seenBefore,omitempty"`
+	// only for potential deadlocks: true if the deadlock was confirmed by
+	// driving the program into it with ConfirmPotentialDeadlocks
+	Confirmed bool `json:"confirmed,omitempty"`
+	// only for confirmed potential deadlocks: the actual deadlock which was
+	// observed, containing the interleaving of the routines
+	Confirmation *Report `json:"confirmation,omitempty"`
+	// only for potential deadlocks: true if the deadlock was found by the
+	// static analysis of the lock order in the package lockorder instead of
+	// the detector
+	Static bool `json:"static,omitempty"`
+}
+
+// ReportLock describes a lock which is involved in a finding
+type ReportLock struct {
+	// identity of the lock, unique while the program is running
+	ID string `json:"id"`
+	// "Mutex" or "RWMutex"
+	Type string `json:"type"`
+	// position where the lock was created
+	Created CallSite `json:"created"`
+}
+
+// ReportEdge describes a dependency of a routine, meaning the acquisition of
+// a lock while other locks were held by the same routine
+type ReportEdge struct {
+	// index of the routine which created the dependency
+	Routine int `json:"routine"`
+	// id of the go routine which created the dependency
+	Goroutine int64 `json:"goroutine"`
+	// position where the go routine was created, nil for the main routine
+	CreatedBy *CallSite `json:"createdBy,omitempty"`
+	// id of the lock which was acquired
+	Lock string `json:"lock"`
+	// true if the lock was acquired with RLock
+	RLock bool `json:"rLock"`
+	// position where Lock was acquired while the locks in Holding were held
+	Acquired CallSite `json:"acquired"`
+	// locks which were held while Lock was acquired
+	Holding []ReportHeldLock `json:"holding"`
+	// only for actual deadlocks and lock wait timeouts: ids of the go
+	// routines which hold Lock
+	HeldBy []int64 `json:"heldBy,omitempty"`
+	// only for actual deadlocks and lock wait timeouts: current call stack
+	// of the go routine
+	CurrentStack string `json:"currentStack,omitempty"`
+	// only for lock wait timeouts: go routines which hold Lock
+	Holders []ReportHolder `json:"holders,omitempty"`
+	// only for lock wait timeouts: time in milliseconds the go routine has
+	// been waiting for Lock
+	WaitingMs int64 `json:"waitingMs,omitempty"`
+}
+
+// ReportHolder describes a go routine which holds a lock another go routine
+// waits for
+type ReportHolder struct {
+	// id of the go routine
+	Goroutine int64 `json:"goroutine"`
+	// true if the lock is held as r-lock
+	RLock bool `json:"rLock"`
+	// position and call stack of the acquisition of the lock
+	Acquired CallSite `json:"acquired"`
+	// time in milliseconds the go routine has been holding the lock
+	HeldMs int64 `json:"heldMs"`
+	// current call stack of the go routine
+	CurrentStack string `json:"currentStack,omitempty"`
+}
+
+// ReportHeldLock describes a lock which was held while another lock was acquired
+type ReportHeldLock struct {
+	// id of the held lock
+	Lock string `json:"lock"`
+	// true if the lock was held with RLock
+	RLock bool `json:"rLock"`
+	// position where the lock was acquired
+	Acquired CallSite `json:"acquired"`
+}
+
+// CallSite describes a position in the code
+type CallSite struct {
+	// name of the function, only set for the creation of go routines
+	Function string `json:"function,omitempty"`
+	// name of the file with full path
+	File string `json:"file"`
+	// line in the file
+	Line int `json:"line"`
+	// call stack, only set if the collection of call stacks is enabled or
+	// for the holders of a lock wait timeout
+	Stack string `json:"stack,omitempty"`
+}
+
+// Policy describes how the detector reacts to a detected deadlock
+type Policy int
+
+const (
+	// PolicyExit runs the comprehensive detection and terminates the program
+	// with the exit code set by SetExitCode (default 2)
+	PolicyExit Policy = iota
+	// PolicyPanic raises a panic with a *DeadlockError. For double locking,
+	// the panic is raised in the routine which tried to acquire the lock and
+	// can be recovered. For local deadlocks, the panic is raised in the
+	// waiting routine which closes the cycle and can also be recovered.
+	PolicyPanic
+	// PolicyCallback calls the function set by SetDeadlockCallback and
+	// continues the program
+	PolicyCallback
+	// PolicyContinue only reports the deadlock and continues the program
+	PolicyContinue
+)
+
+// DeadlockError is the error for a detected deadlock. It is used as value of
+// the panic with PolicyPanic and passed to the callback with PolicyCallback.
+type DeadlockError struct {
+	// report of the deadlock
+	Report *Report
+	// only for RunSchedule: the schedule with which the deadlock was found
+	Schedule []byte
+}
+
+// Error returns a short description of the deadlock
+//  Returns:
+//   (string): the description
+func (e *DeadlockError) Error() string {
+	if e.Report == nil {
+		return "deadlock detected"
+	}
+
+	if e.Report.Kind == KindScheduleTimeout {
+		return "the schedule did not finish within the schedule timeout"
+	}
+	if len(e.Report.Edges) == 0 {
+		return "deadlock detected"
+	}
+
+	acquired := e.Report.Edges[0].Acquired
+	if e.Report.Kind == KindDoubleLocking {
+		return fmt.Sprintf("double locking at %s:%d", acquired.File, acquired.Line)
+	}
+	return fmt.Sprintf("deadlock of %d routines at %s:%d", len(e.Report.Edges),
+		acquired.File, acquired.Line)
+}
+
+// GraphFormat is the format in which the lock graph is written
+type GraphFormat int
+
+const (
+	// GraphDOT is the format used by Graphviz
+	GraphDOT GraphFormat = iota
+	// GraphJSON is a JSON object with the nodes and edges of the graph
+	GraphJSON
+)
+
+// TraceOp is the operation of a trace event
+type TraceOp string
+
+const (
+	// TraceCreate is the creation of a lock
+	TraceCreate TraceOp = "create"
+	// TraceLock is the acquisition of a lock with Lock
+	TraceLock TraceOp = "lock"
+	// TraceRLock is the acquisition of a lock with RLock
+	TraceRLock TraceOp = "rlock"
+	// TraceTryLock is the successful acquisition of a lock with TryLock
+	TraceTryLock TraceOp = "trylock"
+	// TraceTryRLock is the successful acquisition of a lock with RTryLock
+	TraceTryRLock TraceOp = "tryrlock"
+	// TraceTryLockFailed is a failed attempt to acquire a lock with TryLock
+	TraceTryLockFailed TraceOp = "trylockfailed"
+	// TraceTryRLockFailed is a failed attempt to acquire a lock with RTryLock
+	TraceTryRLockFailed TraceOp = "tryrlockfailed"
+	// TraceUnlock is the release of a lock with Unlock or RUnlock
+	TraceUnlock TraceOp = "unlock"
+)
+
+// TraceEvent is a single event of the trace
+type TraceEvent struct {
+	// operation of the event
+	Op TraceOp `json:"op"`
+	// id of the go routine which executed the operation
+	Goroutine int64 `json:"g"`
+	// identity of the lock, unique while the program is running
+	Lock string `json:"lock"`
+	// only for TraceCreate: "Mutex" or "RWMutex"
+	Type string `json:"type,omitempty"`
+	// time of the event in nanoseconds since the Unix epoch
+	Time int64 `json:"t"`
+	// program counter of the call site
+	PC uint64 `json:"pc"`
+	// file of the call site
+	File string `json:"file"`
+	// line of the call site
+	Line int `json:"line"`
+}
+
+// ConfirmOptions configures the confirmation of potential deadlocks
+type ConfirmOptions struct {
+	// time a routine sleeps after an outer acquisition of the cycle,
+	// 100ms if not set
+	Delay time.Duration
+	// maximum duration of a run of the workload, 5s if not set
+	Timeout time.Duration
+	// number of runs of the workload for each cycle, 3 if not set
+	Attempts int
+}
+
+// ReplayOptions are the settings for the replay of a trace
+type ReplayOptions struct {
+	// If ByClass is set to true, all locks which were created at the same
+	// position are treated as the same lock
+	ByClass bool
+	// If Filter is set, only locks which were created in a file matching
+	// Filter are analyzed, e.g. a *regexp.Regexp
+	Filter FileFilter
+}
+
+// FileFilter decides which files are analyzed by the replay of a trace. It is
+// implemented by *regexp.Regexp.
+type FileFilter interface {
+	// MatchString returns true if the file with the given path is analyzed
+	MatchString(path string) bool
+}
+
+// AcquisitionStats are the statistics of the acquisitions of a lock
+type AcquisitionStats struct {
+	// number of acquisitions
+	Acquisitions int64 `json:"acquisitions"`
+	// number of acquisitions, for which the routine had to wait for the lock
+	Contentions int64 `json:"contentions"`
+	// total time the routines waited for the lock
+	Wait time.Duration `json:"waitNs"`
+	// longest time a routine waited for the lock
+	MaxWait time.Duration `json:"maxWaitNs"`
+	// total time the lock was held
+	Hold time.Duration `json:"holdNs"`
+	// longest time the lock was held
+	MaxHold time.Duration `json:"maxHoldNs"`
+}
+
+// SiteStats are the statistics of the acquisitions of a lock at one
+// position in the code
+type SiteStats struct {
+	// position of the acquisition
+	Site CallSite `json:"site"`
+	AcquisitionStats
+}
+
+// LockStats are the statistics of the acquisitions of a lock
+type LockStats struct {
+	// the lock
+	Lock ReportLock `json:"lock"`
+	AcquisitionStats
+	// statistics by the position of the acquisition
+	Sites []SiteStats `json:"sites"`
+}
+
+// DetectorState is the current state of a detector, see Detector.State
+type DetectorState struct {
+	// routines known to the detector
+	Routines []RoutineState `json:"routines"`
+	// true if the waiting routines are tracked, which requires the periodic
+	// detection or the lock wait timeout
+	WaitsTracked bool `json:"waitsTracked"`
+	// routines which currently wait for a lock
+	Waits []WaitState `json:"waits"`
+	// number of dependencies of all routines
+	Dependencies int `json:"dependencies"`
+}
+
+// RoutineState is the state of a routine known to the detector
+type RoutineState struct {
+	// index of the routine
+	Routine int `json:"routine"`
+	// id of the go routine
+	Goroutine int64 `json:"goroutine"`
+	// position where the go routine was created, nil for the main routine
+	CreatedBy *CallSite `json:"createdBy,omitempty"`
+	// locks currently held by the routine in the order of their acquisition
+	Holding []HeldLockState `json:"holding"`
+	// number of dependencies in the lock tree of the routine
+	Dependencies int `json:"dependencies"`
+}
+
+// HeldLockState is a lock currently held by a routine
+type HeldLockState struct {
+	// the lock
+	Lock ReportLock `json:"lock"`
+	// true if the lock is held as r-lock
+	RLock bool `json:"rLock"`
+	// position where the lock was acquired
+	Acquired CallSite `json:"acquired"`
+}
+
+// WaitState is a routine currently waiting for a lock
+type WaitState struct {
+	// index of the routine, -1 if the routine is not tracked by the detection
+	Routine int `json:"routine"`
+	// id of the go routine
+	Goroutine int64 `json:"goroutine"`
+	// the lock the routine waits for
+	Lock ReportLock `json:"lock"`
+	// true if the routine waits for a r-lock
+	RLock bool `json:"rLock"`
+	// position where the lock is acquired
+	Acquired CallSite `json:"acquired"`
+	// time in milliseconds the routine has been waiting
+	WaitingMs int64 `json:"waitingMs"`
+	// ids of the go routines which hold the lock
+	HeldBy []int64 `json:"heldBy"`
+}